@@ -0,0 +1,325 @@
+package simpledb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// entryOverhead 估算大小时每条数据额外的开销，包括跳表节点和元信息
+const entryOverhead = 64
+
+// Stats db的运行统计
+type Stats struct {
+	// Entries 数据条数，包括已过期但还未清理的数据
+	Entries int
+	// Bytes 估算的数据大小，只在设置了容量限制时统计
+	Bytes int64
+	// Hits、Misses Get命中和未命中的次数
+	Hits   uint64
+	Misses uint64
+	// Evictions 超出容量被淘汰的次数
+	Evictions uint64
+}
+
+// cache 容量限制，记录每条数据的大小并在写入前按策略淘汰
+type cache struct {
+	mu         sync.Mutex
+	policy     policy
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	sizer      func(key, value interface{}) int64
+	hash       func(key interface{}) uint64
+	evictions  uint64
+	// volatile 只淘汰带有过期时间的数据，pinned、pinnedBytes 不会被淘汰的数据的条数和大小
+	volatile    bool
+	pinned      int
+	pinnedBytes int64
+}
+
+func newCache(conf Config) *cache {
+	c := &cache{
+		policy:     newPolicy(conf.policy, conf.maxEntries),
+		maxEntries: conf.maxEntries,
+		maxBytes:   conf.maxBytes,
+		sizer:      conf.sizer,
+		volatile:   conf.policy == PolicyVolatileTTL,
+	}
+	if conf.policy == PolicyTinyLFU {
+		c.hash = hashKey
+	}
+	return c
+}
+
+// put 写入key时更新元信息，覆盖时沿用旧的元信息
+func (c *cache) put(e *cacheEntry, key, value interface{}, expireAt int64) *cacheEntry {
+	var size int64
+	if c.maxBytes > 0 {
+		size = c.sizer(key, value)
+	}
+	c.mu.Lock()
+	if e == nil || e.removed {
+		e = &cacheEntry{key: key, size: size, expireAt: expireAt}
+		if c.hash != nil {
+			e.hash = c.hash(key)
+		}
+		c.policy.add(e)
+		c.bytes += size
+		c.pin(e, 1)
+		c.mu.Unlock()
+		return e
+	}
+	c.pin(e, -1)
+	c.bytes += size - e.size
+	e.key, e.size = key, size
+	if e.expireAt != expireAt {
+		// 过期时间影响volatile-ttl的采样范围，重新加入
+		c.policy.remove(e)
+		e.expireAt = expireAt
+		c.policy.add(e)
+	} else {
+		c.policy.access(e)
+	}
+	c.pin(e, 1)
+	c.mu.Unlock()
+	return e
+}
+
+// pin 按delta增减不会被淘汰的数据的统计，调用方需持有cache的锁
+func (c *cache) pin(e *cacheEntry, delta int) {
+	if c.volatile && e.expireAt == 0 {
+		c.pinned += delta
+		c.pinnedBytes += int64(delta) * e.size
+	}
+}
+
+// access 记录一次读取，数据可能已被并发移除
+func (c *cache) access(e *cacheEntry) {
+	if e == nil {
+		return
+	}
+	c.mu.Lock()
+	if !e.removed {
+		c.policy.access(e)
+	}
+	c.mu.Unlock()
+}
+
+func (c *cache) remove(e *cacheEntry) {
+	if e == nil {
+		return
+	}
+	c.mu.Lock()
+	if !e.removed {
+		c.policy.remove(e)
+		c.bytes -= e.size
+		c.pin(e, -1)
+		e.removed = true
+	}
+	c.mu.Unlock()
+}
+
+func (c *cache) victim(skip *cacheEntry) *cacheEntry {
+	c.mu.Lock()
+	e := c.policy.victim(skip)
+	c.mu.Unlock()
+	return e
+}
+
+func (c *cache) size() int64 {
+	c.mu.Lock()
+	size := c.bytes
+	c.mu.Unlock()
+	return size
+}
+
+// over 写入后的条数和大小是否超出限制
+func (c *cache) over(entries int, bytes int64) bool {
+	return (c.maxEntries > 0 && entries > c.maxEntries) || (c.maxBytes > 0 && bytes > c.maxBytes)
+}
+
+// makeRoom 写入key前按策略淘汰数据，直到写入后不超出限制，key自身不会被淘汰，调用方需持有写锁
+func (d *TypedDB[K, V]) makeRoom(key K, value V) error {
+	if d.cache == nil {
+		return nil
+	}
+	var size int64
+	if d.cache.maxBytes > 0 {
+		size = d.cache.sizer(key, value)
+	}
+	for {
+		var (
+			entries, bytes = d.data.Len() + 1, d.cache.size() + size
+			skip           *cacheEntry
+		)
+		if _, cur, err := d.data.Lookup(key); err == nil {
+			// 覆盖已有的key，条数不变，大小只计算差值
+			entries--
+			if cur.meta != nil {
+				bytes -= cur.meta.size
+			}
+			skip = cur.meta
+		}
+		if !d.cache.over(entries, bytes) {
+			return nil
+		}
+		if err := d.evictOne(skip); err != nil {
+			return err
+		}
+	}
+}
+
+// fits 淘汰所有可以淘汰的数据后key能否放下，只计算不淘汰，调用方需持有写锁
+func (d *TypedDB[K, V]) fits(key K, value V) bool {
+	if d.cache == nil {
+		return true
+	}
+	var size int64
+	if d.cache.maxBytes > 0 {
+		size = d.cache.sizer(key, value)
+	}
+	d.cache.mu.Lock()
+	entries, bytes := d.cache.pinned+1, d.cache.pinnedBytes+size
+	if _, cur, err := d.data.Lookup(key); err == nil && cur.meta != nil && d.cache.volatile && cur.meta.expireAt == 0 {
+		// 覆盖不会被淘汰的key，只计算差值
+		entries--
+		bytes -= cur.meta.size
+	}
+	d.cache.mu.Unlock()
+	return !d.cache.over(entries, bytes)
+}
+
+// shrink 回放日志后淘汰数据直到不超出限制，重新打开时可能调小了限制，
+// 没有可以淘汰的数据时保留剩余的数据，调用方需持有写锁
+func (d *TypedDB[K, V]) shrink() error {
+	if d.cache == nil {
+		return nil
+	}
+	for d.cache.over(d.data.Len(), d.cache.size()) {
+		if err := d.evictOne(nil); err != nil {
+			if err == errors.ErrOutOfCapacity {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// evictOne 按策略淘汰一条skip以外的数据，调用方需持有写锁
+func (d *TypedDB[K, V]) evictOne(skip *cacheEntry) error {
+	e := d.cache.victim(skip)
+	if e == nil {
+		return errors.ErrOutOfCapacity
+	}
+	stored := e.key.(K)
+	if d.log != nil {
+		rec, err := d.deleteRecord(stored)
+		if err != nil {
+			return err
+		}
+		if err = d.log.Append(rec); err != nil {
+			return err
+		}
+	}
+	if err := d.delete(stored, EvictCapacity); err != nil {
+		// 元信息与数据不一致时直接丢弃，避免一直选中同一条数据，不计入淘汰次数
+		d.cache.remove(e)
+		return nil
+	}
+	atomic.AddUint64(&d.cache.evictions, 1)
+	return nil
+}
+
+// Stats 返回db的运行统计
+func (d *TypedDB[K, V]) Stats() Stats {
+	s := Stats{
+		Entries: d.data.Len(),
+		Hits:    atomic.LoadUint64(&d.hits),
+		Misses:  atomic.LoadUint64(&d.misses),
+	}
+	if d.cache != nil {
+		s.Bytes = d.cache.size()
+		s.Evictions = atomic.LoadUint64(&d.cache.evictions)
+	}
+	return s
+}
+
+// Stats 返回db的运行统计
+func (d *DB) Stats() Stats {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.Stats()
+}
+
+// hashKey W-TinyLFU统计访问频率时使用的key的哈希
+func hashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	switch k := key.(type) {
+	case string:
+		_, _ = h.Write([]byte(k))
+	case CustomKey:
+		_, _ = h.Write([]byte(k.Key()))
+	default:
+		_, _ = fmt.Fprint(h, key)
+	}
+	return h.Sum64()
+}
+
+// EstimateSize 默认的大小估算，按key和value的实际内容递归累加，再加上每条数据固定的开销
+func EstimateSize(key, value interface{}) int64 {
+	return entryOverhead + sizeOf(reflect.ValueOf(key), 0) + sizeOf(reflect.ValueOf(value), 0)
+}
+
+// maxSizeDepth 估算嵌套结构时的最大深度，避免循环引用
+const maxSizeDepth = 8
+
+func sizeOf(v reflect.Value, depth int) int64 {
+	if !v.IsValid() || depth > maxSizeDepth {
+		return 0
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return 16 + int64(v.Len())
+	case reflect.Slice:
+		size := int64(24)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return size + int64(v.Len())
+		}
+		for i := 0; i < v.Len(); i++ {
+			size += sizeOf(v.Index(i), depth+1)
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		for i := 0; i < v.Len(); i++ {
+			size += sizeOf(v.Index(i), depth+1)
+		}
+		return size
+	case reflect.Map:
+		size := int64(48)
+		iter := v.MapRange()
+		for iter.Next() {
+			size += sizeOf(iter.Key(), depth+1) + sizeOf(iter.Value(), depth+1)
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += sizeOf(v.Field(i), depth+1)
+		}
+		return size
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 8
+		}
+		return 8 + sizeOf(v.Elem(), depth+1)
+	}
+	return int64(v.Type().Size())
+}
@@ -0,0 +1,226 @@
+package simpledb
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_MaxEntries(t *testing.T) {
+	var evicted []interface{}
+	db := NewDB(DBOptionMaxEntries(3), DBOptionOnEvict(func(key, value interface{}, reason EvictReason) {
+		if reason == EvictCapacity {
+			evicted = append(evicted, key)
+		}
+	}))
+	for i := 1; i <= 3; i++ {
+		_ = db.Save(strconv.Itoa(i), i)
+	}
+	// 访问1之后，2成为最久未访问的数据
+	_, _ = db.Get("1")
+	_ = db.Save("4", 4)
+	if _, err := db.Get("2"); err != errors.ErrNotFound {
+		t.Errorf("max entries failed, 2 should be evicted, err: %+v", err)
+		return
+	}
+	// 覆盖已有的key不淘汰数据
+	_ = db.Save("4", 40)
+	if n, _ := db.Count(); n != 3 {
+		t.Errorf("max entries failed, count(%d) should be 3", n)
+		return
+	}
+	// 淘汰后按key的顺序遍历不受影响
+	list, _, _ := db.List(1, 10)
+	if len(list) != 3 || list[0] != 1 || list[1] != 3 || list[2] != 40 {
+		t.Errorf("list failed, list(%+v) should be [1 3 40]", list)
+		return
+	}
+	s := db.Stats()
+	if s.Entries != 3 || s.Evictions != 1 || s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("stats failed, stats: %+v", s)
+		return
+	}
+	_ = db.Close()
+	if len(evicted) != 1 || evicted[0] != "2" {
+		t.Errorf("on evict failed, evicted(%+v) should be [2]", evicted)
+	}
+}
+
+func TestDB_MaxBytes(t *testing.T) {
+	sizer := func(key, value interface{}) int64 {
+		return int64(len(value.(string)))
+	}
+	db := NewDB(DBOptionMaxBytes(10), DBOptionSizer(sizer))
+	_ = db.Save("1", "aaaa")
+	_ = db.Save("2", "bbbb")
+	_ = db.Save("3", "cc")
+	if s := db.Stats(); s.Bytes != 10 || s.Entries != 3 {
+		t.Errorf("max bytes failed, stats: %+v", s)
+		return
+	}
+	// 覆盖时只计算差值，需要淘汰1
+	_ = db.Save("3", "cccccc")
+	if _, err := db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("max bytes failed, 1 should be evicted, err: %+v", err)
+		return
+	}
+	if s := db.Stats(); s.Bytes != 10 || s.Evictions != 1 {
+		t.Errorf("max bytes failed, stats: %+v", s)
+		return
+	}
+	_ = db.Delete("2")
+	if s := db.Stats(); s.Bytes != 6 {
+		t.Errorf("delete failed, bytes(%d) should be 6", s.Bytes)
+		return
+	}
+	if err := db.Save("4", "ddddddddddd"); err != errors.ErrOutOfCapacity {
+		t.Errorf("max bytes failed, err(%+v) should be ErrOutOfCapacity", err)
+		return
+	}
+	// 3最久未访问，但正在写入的key不会被淘汰
+	_ = db.Save("3", "ccccc")
+	_ = db.Save("5", "e")
+	if err := db.Save("3", "cccccccccc"); err != nil {
+		t.Errorf("max bytes failed, err: %+v", err)
+		return
+	}
+	if v, _ := db.Get("3"); v != "cccccccccc" {
+		t.Errorf("max bytes failed, v(%v) of 3 should be kept", v)
+		return
+	}
+	if s := db.Stats(); s.Bytes != 10 || s.Entries != 1 || s.Evictions != 3 {
+		t.Errorf("max bytes failed, stats: %+v", s)
+	}
+}
+
+func TestDB_VolatileTTL(t *testing.T) {
+	db := NewDB(DBOptionWithExpired(), DBOptionMaxEntries(2), DBOptionEvictionPolicy(PolicyVolatileTTL))
+	_ = db.Save("1", 1)
+	_ = db.Save("2", 2, SaveOptionTTLDuration(time.Hour))
+	_ = db.Save("3", 3)
+	if _, err := db.Get("2"); err != errors.ErrNotFound {
+		t.Errorf("volatile ttl failed, 2 should be evicted, err: %+v", err)
+		return
+	}
+	// 没有带过期时间的数据可以淘汰
+	if err := db.Save("4", 4); err != errors.ErrOutOfCapacity {
+		t.Errorf("volatile ttl failed, err(%+v) should be ErrOutOfCapacity", err)
+		return
+	}
+	_ = db.Expire("1", time.Hour)
+	if err := db.Save("4", 4); err != nil {
+		t.Errorf("volatile ttl failed, err: %+v", err)
+		return
+	}
+	if _, err := db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("volatile ttl failed, 1 should be evicted, err: %+v", err)
+	}
+}
+
+func TestDB_MaxEntriesTx(t *testing.T) {
+	db := NewDB(DBOptionMaxEntries(2))
+	err := db.Update(func(tx *Tx) error {
+		for i := 1; i <= 3; i++ {
+			if err := tx.Save(strconv.Itoa(i), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("update failed, err: %+v", err)
+		return
+	}
+	if s := db.Stats(); s.Entries != 2 || s.Evictions != 1 {
+		t.Errorf("max entries failed, stats: %+v", s)
+	}
+}
+
+func TestDB_MaxBytesTxOutOfCapacity(t *testing.T) {
+	db := NewDB(DBOptionMaxBytes(10), DBOptionSizer(func(key, value interface{}) int64 {
+		return int64(value.(int))
+	}))
+	_ = db.Save("1", 5)
+	// 3放不下，事务失败时不会淘汰任何数据
+	err := db.Update(func(tx *Tx) error {
+		_ = tx.Save("2", 4)
+		return tx.Save("3", 20)
+	})
+	if err != errors.ErrOutOfCapacity {
+		t.Errorf("update failed, err(%+v) should be ErrOutOfCapacity", err)
+		return
+	}
+	if v, err := db.Get("1"); err != nil || v != 5 {
+		t.Errorf("max bytes failed, 1 should not be evicted, err: %+v", err)
+		return
+	}
+	if s := db.Stats(); s.Entries != 1 || s.Evictions != 0 {
+		t.Errorf("max bytes failed, stats: %+v", s)
+		return
+	}
+	// volatile-ttl下不带过期时间的数据不能被淘汰
+	db = NewDB(DBOptionWithExpired(), DBOptionMaxEntries(1), DBOptionEvictionPolicy(PolicyVolatileTTL))
+	_ = db.Save("1", 1)
+	if err = db.Update(func(tx *Tx) error {
+		return tx.Save("2", 2)
+	}); err != errors.ErrOutOfCapacity {
+		t.Errorf("update failed, err(%+v) should be ErrOutOfCapacity", err)
+	}
+}
+
+func TestDBWithWAL_MaxEntries(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB(DBOptionWithWAL(dir), DBOptionMaxEntries(2))
+	for i := 1; i <= 3; i++ {
+		_ = db.Save(strconv.Itoa(i), i)
+	}
+	_ = db.Close()
+
+	// 淘汰记录在日志中，重启后不会恢复
+	db = NewDB(DBOptionWithWAL(dir), DBOptionMaxEntries(2))
+	defer db.Close()
+	if _, err := db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("reopen failed, 1 should be evicted, err: %+v", err)
+		return
+	}
+	if n, _ := db.Count(); n != 2 {
+		t.Errorf("reopen failed, count(%d) should be 2", n)
+		return
+	}
+	_ = db.Close()
+
+	// 调小限制后重新打开，回放后淘汰多出的数据
+	db = NewDB(DBOptionWithWAL(dir), DBOptionMaxEntries(1))
+	if s := db.Stats(); s.Entries != 1 || s.Evictions != 1 {
+		t.Errorf("reopen failed, stats(%+v) should have 1 entry", s)
+		return
+	}
+	_ = db.Close()
+	db = NewDB(DBOptionWithWAL(dir), DBOptionMaxEntries(2))
+	if n, _ := db.Count(); n != 1 {
+		t.Errorf("reopen failed, count(%d) should be 1", n)
+	}
+}
+
+func TestDB_CapacityWithLSM(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	if _, err := OpenDB(DBOptionWithLSM(dir), DBOptionMaxEntries(2)); err != errors.ErrCapacityWithLSM {
+		t.Errorf("open failed, err(%+v) should be ErrCapacityWithLSM", err)
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	type user struct {
+		Name string
+		Tags []string
+	}
+	small := EstimateSize("k", user{Name: "a"})
+	large := EstimateSize("k", user{Name: "a", Tags: []string{"x", "y"}})
+	if small <= entryOverhead || large <= small {
+		t.Errorf("estimate size failed, small: %d, large: %d", small, large)
+	}
+}
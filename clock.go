@@ -0,0 +1,50 @@
+package simpledb
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock db中所有与时间相关的操作都通过它完成，包括过期时间的计算和后台清理，
+// 测试时可以替换为clocktest.Fake，手动推进时间
+type Clock interface {
+	Now() time.Time
+	// Every 每隔d调用一次fn，返回的stop会停止调用并等待正在执行的fn结束
+	Every(d time.Duration, fn func()) (stop func())
+}
+
+// realClock 使用系统时间
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Every(d time.Duration, fn func()) func() {
+	var (
+		t    = time.NewTicker(d)
+		stop = make(chan struct{})
+		done = make(chan struct{})
+		once sync.Once
+	)
+	go func() {
+		defer func() {
+			t.Stop()
+			close(done)
+		}()
+		for {
+			select {
+			case <-t.C:
+				fn()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Package clocktest 提供手动推进的时钟，用于测试与过期时间相关的逻辑
+package clocktest
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake 手动推进的时钟，实现了simpledb.Clock，
+// Advance时到期的定时任务在调用方的协程中同步执行
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+type timer struct {
+	next     time.Time
+	interval time.Duration
+	fn       func()
+	// running 正在执行的fn，stop时等待其结束
+	running sync.WaitGroup
+}
+
+// NewFake 创建时间为now的时钟
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	now := f.now
+	f.mu.Unlock()
+	return now
+}
+
+func (f *Fake) Every(d time.Duration, fn func()) func() {
+	f.mu.Lock()
+	t := &timer{next: f.now.Add(d), interval: d, fn: fn}
+	f.timers = append(f.timers, t)
+	f.mu.Unlock()
+	return func() {
+		f.mu.Lock()
+		for i, cur := range f.timers {
+			if cur == t {
+				f.timers = append(f.timers[:i], f.timers[i+1:]...)
+				break
+			}
+		}
+		f.mu.Unlock()
+		t.running.Wait()
+	}
+}
+
+// Advance 将时间推进d，期间到期的定时任务按到期的顺序执行，执行时Now返回其到期时间
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	for {
+		var due *timer
+		for _, t := range f.timers {
+			if !t.next.After(end) && (due == nil || t.next.Before(due.next)) {
+				due = t
+			}
+		}
+		if due == nil {
+			break
+		}
+		f.now = due.next
+		due.next = due.next.Add(due.interval)
+		// 持锁时标记为执行中，之后的stop一定会等到fn结束
+		due.running.Add(1)
+		f.mu.Unlock()
+		due.fn()
+		due.running.Done()
+		f.mu.Lock()
+	}
+	f.now = end
+	f.mu.Unlock()
+}
@@ -0,0 +1,60 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Advance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	var fired []time.Time
+	stop := f.Every(time.Second, func() {
+		fired = append(fired, f.Now())
+	})
+	f.Advance(2500 * time.Millisecond)
+	if len(fired) != 2 || !fired[0].Equal(start.Add(time.Second)) || !fired[1].Equal(start.Add(2*time.Second)) {
+		t.Errorf("advance failed, fired(%+v) should be at 1s and 2s", fired)
+		return
+	}
+	if now := f.Now(); !now.Equal(start.Add(2500 * time.Millisecond)) {
+		t.Errorf("advance failed, now(%s) should be 2.5s after start", now)
+		return
+	}
+	stop()
+	f.Advance(time.Hour)
+	if len(fired) != 2 {
+		t.Errorf("stop failed, len(%d) should be 2", len(fired))
+	}
+}
+
+func TestFake_StopWaitsForFn(t *testing.T) {
+	f := NewFake(time.Unix(1000, 0))
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+		stopped = make(chan struct{})
+	)
+	stop := f.Every(time.Second, func() {
+		close(started)
+		<-release
+	})
+	go f.Advance(time.Second)
+	<-started
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Errorf("stop failed, stop should wait for the running fn")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("stop failed, stop should return after fn ends")
+	}
+}
@@ -0,0 +1,58 @@
+// simpledb-server 通过RESP协议对外提供simpledb，可以使用redis的客户端访问，例如:
+//
+//	simpledb-server -addr 127.0.0.1:6380 -wal ./data
+//	simpledb-server -network unix -addr /tmp/simpledb.sock
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/byronzhu-haha/simpledb"
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/server"
+)
+
+func main() {
+	var (
+		network = flag.String("network", "tcp", "tcp or unix")
+		addr    = flag.String("addr", "127.0.0.1:6380", "address to listen on, or the socket path for unix")
+		walDir  = flag.String("wal", "", "directory of the write-ahead log, empty for memory only")
+		lsmDir  = flag.String("lsm", "", "directory of the lsm storage, empty for memory only")
+	)
+	flag.Parse()
+
+	opts := []simpledb.DBOption{simpledb.DBOptionWithExpired()}
+	if *walDir != "" {
+		opts = append(opts, simpledb.DBOptionWithWAL(*walDir))
+	}
+	if *lsmDir != "" {
+		opts = append(opts, simpledb.DBOptionWithLSM(*lsmDir))
+	}
+	db, err := simpledb.OpenDB(opts...)
+	if err != nil {
+		log.Fatalf("open db failed, err: %+v", err)
+	}
+	if *network == "unix" {
+		// 上次未正常退出时留下的socket文件
+		_ = os.Remove(*addr)
+	}
+
+	srv := server.New(db)
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		<-ch
+		_ = srv.Close()
+	}()
+	log.Printf("simpledb-server listening on %s %s", *network, *addr)
+	if err = srv.ListenAndServe(*network, *addr); err != errors.ErrServerClosed {
+		log.Printf("serve failed, err: %+v", err)
+	}
+	if err = db.Close(); err != nil {
+		log.Fatalf("close db failed, err: %+v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package simpledb
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Codec 值(以及CustomKey)的编解码器，持久化时使用
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// gobCodec 默认的编解码器，自定义类型需要先调用gob.Register注册
+type gobCodec struct{}
+
+type gobValue struct {
+	V interface{}
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&gobValue{V: v}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte) (interface{}, error) {
+	var v gobValue
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v.V, nil
+}
@@ -1,7 +1,35 @@
 package simpledb
 
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/wal"
+)
+
 type Config struct {
-	withExpired bool
+	withExpired    bool
+	walDir         string
+	walOptions     wal.Options
+	codec          Codec
+	compactRatio   float64
+	compactMinSize int64
+	lsmDir         string
+	memtableSize   int64
+	expireLimit    int
+	deleteOnRead   bool
+	clock          Clock
+	onEvict        func(key, value interface{}, reason EvictReason)
+	evictWorkers   int
+	evictQueueSize int
+	evictDrop      bool
+	maxEntries     int
+	maxBytes       int64
+	sizer          func(key, value interface{}) int64
+	policy         EvictionPolicy
+	refresh        func(ctx context.Context, key interface{}) (interface{}, time.Duration, error)
+	refreshAhead   float64
 }
 
 type DBOption func(o Config) Config
@@ -13,20 +41,331 @@ func DBOptionWithExpired() DBOption {
 	}
 }
 
+// DBOptionWithWAL 开启预写日志，所有的写操作都会追加到dir下的日志文件中，
+// 创建db时会回放日志恢复数据
+func DBOptionWithWAL(dir string) DBOption {
+	return func(o Config) Config {
+		o.walDir = dir
+		return o
+	}
+}
+
+// DBOptionWALSyncAlways 每次写操作都刷盘
+func DBOptionWALSyncAlways() DBOption {
+	return func(o Config) Config {
+		o.walOptions.Sync = wal.SyncAlways
+		return o
+	}
+}
+
+// DBOptionWALSyncEvery 每隔interval刷盘一次，默认每秒一次
+func DBOptionWALSyncEvery(interval time.Duration) DBOption {
+	return func(o Config) Config {
+		o.walOptions.Sync = wal.SyncEvery
+		o.walOptions.SyncInterval = interval
+		return o
+	}
+}
+
+// DBOptionWALSyncNever 从不主动刷盘，由操作系统决定
+func DBOptionWALSyncNever() DBOption {
+	return func(o Config) Config {
+		o.walOptions.Sync = wal.SyncNever
+		return o
+	}
+}
+
+// DBOptionWithCodec 设置持久化时value和CustomKey的编解码器，默认使用gob
+func DBOptionWithCodec(codec Codec) DBOption {
+	return func(o Config) Config {
+		o.codec = codec
+		return o
+	}
+}
+
+// DBOptionCompactRatio 日志大小达到上次压缩后的ratio倍时自动压缩，默认为2，小于等于0时不自动压缩
+func DBOptionCompactRatio(ratio float64) DBOption {
+	return func(o Config) Config {
+		o.compactRatio = ratio
+		return o
+	}
+}
+
+// DBOptionCompactMinSize 日志小于size时不自动压缩，默认为16MB
+func DBOptionCompactMinSize(size int64) DBOption {
+	return func(o Config) Config {
+		o.compactMinSize = size
+		return o
+	}
+}
+
+// DBOptionWithLSM 使用dir下的LSM树存储数据，跳表作为memtable，写满后落盘，
+// 适用于数据量超过内存的场景，LSM树自身带有日志，此时DBOptionWithWAL不再生效
+func DBOptionWithLSM(dir string) DBOption {
+	return func(o Config) Config {
+		o.lsmDir = dir
+		return o
+	}
+}
+
+// DBOptionMemtableSize memtable达到size后写入磁盘，默认为4MB
+func DBOptionMemtableSize(size int64) DBOption {
+	return func(o Config) Config {
+		o.memtableSize = size
+		return o
+	}
+}
+
+// DBOptionExpireLimit 后台每秒最多清理的过期数据，超出的部分留到下一秒，默认为10000
+func DBOptionExpireLimit(limit int) DBOption {
+	return func(o Config) Config {
+		if limit > 0 {
+			o.expireLimit = limit
+		}
+		return o
+	}
+}
+
+// DBOptionDeleteExpiredOnRead Get读到已过期的数据时立即删除，而不是等待后台清理
+func DBOptionDeleteExpiredOnRead() DBOption {
+	return func(o Config) Config {
+		o.deleteOnRead = true
+		return o
+	}
+}
+
+// DBOptionClock 使用指定的时钟，默认为系统时间
+func DBOptionClock(clock Clock) DBOption {
+	return func(o Config) Config {
+		if clock != nil {
+			o.clock = clock
+		}
+		return o
+	}
+}
+
+// DBOptionOnEvict 数据过期、被删除、被覆盖或者超出容量被淘汰时调用fn，
+// fn在db的锁之外由协程池执行，可以在其中读取db，在其中写入db时若队列已满会一直阻塞，
+// 此时应开启DBOptionEvictDropWhenFull
+func DBOptionOnEvict(fn func(key, value interface{}, reason EvictReason)) DBOption {
+	return func(o Config) Config {
+		o.onEvict = fn
+		return o
+	}
+}
+
+// DBOptionEvictWorkers 执行移除回调的协程数，默认为1，大于1时回调的顺序不再确定
+func DBOptionEvictWorkers(n int) DBOption {
+	return func(o Config) Config {
+		if n > 0 {
+			o.evictWorkers = n
+		}
+		return o
+	}
+}
+
+// DBOptionEvictQueueSize 等待回调的队列长度，默认为1024，队列满时写入方会阻塞
+func DBOptionEvictQueueSize(size int) DBOption {
+	return func(o Config) Config {
+		if size >= 0 {
+			o.evictQueueSize = size
+		}
+		return o
+	}
+}
+
+// DBOptionEvictDropWhenFull 队列满时丢弃事件，而不是阻塞写入方
+func DBOptionEvictDropWhenFull() DBOption {
+	return func(o Config) Config {
+		o.evictDrop = true
+		return o
+	}
+}
+
+// DBOptionMaxEntries 最多保存n条数据，超出时按淘汰策略移除数据，不支持与DBOptionWithLSM同时使用
+func DBOptionMaxEntries(n int) DBOption {
+	return func(o Config) Config {
+		if n > 0 {
+			o.maxEntries = n
+		}
+		return o
+	}
+}
+
+// DBOptionMaxBytes 数据估算的大小最多为n字节，超出时按淘汰策略移除数据，不支持与DBOptionWithLSM同时使用
+func DBOptionMaxBytes(n int64) DBOption {
+	return func(o Config) Config {
+		if n > 0 {
+			o.maxBytes = n
+		}
+		return o
+	}
+}
+
+// DBOptionSizer 估算每条数据大小的方法，默认为EstimateSize
+func DBOptionSizer(fn func(key, value interface{}) int64) DBOption {
+	return func(o Config) Config {
+		if fn != nil {
+			o.sizer = fn
+		}
+		return o
+	}
+}
+
+// DBOptionEvictionPolicy 超出容量时的淘汰策略，默认为PolicyLRU
+func DBOptionEvictionPolicy(p EvictionPolicy) DBOption {
+	return func(o Config) Config {
+		o.policy = p
+		return o
+	}
+}
+
+// DBOptionRefresh 注册刷新数据的方法，读到软过期的数据时仍返回旧值，同时在后台调用fn刷新，
+// fn返回的ttl为新的过期时间，不大于0时不过期，同一个key同时只有一次刷新，与GetOrLoad共享加载
+func DBOptionRefresh(fn func(ctx context.Context, key interface{}) (interface{}, time.Duration, error)) DBOption {
+	return func(o Config) Config {
+		o.refresh = fn
+		return o
+	}
+}
+
+// DBOptionRefreshAhead 未指定软过期时间的数据在经过ttl的fraction倍之后视为软过期，fraction需在0到1之间，
+// 配合DBOptionRefresh在过期前刷新热点数据
+func DBOptionRefreshAhead(fraction float64) DBOption {
+	return func(o Config) Config {
+		if fraction > 0 && fraction < 1 {
+			o.refreshAhead = fraction
+		}
+		return o
+	}
+}
+
 type SaveOptions struct {
 	isExpired bool
-	ttl       int64
+	ttl       time.Duration
+	deadline  time.Time
+	softTTL   time.Duration
+	sliding   bool
 }
 
 type SaveOption func(o SaveOptions) SaveOptions
 
+// maxTTLSeconds 能用time.Duration表示的最大秒数
+const maxTTLSeconds = math.MaxInt64 / int64(time.Second)
+
+// SaveOptionTTL 过期时间，单位为秒，超出time.Duration范围时按最大值处理
 func SaveOptionTTL(ttl int64) SaveOption {
+	if ttl > maxTTLSeconds {
+		ttl = maxTTLSeconds
+	}
+	return SaveOptionTTLDuration(time.Duration(ttl) * time.Second)
+}
+
+// SaveOptionTTLDuration 过期时间，精确到毫秒
+func SaveOptionTTLDuration(ttl time.Duration) SaveOption {
 	return func(o SaveOptions) SaveOptions {
 		if ttl <= 0 {
 			return o
 		}
 		o.isExpired = true
 		o.ttl = ttl
+		o.deadline = time.Time{}
+		o.sliding = false
+		return o
+	}
+}
+
+// SaveOptionExpireAt 在t时过期，精确到毫秒，t已经过去时数据保存后立即过期
+func SaveOptionExpireAt(t time.Time) SaveOption {
+	return func(o SaveOptions) SaveOptions {
+		if t.IsZero() {
+			return o
+		}
+		o.isExpired = true
+		o.deadline = t
+		o.sliding = false
+		return o
+	}
+}
+
+// SaveOptionSlidingTTL 滑动过期，每次Get命中后过期时间重置为ttl之后，
+// 续期只在内存中生效，日志中记录的是写入时的过期时间，压缩日志和快照时才保存续期后的时间
+func SaveOptionSlidingTTL(ttl time.Duration) SaveOption {
+	return func(o SaveOptions) SaveOptions {
+		if ttl <= 0 {
+			return o
+		}
+		o.isExpired = true
+		o.ttl = ttl
+		o.deadline = time.Time{}
+		o.sliding = true
+		return o
+	}
+}
+
+// SaveOptionSoftTTL 软过期时间，需同时指定过期时间，之后到过期前读取仍返回旧值，并通过DBOptionRefresh注册的方法在后台刷新
+func SaveOptionSoftTTL(ttl time.Duration) SaveOption {
+	return func(o SaveOptions) SaveOptions {
+		if ttl > 0 {
+			o.softTTL = ttl
+		}
+		return o
+	}
+}
+
+// LoadOptions GetOrLoad的选项
+type LoadOptions struct {
+	negativeTTL time.Duration
+}
+
+type LoadOption func(o LoadOptions) LoadOptions
+
+// LoadOptionNegativeTTL 加载失败时缓存错误ttl，期间同一个key的GetOrLoad直接返回该错误，默认不缓存
+func LoadOptionNegativeTTL(ttl time.Duration) LoadOption {
+	return func(o LoadOptions) LoadOptions {
+		o.negativeTTL = ttl
+		return o
+	}
+}
+
+// RangeOptions 范围查询的选项，默认包含起点、不包含终点、不限数量、按升序返回
+type RangeOptions struct {
+	excludeStart bool
+	includeEnd   bool
+	limit        int
+	reverse      bool
+}
+
+type RangeOption func(o RangeOptions) RangeOptions
+
+// RangeOptionExcludeStart 不包含起点
+func RangeOptionExcludeStart() RangeOption {
+	return func(o RangeOptions) RangeOptions {
+		o.excludeStart = true
+		return o
+	}
+}
+
+// RangeOptionIncludeEnd 包含终点
+func RangeOptionIncludeEnd() RangeOption {
+	return func(o RangeOptions) RangeOptions {
+		o.includeEnd = true
+		return o
+	}
+}
+
+// RangeOptionLimit 最多返回limit条数据，小于等于0时不限制
+func RangeOptionLimit(limit int) RangeOption {
+	return func(o RangeOptions) RangeOptions {
+		o.limit = limit
+		return o
+	}
+}
+
+// RangeOptionReverse 从终点向起点按降序返回
+func RangeOptionReverse() RangeOption {
+	return func(o RangeOptions) RangeOptions {
+		o.reverse = true
 		return o
 	}
 }
@@ -0,0 +1,83 @@
+package simpledb
+
+import (
+	"encoding/base64"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// ListAfter 返回cursor之后最多limit条满足所有查询函数的数据，以及下一页的游标，cursor为空时从头开始，
+// 返回的游标为空表示没有更多数据，limit不大于0时返回剩余的所有数据。游标中保存的是本页最后一个key，
+// 下一页通过跳表寻址直接定位，期间写入或删除的数据不会导致跳过或重复返回已有的数据，
+// key不是string时使用codec编码，需要codec能够编码key的类型
+func (d *TypedDB[K, V]) ListAfter(cursor string, limit int, queries ...func(v V) bool) ([]V, string, error) {
+	if !d.life.enter() {
+		return nil, "", errors.ErrClosed
+	}
+	defer d.life.leave()
+	var (
+		start *K
+		o     = RangeOptions{excludeStart: true}
+	)
+	if cursor != "" {
+		key, err := d.decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = &key
+	}
+	var (
+		ret     []V
+		last    K
+		hasNext bool
+	)
+	d.scanKeys(start, nil, o, func(key K, v V) bool {
+		if any(v) == nil || !match(v, queries) {
+			return true
+		}
+		// 多取一条判断是否还有下一页
+		if limit > 0 && len(ret) >= limit {
+			hasNext = true
+			return false
+		}
+		ret = append(ret, v)
+		last = key
+		return true
+	})
+	if !hasNext {
+		return ret, "", nil
+	}
+	next, err := d.encodeCursor(last)
+	if err != nil {
+		return nil, "", err
+	}
+	return ret, next, nil
+}
+
+func (d *TypedDB[K, V]) encodeCursor(key K) (string, error) {
+	raw, err := d.encodeKey(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func (d *TypedDB[K, V]) decodeCursor(cursor string) (key K, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return key, errors.ErrInvalidCursor
+	}
+	if key, err = d.decodeKey(raw); err != nil || any(key) == nil {
+		return key, errors.ErrInvalidCursor
+	}
+	return key, nil
+}
+
+// ListAfter 返回cursor之后最多limit条满足所有查询函数的数据，以及下一页的游标，
+// cursor为空时从头开始，返回的游标为空表示没有更多数据，limit不大于0时返回剩余的所有数据
+func (d *DB) ListAfter(cursor string, limit int, queries ...Query) ([]interface{}, string, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.ListAfter(cursor, limit, funcs(queries)...)
+}
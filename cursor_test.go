@@ -0,0 +1,75 @@
+package simpledb
+
+import (
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_ListAfter(t *testing.T) {
+	db := NewDB()
+	for _, key := range []string{"a", "c", "e", "g", "i"} {
+		_ = db.Save(key, key)
+	}
+	list, cursor, err := db.ListAfter("", 2)
+	if err != nil {
+		t.Errorf("list after failed, err: %+v", err)
+		return
+	}
+	if len(list) != 2 || list[0] != "a" || list[1] != "c" || cursor == "" {
+		t.Errorf("list after failed, list(%v) should be [a c] with cursor", list)
+		return
+	}
+	// 翻页期间的写入和删除不会导致跳过或重复
+	_ = db.Save("b", "b")
+	_ = db.Save("d", "d")
+	_ = db.Delete("c")
+	list, cursor, _ = db.ListAfter(cursor, 2)
+	if len(list) != 2 || list[0] != "d" || list[1] != "e" || cursor == "" {
+		t.Errorf("list after failed, list(%v) should be [d e] with cursor", list)
+		return
+	}
+	list, cursor, _ = db.ListAfter(cursor, 2, func(v interface{}) bool {
+		return v != "g"
+	})
+	if len(list) != 1 || list[0] != "i" || cursor != "" {
+		t.Errorf("list after failed, list(%v) should be [i] without cursor", list)
+		return
+	}
+	if _, _, err = db.ListAfter("!", 2); err != errors.ErrInvalidCursor {
+		t.Errorf("list after failed, err(%+v) should be ErrInvalidCursor", err)
+		return
+	}
+}
+
+func TestTypedDB_ListAfter(t *testing.T) {
+	db, err := OpenTypedDB[int, int](func(a, b int) int { return a - b })
+	if err != nil {
+		t.Errorf("open typed db failed, err: %+v", err)
+		return
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		_ = db.Save(i, i)
+	}
+	var (
+		cursor string
+		seen   []int
+	)
+	for {
+		list, next, err := db.ListAfter(cursor, 3)
+		if err != nil {
+			t.Errorf("list after failed, err: %+v", err)
+			return
+		}
+		seen = append(seen, list...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 10 || seen[0] != 0 || seen[9] != 9 {
+		t.Errorf("list after failed, seen(%v) should be 0..9", seen)
+		return
+	}
+}
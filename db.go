@@ -1,12 +1,11 @@
 package simpledb
 
 import (
-	"github.com/byronzhu-haha/simpledb/errors"
-	"math"
+	"io"
 	"reflect"
-	"sync"
-	"time"
+	"strings"
 
+	"github.com/byronzhu-haha/simpledb/errors"
 	"github.com/byronzhu-haha/simpledb/skiplist"
 )
 
@@ -29,94 +28,93 @@ type CustomKey interface {
 
 type Query func(v interface{}) bool
 
-type expireCustomKey struct {
-	typ        keyType
-	expireTime int64
-	strKey     string
-	key        CustomKey
-}
-
-func (k expireCustomKey) Key() string {
-	if k.typ == String {
-		return k.strKey
-	}
-	return k.key.Key()
-}
-
+// DB key和value都为interface{}的内存数据库，是TypedDB的一层封装，
+// 需要在编译期检查类型时请使用TypedDB
 type DB struct {
 	typ     keyType
 	hasInit bool
-	mu      sync.RWMutex
-	keys    map[string]CustomKey
-	data    skiplist.SkipList
-	conf    Config
+	typed   *TypedDB[interface{}, interface{}]
 }
 
 // NewCustomDB 创建一个key可以定制的内存数据库，
-// 前提是您的key类型实现了CustomKey接口，且它可比较，即key实例为实现类的值类型
+// 前提是您的key类型实现了CustomKey接口，且它可比较，即key实例为实现类的值类型。
+// 开启持久化时若打开日志失败会panic，需要处理错误请使用OpenCustomDB
 func NewCustomDB(less func(l, r interface{}) bool, opts ...DBOption) *DB {
-	var (
-		conf = Config{}
-	)
-	for _, opt := range opts {
-		conf = opt(conf)
-	}
-	db := &DB{
-		typ:     Custom,
-		hasInit: true,
-		keys:    make(map[string]CustomKey),
-		conf:    conf,
-	}
-	if conf.withExpired {
-		db.data = skiplist.NewSkipList(func(l, r interface{}) bool {
-			left := l.(expireCustomKey)
-			right := r.(expireCustomKey)
-			return less(left.key, right.key)
-		})
-		go db.background()
-	} else {
-		db.data = skiplist.NewSkipList(less)
+	db, err := OpenCustomDB(less, opts...)
+	if err != nil {
+		panic(err)
 	}
 	return db
 }
 
-// NewDB 创建key为string, value为interface{}的内存数据库
-func NewDB(opts ...DBOption) *DB {
-	var (
-		conf = Config{}
-	)
-	for _, opt := range opts {
-		conf = opt(conf)
-	}
-	db := &DB{
-		typ:     String,
-		hasInit: true,
-		conf:    conf,
+// OpenCustomDB 同NewCustomDB，开启持久化时会回放日志，失败则返回错误
+func OpenCustomDB(less func(l, r interface{}) bool, opts ...DBOption) (*DB, error) {
+	cmp := func(a, b interface{}) int {
+		if less(a, b) {
+			return -1
+		}
+		if less(b, a) {
+			return 1
+		}
+		return 0
 	}
-	less := func(l, r string) bool {
-		if l < r {
-			return true
+	typed := newTypedDB[interface{}, interface{}](cmp, newConfig(opts))
+	typed.alias = func(key interface{}) string {
+		if custom, ok := key.(CustomKey); ok {
+			return custom.Key()
 		}
-		return false
+		return ""
 	}
-	if db.conf.withExpired {
-		db.data = skiplist.NewSkipList(func(l, r interface{}) bool {
-			left := l.(expireCustomKey)
-			right := r.(expireCustomKey)
-			return less(left.strKey, right.strKey)
-		})
-		db.keys = make(map[string]CustomKey)
-		go db.background()
-	} else {
-		db.data = skiplist.NewSkipList(func(l, r interface{}) bool {
-			return less(l.(string), r.(string))
-		})
+	return open(Custom, typed)
+}
+
+// NewDB 创建key为string, value为interface{}的内存数据库，
+// 开启持久化时若打开日志失败会panic，需要处理错误请使用OpenDB
+func NewDB(opts ...DBOption) *DB {
+	db, err := OpenDB(opts...)
+	if err != nil {
+		panic(err)
 	}
 	return db
 }
 
-func (d *DB) withExpired() bool {
-	return d.conf.withExpired
+// OpenDB 同NewDB，开启持久化时会回放日志，失败则返回错误
+func OpenDB(opts ...DBOption) (*DB, error) {
+	cmp := func(a, b interface{}) int {
+		return strings.Compare(a.(string), b.(string))
+	}
+	typed := newTypedDB[interface{}, interface{}](cmp, newConfig(opts))
+	typed.stringKey = true
+	return open(String, typed)
+}
+
+func open(typ keyType, typed *TypedDB[interface{}, interface{}]) (*DB, error) {
+	if err := typed.open(); err != nil {
+		return nil, err
+	}
+	return &DB{
+		typ:     typ,
+		hasInit: true,
+		typed:   typed,
+	}, nil
+}
+
+func newConfig(opts []DBOption) Config {
+	conf := Config{
+		codec:          gobCodec{},
+		compactRatio:   defaultCompactRatio,
+		compactMinSize: defaultCompactMinSize,
+		expireLimit:    defaultExpireLimit,
+		clock:          realClock{},
+		evictWorkers:   defaultEvictWorkers,
+		evictQueueSize: defaultEvictQueueSize,
+		sizer:          EstimateSize,
+		policy:         PolicyLRU,
+	}
+	for _, opt := range opts {
+		conf = opt(conf)
+	}
+	return conf
 }
 
 func (d *DB) checkBeforeOp() {
@@ -126,91 +124,64 @@ func (d *DB) checkBeforeOp() {
 }
 
 // isValidKey 校验是否为有效的key，目前支持string类型、实现了CustomKey接口的值类型
-func (d *DB) isValidKey(key interface{}) (CustomKey, error) {
+func (d *DB) isValidKey(key interface{}) error {
 	if key == nil {
-		return nil, errors.ErrNilKey
+		return errors.ErrNilKey
 	}
 	switch d.typ {
 	case String:
 		_, ok := key.(string)
 		if ok {
-			return nil, nil
+			return nil
 		}
-		return nil, ErrInvalidStringKey
+		return ErrInvalidStringKey
 	case Custom:
 		cus, ok := key.(CustomKey)
 		if ok {
 			if cus == nil {
-				return nil, errors.ErrNilKey
+				return errors.ErrNilKey
 			}
 			typ := reflect.TypeOf(key)
 			if typ.Name() == "" {
-				return nil, ErrInvalidCustomKeyPointer
+				return ErrInvalidCustomKeyPointer
 			}
-			return cus, nil
+			return nil
 		}
-		return nil, ErrInvalidCustomKey
+		return ErrInvalidCustomKey
 	}
-	return nil, errors.ErrInvalidKey
+	return errors.ErrInvalidKey
 }
 
-// Save 保存数据，支持过期时间
-func (d *DB) Save(key, value interface{}, opts ...SaveOption) error {
-	// 检测db是否被初始化
-	d.checkBeforeOp()
-
-	custom, err := d.isValidKey(key)
-	if err != nil {
-		return err
-	}
-	var o SaveOptions
-	for _, opt := range opts {
-		o = opt(o)
-	}
-	// 设置过期时，将key包上过期时间
-	if d.withExpired() {
-		ttl := int64(math.MaxInt64)
-		if o.isExpired {
-			ttl = o.ttl
-		}
-		key, custom = d.packWithExpire(key, custom, ttl)
-	}
-	err = d.data.Set(key, value)
-	if err != nil {
-		return err
-	}
-	if custom != nil {
-		d.mu.Lock()
-		// 删除旧的key
-		old := d.keys[custom.Key()]
-		if old != nil && old != custom {
-			_ = d.data.Del(old)
-		}
-		d.keys[custom.Key()] = custom
-		d.mu.Unlock()
+// lookupKey 将寻址key转换为存储的key，如果是CustomKey类型的db，支持CustomKey.Key()作为寻址key
+func (d *DB) lookupKey(key interface{}) (interface{}, error) {
+	if key == nil {
+		return nil, errors.ErrNilKey
 	}
-	return nil
-}
-
-// packWithExpire 为key包上过期时间
-func (d *DB) packWithExpire(inKey interface{}, inCustomKey CustomKey, ttl int64) (outKey interface{}, outCustomKey CustomKey) {
-	now := time.Now().Unix()
 	switch d.typ {
 	case String:
-		outCustomKey = expireCustomKey{
-			typ:        String,
-			expireTime: now + ttl,
-			strKey:     inKey.(string),
+		if _, ok := key.(string); ok {
+			return key, nil
 		}
 	case Custom:
-		outCustomKey = expireCustomKey{
-			typ:        Custom,
-			expireTime: now + ttl,
-			key:        inCustomKey,
+		if name, ok := key.(string); ok {
+			return d.typed.resolve(name)
 		}
+		if custom, ok := key.(CustomKey); ok && custom != nil {
+			return custom, nil
+		}
+	}
+	return nil, errors.ErrNotFound
+}
+
+// Save 保存数据，支持过期时间
+func (d *DB) Save(key, value interface{}, opts ...SaveOption) error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if err := d.isValidKey(key); err != nil {
+		return err
 	}
-	outKey = outCustomKey
-	return
+	return d.typed.Save(key, value, opts...)
 }
 
 // Get 根据key获取值，如果是CustomKey类型的key，支持接收CustomKey.Key()作为寻址key
@@ -218,14 +189,11 @@ func (d *DB) Get(key interface{}) (interface{}, error) {
 	// 检测db是否被初始化
 	d.checkBeforeOp()
 
-	if !d.withExpired() && d.typ == String {
-		return d.data.Get(key)
-	}
-	custom, err := d.getCustomKey(key, true)
+	k, err := d.lookupKey(key)
 	if err != nil {
 		return nil, err
 	}
-	return d.data.Get(custom)
+	return d.typed.Get(k)
 }
 
 // Delete 删除指定的Key，同Get支持CustomKey.Key()作为寻址key
@@ -233,164 +201,86 @@ func (d *DB) Delete(key interface{}) error {
 	// 检测db是否被初始化
 	d.checkBeforeOp()
 
-	if !d.withExpired() && d.typ == String {
-		return d.data.Del(key)
-	}
-	custom, err := d.getCustomKey(key, true)
-	if err != nil {
-		return err
-	}
-	err = d.data.Del(custom)
-	if err != nil {
-		return err
-	}
-	d.mu.Lock()
-	// 双重检测
-	custom, err = d.getCustomKey(key, false)
+	k, err := d.lookupKey(key)
 	if err != nil {
-		d.mu.Unlock()
 		return err
 	}
-	delete(d.keys, custom.Key())
-	d.mu.Unlock()
-	return nil
+	return d.typed.Delete(k)
 }
 
-func (d *DB) getCustomKey(key interface{}, locked bool) (CustomKey, error) {
-	var (
-		k, ok  = key.(string)
-		custom CustomKey
-	)
+func (d *DB) Count(queries ...Query) (int, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.Count(funcs(queries)...)
+}
 
-	if ok {
-		return d.customKeyRLock(k, locked)
+// funcs 将Query转换为TypedDB接受的查询函数
+func funcs(queries []Query) []func(v interface{}) bool {
+	ret := make([]func(v interface{}) bool, 0, len(queries))
+	for _, q := range queries {
+		ret = append(ret, q)
 	}
+	return ret
+}
 
-	custom, ok = key.(CustomKey)
-	if !ok || custom == nil {
-		return nil, errors.ErrNotFound
-	}
+// Iterator 按key的顺序遍历，支持Seek定位以及Prev反向遍历
+func (d *DB) Iterator() skiplist.Iterator[interface{}, interface{}] {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
 
-	if d.withExpired() {
-		return d.customKeyRLock(custom.Key(), locked)
+	return &dbIterator{
+		Iterator: d.typed.Iterator(),
+		db:       d,
 	}
+}
 
-	return custom, nil
+// dbIterator Seek时同Get一样转换寻址key
+type dbIterator struct {
+	skiplist.Iterator[interface{}, interface{}]
+	db *DB
 }
 
-func (d *DB) customKeyRLock(key string, locked bool) (custom CustomKey, err error) {
-	if locked {
-		d.mu.RLock()
-		custom = d.keys[key]
-		d.mu.RUnlock()
-	} else {
-		custom = d.keys[key]
-	}
-	if custom == nil {
-		err = errors.ErrNotFound
+// Seek 定位到第一条不小于key的数据，如果是CustomKey类型的db，支持CustomKey.Key()，
+// key无效或者别名不存在时迭代器失效
+func (i *dbIterator) Seek(key interface{}) {
+	k, err := i.db.lookupKey(key)
+	if err != nil {
+		// 移动到最后一条之后
+		i.Iterator.SeekToLast()
+		i.Iterator.HasNext()
+		return
 	}
-	return custom, err
+	i.Iterator.Seek(k)
 }
 
-func (d *DB) Count(queries ...Query) (int, error) {
+func (d *DB) List(page, pageSize int32, queries ...Query) ([]interface{}, bool, error) {
 	// 检测db是否被初始化
 	d.checkBeforeOp()
 
-	if len(queries) == 0 {
-		return d.data.Len(), nil
-	}
-	var count int
-	iter := d.data.Iterator()
-	for iter.HasNext() {
-		v := iter.Value()
-		if v == nil {
-			continue
-		}
-		if !query(v, queries...) {
-			continue
-		}
-		count++
-	}
-	iter.Close()
-	return count, nil
-}
-
-func query(v interface{}, queries ...Query) bool {
-	for _, q := range queries {
-		if !q(v) {
-			return false
-		}
-	}
-	return true
+	return d.typed.List(page, pageSize, funcs(queries)...)
 }
 
-func (d *DB) Iterator() skiplist.Iterator {
+// Compact 用跳表中当前的数据重写日志，丢弃已删除和已过期的数据
+func (d *DB) Compact() error {
 	// 检测db是否被初始化
 	d.checkBeforeOp()
 
-	return d.data.Iterator()
+	return d.typed.Compact()
 }
 
-func (d *DB) List(page, pageSize int32, queries ...Query) ([]interface{}, bool, error) {
+// SnapshotTo 将db某一时刻的数据写入w，保留剩余的过期时间
+func (d *DB) SnapshotTo(w io.Writer) error {
 	// 检测db是否被初始化
 	d.checkBeforeOp()
 
-	var (
-		iter        = d.data.Iterator()
-		offset      = (page - 1) * pageSize
-		end         = offset + pageSize
-		count       int32
-		ret         = make([]interface{}, 0, pageSize)
-		hasNextPage bool
-	)
-
-	// 最坏情况是全表扫描
-	for iter.HasNext() {
-		if hasNextPage {
-			break
-		}
-		v := iter.Value()
-		if v == nil {
-			continue
-		}
-		if !query(v, queries...) {
-			continue
-		}
-		if count >= end {
-			hasNextPage = true
-			continue
-		}
-		if offset <= count {
-			ret = append(ret, v)
-		}
-		count++
-	}
-	iter.Close()
-	return ret, hasNextPage, nil
+	return d.typed.SnapshotTo(w)
 }
 
-func (d *DB) background() {
-	t := time.NewTicker(time.Second)
-	defer t.Stop()
-	for range t.C {
-		// todo: 如何避免全表扫描
-		now := time.Now().Unix()
-		iter := d.Iterator()
-		for iter.HasNext() {
-			ek, ok := iter.Key().(expireCustomKey)
-			if !ok {
-				continue
-			}
-			if ek.expireTime <= now {
-				err := d.data.Del(ek)
-				if err != nil {
-					continue
-				}
-				d.mu.Lock()
-				delete(d.keys, ek.Key())
-				d.mu.Unlock()
-			}
-		}
-		iter.Close()
-	}
+// Close 关闭db，等待正在执行的操作结束，停止后台任务并将数据刷盘，之后的操作都返回ErrClosed
+func (d *DB) Close() error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.Close()
 }
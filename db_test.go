@@ -1,15 +1,15 @@
 package simpledb
 
 import (
+	"github.com/byronzhu-haha/simpledb/clocktest"
 	"github.com/byronzhu-haha/simpledb/errors"
 	"strconv"
 	"testing"
 	"time"
 )
 
-var db = NewDB()
-
 func TestByDB_Save(t *testing.T) {
+	db := NewDB()
 	err := db.Save("2", 2)
 	if err != nil {
 		t.Errorf("save failed, err should be nil, err: %+v", err)
@@ -19,16 +19,17 @@ func TestByDB_Save(t *testing.T) {
 		t.Errorf("save failed, err should be nil, err: %+v", err)
 		return
 	}
-	if db.data.Len() != 1 {
+	if db.typed.data.Len() != 1 {
 		t.Errorf("save failed, len of db should be 1")
 		return
 	}
-	if v, _ := db.data.Get("2"); v != 3 {
+	if it, _ := db.typed.data.Get("2"); it.value != 3 {
 		t.Errorf("save failed, v should be 3")
 	}
 }
 
 func TestByDB_Count(t *testing.T) {
+	db := NewDB()
 	for i := 0; i < 10; i++ {
 		_ = db.Save(strconv.Itoa(i), i)
 	}
@@ -43,6 +44,7 @@ func TestByDB_Count(t *testing.T) {
 }
 
 func TestByDB_Get(t *testing.T) {
+	db := NewDB()
 	_ = db.Save("5", "10")
 	v, err := db.Get("5")
 	if err != nil {
@@ -55,6 +57,7 @@ func TestByDB_Get(t *testing.T) {
 }
 
 func TestByDB_Delete(t *testing.T) {
+	db := NewDB()
 	_ = db.Save("5", 10)
 	err := db.Delete("5")
 	if err != nil {
@@ -71,6 +74,7 @@ func TestByDB_Delete(t *testing.T) {
 }
 
 func TestByDB_Iterator(t *testing.T) {
+	db := NewDB()
 	in := map[string]int{
 		"1": 1,
 		"2": 2,
@@ -95,6 +99,7 @@ func TestByDB_Iterator(t *testing.T) {
 }
 
 func TestByDB_List(t *testing.T) {
+	db := NewDB()
 	in := map[string]int{
 		"1": 1,
 		"2": 2,
@@ -130,9 +135,10 @@ func TestByDB_List(t *testing.T) {
 	}
 }
 
-var expiredDB = NewDB(DBOptionWithExpired())
-
 func TestByDBExpired_Save(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	expiredDB := NewDB(DBOptionWithExpired(), DBOptionClock(clock))
+	defer expiredDB.Close()
 	err := expiredDB.Save("1", 1, SaveOptionTTL(2))
 	if err != nil {
 		t.Errorf("save failed, err should be nil, err: %+v", err)
@@ -147,7 +153,7 @@ func TestByDBExpired_Save(t *testing.T) {
 		t.Errorf("test failed, v should be 1")
 		return
 	}
-	time.Sleep(3 * time.Second)
+	clock.Advance(3 * time.Second)
 	v, err = expiredDB.Get("1")
 	if err != errors.ErrNotFound {
 		t.Errorf("test failed, err should be ErrNil")
@@ -206,7 +212,7 @@ func TestCustomDB_Save(t *testing.T) {
 		t.Errorf("save failed, err should be nil")
 		return
 	}
-	if customDB.data.Len() != 1 {
+	if customDB.typed.data.Len() != 1 {
 		t.Errorf("save failed, len of db should be 1")
 	}
 }
@@ -296,6 +302,35 @@ func TestCustomDB_Iterator(t *testing.T) {
 	}
 }
 
+func TestCustomDB_IteratorSeek(t *testing.T) {
+	db := NewCustomDB(func(l, r interface{}) bool {
+		return l.(customKey).seq < r.(customKey).seq
+	})
+	for _, v := range newKVN() {
+		_ = db.Save(newCustomKey(v.key, v.seq), v)
+	}
+	iter := db.Iterator()
+	defer iter.Close()
+	// 支持CustomKey.Key()作为定位的key
+	iter.Seek("3")
+	if !iter.Valid() || iter.Value().(value).seq != 3 {
+		t.Errorf("seek failed, v(%+v) should be 3", iter.Value())
+		return
+	}
+	var seqs []int32
+	for ; iter.Valid(); iter.Prev() {
+		seqs = append(seqs, iter.Key().(customKey).seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 3 || seqs[2] != 1 {
+		t.Errorf("prev failed, seqs(%+v) should be in descending order", seqs)
+		return
+	}
+	iter.Seek("5")
+	if iter.Valid() {
+		t.Errorf("seek failed, iterator should be invalid")
+	}
+}
+
 func newKVN() []value {
 	vs := []value{
 		{
@@ -366,23 +401,24 @@ func TestCustomDB_List(t *testing.T) {
 	}
 }
 
-var expiredCustomDB = NewCustomDB(func(l, r interface{}) bool {
-	left := l.(customKey)
-	right := r.(customKey)
-	if left.seq < right.seq {
-		return true
-	}
-	return false
-}, DBOptionWithExpired())
-
 func TestCustomDBExpired_Save(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	expiredCustomDB := NewCustomDB(func(l, r interface{}) bool {
+		left := l.(customKey)
+		right := r.(customKey)
+		if left.seq < right.seq {
+			return true
+		}
+		return false
+	}, DBOptionWithExpired(), DBOptionClock(clock))
+	defer expiredCustomDB.Close()
 	k, v := newKV()
 	err := expiredCustomDB.Save(k, v, SaveOptionTTL(2))
 	if err != nil {
 		t.Errorf("save failed, err should be nil")
 		return
 	}
-	if l := expiredCustomDB.data.Len(); l != 1 {
+	if l := expiredCustomDB.typed.data.Len(); l != 1 {
 		t.Errorf("save failed, len of db should be 1, l: %+v", l)
 		return
 	}
@@ -395,8 +431,8 @@ func TestCustomDBExpired_Save(t *testing.T) {
 		t.Errorf("save failed, nv should be equal v")
 		return
 	}
-	time.Sleep(3 * time.Second)
-	if l := expiredCustomDB.data.Len(); l != 0 {
+	clock.Advance(3 * time.Second)
+	if l := expiredCustomDB.typed.data.Len(); l != 0 {
 		t.Errorf("save failed, len of db should be 0, l: %d", l)
 	}
 }
@@ -8,6 +8,25 @@ var (
 	ErrNotFound               = errors.New("not found value")
 	ErrNotInit                = errors.New("db is not init")
 	ErrInvalidKey             = errors.New("type of key is invalid")
+	ErrCorruptRecord          = errors.New("record of log is corrupt")
+	ErrLogClosed              = errors.New("log is closed")
+	ErrRecordTooLarge         = errors.New("record of log is too large")
+	ErrInvalidSnapshot        = errors.New("snapshot is invalid")
+	ErrRewriting              = errors.New("log is being rewritten")
+	ErrCorruptTable           = errors.New("table is corrupt")
+	ErrInvalidValue           = errors.New("type of value is invalid")
+	ErrConflict               = errors.New("transaction conflict, please retry")
+	ErrTxReadOnly             = errors.New("transaction is read-only")
+	ErrTxDone                 = errors.New("transaction has been committed or discarded")
+	ErrClosed                 = errors.New("db is closed")
+	ErrExpireDisabled         = errors.New("expiration is disabled, open db with DBOptionWithExpired")
+	ErrOutOfCapacity          = errors.New("db is full and no entry can be evicted")
+	ErrCapacityWithLSM        = errors.New("capacity limits are not supported with lsm storage")
+	ErrIndexExists            = errors.New("index already exists")
+	ErrIndexNotFound          = errors.New("index not found")
+	ErrDuplicateIndex         = errors.New("value violates unique index")
+	ErrInvalidCursor          = errors.New("cursor is invalid")
+	ErrServerClosed           = errors.New("server is closed")
 )
 
 type withMessage struct {
@@ -0,0 +1,144 @@
+package simpledb
+
+import (
+	"sync"
+)
+
+// EvictReason 数据被移除的原因
+type EvictReason byte
+
+const (
+	// EvictExpired 过期后被清理
+	EvictExpired EvictReason = iota + 1
+	// EvictDeleted 被主动删除
+	EvictDeleted
+	// EvictOverwrite 被新的值覆盖
+	EvictOverwrite
+	// EvictCapacity 超出容量被淘汰
+	EvictCapacity
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	case EvictOverwrite:
+		return "overwrite"
+	case EvictCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+const (
+	defaultEvictWorkers   = 1
+	defaultEvictQueueSize = 1024
+)
+
+// evictEvent 一次移除，交给回调处理
+type evictEvent struct {
+	key    interface{}
+	value  interface{}
+	reason EvictReason
+}
+
+// evictPool 在db的锁之外执行回调的协程池，队列满时阻塞写入方或者丢弃事件
+type evictPool struct {
+	fn    func(key, value interface{}, reason EvictReason)
+	queue chan evictEvent
+	drop  bool
+	// mu 保证关闭前放入队列的事件都能被协程处理，closed之后的事件在调用方直接执行回调
+	mu     sync.RWMutex
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newEvictPool(conf Config) *evictPool {
+	p := &evictPool{
+		fn:    conf.onEvict,
+		queue: make(chan evictEvent, conf.evictQueueSize),
+		drop:  conf.evictDrop,
+		stop:  make(chan struct{}),
+	}
+	for i := 0; i < conf.evictWorkers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+func (p *evictPool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case e := <-p.queue:
+			p.fn(e.key, e.value, e.reason)
+		case <-p.stop:
+			// 处理完队列中剩余的事件再退出
+			for {
+				select {
+				case e := <-p.queue:
+					p.fn(e.key, e.value, e.reason)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// dispatch 将事件放入队列，关闭后在调用方同步执行回调，不能在持有db的锁时调用
+func (p *evictPool) dispatch(events []evictEvent) {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		for _, e := range events {
+			p.fn(e.key, e.value, e.reason)
+		}
+		return
+	}
+	for _, e := range events {
+		if p.drop {
+			select {
+			case p.queue <- e:
+			default:
+			}
+			continue
+		}
+		p.queue <- e
+	}
+	p.mu.RUnlock()
+}
+
+// close 停止接收事件，等待队列中的事件处理完
+func (p *evictPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// evict 记录一次移除，在unlock之后交给回调，调用方需持有写锁
+func (d *TypedDB[K, V]) evict(key K, it item[V], reason EvictReason) {
+	if d.evictions == nil {
+		return
+	}
+	if reason == EvictOverwrite && d.expired(it, d.now()) {
+		reason = EvictExpired
+	}
+	d.pending = append(d.pending, evictEvent{key: key, value: it.value, reason: reason})
+}
+
+// unlock 释放写锁，并将期间记录的移除交给回调
+func (d *TypedDB[K, V]) unlock() {
+	events := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+	if len(events) > 0 {
+		d.evictions.dispatch(events)
+	}
+}
@@ -0,0 +1,124 @@
+package simpledb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/clocktest"
+)
+
+type evicted struct {
+	key    interface{}
+	value  interface{}
+	reason EvictReason
+}
+
+// recorder 记录所有的移除，db关闭后读取
+type recorder struct {
+	mu     sync.Mutex
+	events []evicted
+}
+
+func (r *recorder) onEvict(key, value interface{}, reason EvictReason) {
+	r.mu.Lock()
+	r.events = append(r.events, evicted{key: key, value: value, reason: reason})
+	r.mu.Unlock()
+}
+
+func TestDB_OnEvict(t *testing.T) {
+	var (
+		r     recorder
+		clock = clocktest.NewFake(time.Now())
+		db    = NewDB(DBOptionWithExpired(), DBOptionClock(clock), DBOptionOnEvict(r.onEvict))
+	)
+	_ = db.Save("1", 1)
+	_ = db.Save("1", 2)
+	_ = db.Delete("1")
+	_ = db.Save("2", 2, SaveOptionTTL(1))
+	clock.Advance(2 * time.Second)
+	_ = db.Save("3", 3)
+	_ = db.Update(func(tx *Tx) error {
+		_ = tx.Save("3", 4)
+		return tx.Save("4", 4)
+	})
+	_, _ = db.CompareAndSwap("4", 4, 5)
+	// 只修改过期时间不算覆盖
+	_ = db.Expire("4", time.Hour)
+	_ = db.Close()
+
+	exp := []evicted{
+		{"1", 1, EvictOverwrite},
+		{"1", 2, EvictDeleted},
+		{"2", 2, EvictExpired},
+		{"3", 3, EvictOverwrite},
+		{"4", 4, EvictOverwrite},
+	}
+	if len(r.events) != len(exp) {
+		t.Errorf("evict failed, events(%+v) should be %+v", r.events, exp)
+		return
+	}
+	for i := range exp {
+		if r.events[i] != exp[i] {
+			t.Errorf("evict failed, event(%+v) should be %+v", r.events[i], exp[i])
+			return
+		}
+	}
+}
+
+func TestDB_OnEvictBackPressure(t *testing.T) {
+	release := make(chan struct{})
+	var (
+		r     recorder
+		block = func(key, value interface{}, reason EvictReason) {
+			<-release
+			r.onEvict(key, value, reason)
+		}
+		db = NewDB(DBOptionOnEvict(block), DBOptionEvictQueueSize(0))
+	)
+	_ = db.Save("1", 1)
+	// 第一个事件被协程取走后阻塞在回调中，第二个事件没有队列可以放入
+	_ = db.Save("1", 2)
+	saved := make(chan struct{})
+	go func() {
+		_ = db.Save("1", 3)
+		close(saved)
+	}()
+	select {
+	case <-saved:
+		t.Errorf("evict failed, save should be blocked")
+		return
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-saved
+	_ = db.Close()
+	if len(r.events) != 2 {
+		t.Errorf("evict failed, len(%d) should be 2", len(r.events))
+	}
+
+	// 队列满时丢弃
+	release = make(chan struct{})
+	r = recorder{}
+	db = NewDB(DBOptionOnEvict(block), DBOptionEvictQueueSize(0), DBOptionEvictDropWhenFull())
+	for i := 0; i < 10; i++ {
+		_ = db.Save("1", i)
+	}
+	close(release)
+	_ = db.Close()
+	if len(r.events) >= 9 {
+		t.Errorf("evict failed, len(%d) should be less than 9", len(r.events))
+	}
+}
+
+func TestEvictPool_DispatchAfterClose(t *testing.T) {
+	var r recorder
+	p := newEvictPool(Config{onEvict: r.onEvict, evictQueueSize: 1, evictWorkers: 1})
+	p.dispatch([]evictEvent{{key: "1", reason: EvictDeleted}})
+	p.close()
+	// 关闭后的事件在调用方直接执行，不会丢失
+	p.dispatch([]evictEvent{{key: "2", reason: EvictDeleted}, {key: "3", reason: EvictCapacity}})
+	if len(r.events) != 3 || r.events[1].key != "2" || r.events[2].reason != EvictCapacity {
+		t.Errorf("dispatch failed, events(%+v) should have 3 events", r.events)
+	}
+}
@@ -0,0 +1,118 @@
+package simpledb
+
+import (
+	"container/heap"
+)
+
+const (
+	// defaultExpireLimit 每秒最多清理的过期数据
+	defaultExpireLimit = 10000
+	// expireBatch 每次持有写锁时最多清理的过期数据，避免长时间阻塞写入
+	expireBatch = 128
+	// expiryRebuildMin 索引中的数据超过该值且大部分已失效时才重建
+	expiryRebuildMin = 1024
+)
+
+// expiry 过期索引中的一项，stamp与数据当前的写入戳不同时说明数据已被覆盖或删除，该项失效
+type expiry[K any] struct {
+	key      K
+	expireAt int64
+	stamp    uint64
+}
+
+// expiryHeap 按过期时间排序的最小堆，数据被覆盖或删除时不更新，失效的项在出堆或重建时丢弃
+type expiryHeap[K any] []expiry[K]
+
+func (h expiryHeap[K]) Len() int           { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool { return h[i].expireAt < h[j].expireAt }
+func (h expiryHeap[K]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap[K]) Push(x interface{}) {
+	*h = append(*h, x.(expiry[K]))
+}
+
+func (h *expiryHeap[K]) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	e := old[n]
+	old[n] = expiry[K]{}
+	*h = old[:n]
+	return e
+}
+
+// track 将带有过期时间的数据加入过期索引，调用方需持有写锁
+func (d *TypedDB[K, V]) track(key K, it item[V]) {
+	if it.expireAt == 0 || !d.withExpired() {
+		return
+	}
+	heap.Push(&d.expiries, expiry[K]{key: key, expireAt: it.expireAt, stamp: it.stamp})
+	if len(d.expiries) > expiryRebuildMin && len(d.expiries) > 2*d.expiryLive {
+		d.rebuildExpiries()
+	}
+}
+
+// valid 过期索引中的项是否仍对应当前的数据，调用方需持有锁
+func (d *TypedDB[K, V]) valid(e expiry[K]) (stored K, it item[V], ok bool) {
+	stored, it, err := d.data.Lookup(e.key)
+	return stored, it, err == nil && it.stamp == e.stamp
+}
+
+// rebuildExpiries 丢弃过期索引中失效的项，调用方需持有写锁
+func (d *TypedDB[K, V]) rebuildExpiries() {
+	live := d.expiries[:0]
+	for _, e := range d.expiries {
+		if _, _, ok := d.valid(e); ok {
+			live = append(live, e)
+		}
+	}
+	for i := len(live); i < len(d.expiries); i++ {
+		d.expiries[i] = expiry[K]{}
+	}
+	d.expiries = live
+	heap.Init(&d.expiries)
+	d.expiryLive = len(live)
+}
+
+// expire 清理在now之前过期的数据，最多处理limit项，返回删除的数量
+func (d *TypedDB[K, V]) expire(now int64, limit int) int {
+	var (
+		removed int
+		handled int
+	)
+	for handled < limit {
+		d.mu.Lock()
+		batch := 0
+		for ; batch < expireBatch && handled < limit && len(d.expiries) > 0; batch++ {
+			if d.expiries[0].expireAt > now {
+				break
+			}
+			e := heap.Pop(&d.expiries).(expiry[K])
+			handled++
+			stored, it, ok := d.valid(e)
+			if !ok {
+				continue
+			}
+			if expireAt := it.deadline(); expireAt > now {
+				// 滑动过期的数据被读取后延后了过期时间，按新的时间重新加入
+				e.expireAt = expireAt
+				heap.Push(&d.expiries, e)
+				continue
+			}
+			_ = d.delete(stored, EvictExpired)
+			removed++
+		}
+		done := batch < expireBatch
+		d.unlock()
+		if done {
+			break
+		}
+	}
+	return removed
+}
+
+// sweep 后台每秒清理一次过期数据
+func (d *TypedDB[K, V]) sweep() {
+	now := d.now()
+	d.expire(now, d.conf.expireLimit)
+	d.loads.purge(now)
+}
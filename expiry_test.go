@@ -0,0 +1,101 @@
+package simpledb
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestTypedDB_Expire(t *testing.T) {
+	db := NewTypedDB[string, int](strings.Compare, DBOptionWithExpired())
+	now := db.now()
+	for i := 0; i < 10; i++ {
+		_ = db.write(strconv.Itoa(i), item[int]{value: i, expireAt: now + int64(i+1)*1000})
+	}
+	// 覆盖或删除后过期索引中的旧项失效
+	_ = db.Save("0", 0)
+	_ = db.Delete("1")
+	if n := db.expire(now+5000, 100); n != 3 {
+		t.Errorf("expire failed, n(%d) should be 3", n)
+		return
+	}
+	if n, _ := db.Count(); n != 6 {
+		t.Errorf("expire failed, count(%d) should be 6", n)
+		return
+	}
+	// 超出上限的部分留到下次
+	if n := db.expire(now+10000, 2); n != 2 {
+		t.Errorf("expire failed, n(%d) should be 2", n)
+		return
+	}
+	if n := db.expire(now+10000, 100); n != 3 {
+		t.Errorf("expire failed, n(%d) should be 3", n)
+		return
+	}
+	if v, err := db.Get("0"); err != nil || v != 0 {
+		t.Errorf("expire failed, 0 should not expire, err: %+v", err)
+	}
+}
+
+func TestTypedDB_ExpiryRebuild(t *testing.T) {
+	db := NewTypedDB[string, int](strings.Compare, DBOptionWithExpired())
+	for i := 0; i < 5*expiryRebuildMin; i++ {
+		_ = db.Save("1", i, SaveOptionTTL(100))
+	}
+	if l := len(db.expiries); l > 2*expiryRebuildMin {
+		t.Errorf("rebuild failed, len(%d) should be bounded", l)
+	}
+}
+
+func TestTypedDB_LazyExpire(t *testing.T) {
+	db := NewTypedDB[string, int](strings.Compare, DBOptionWithExpired())
+	now := db.now()
+	_ = db.Save("1", 1)
+	_ = db.write("2", item[int]{value: 2, expireAt: now - 1})
+	_ = db.write("3", item[int]{value: 3, expireAt: now + 100000})
+	_ = db.write("4", item[int]{value: 4, expireAt: now - 1})
+	if _, err := db.Get("2"); err != errors.ErrNotFound {
+		t.Errorf("get failed, 2 should be expired, err: %+v", err)
+		return
+	}
+	if n, _ := db.Count(); n != 2 {
+		t.Errorf("count failed, count(%d) should be 2", n)
+		return
+	}
+	if vs, _, _ := db.List(1, 10); len(vs) != 2 || vs[0] != 1 || vs[1] != 3 {
+		t.Errorf("list failed, values(%+v) should be [1 3]", vs)
+		return
+	}
+	iter := db.Iterator()
+	defer iter.Close()
+	if iter.Seek("2"); !iter.Valid() || iter.Key() != "3" {
+		t.Errorf("seek failed, key(%+v) should be 3", iter.Key())
+		return
+	}
+	if iter.SeekToLast(); !iter.Valid() || iter.Key() != "3" {
+		t.Errorf("seek to last failed, key(%+v) should be 3", iter.Key())
+		return
+	}
+	if iter.Prev(); !iter.Valid() || iter.Key() != "1" {
+		t.Errorf("prev failed, key(%+v) should be 1", iter.Key())
+		return
+	}
+	// 过期的数据默认仍留在跳表中，等待后台清理
+	if _, err := db.data.Get("2"); err != nil {
+		t.Errorf("get failed, 2 should not be deleted, err: %+v", err)
+	}
+}
+
+func TestTypedDB_DeleteExpiredOnRead(t *testing.T) {
+	db := NewTypedDB[string, int](strings.Compare, DBOptionWithExpired(), DBOptionDeleteExpiredOnRead())
+	_ = db.write("1", item[int]{value: 1, expireAt: db.now() - 1})
+	if _, err := db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("get failed, 1 should be expired, err: %+v", err)
+		return
+	}
+	if _, err := db.data.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("get failed, 1 should be deleted, err: %+v", err)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/byronzhu-haha/simpledb
 
-go 1.14
+go 1.19
@@ -0,0 +1,305 @@
+package simpledb
+
+import (
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/skiplist"
+)
+
+// IndexOptions 创建索引的选项
+type IndexOptions struct {
+	unique bool
+}
+
+type IndexOption func(o IndexOptions) IndexOptions
+
+// IndexOptionUnique 唯一索引，保存与其他key的字段相同的数据时返回ErrDuplicateIndex
+func IndexOptionUnique() IndexOption {
+	return func(o IndexOptions) IndexOptions {
+		o.unique = true
+		return o
+	}
+}
+
+// indexKey 索引中的一项，先按字段排序，字段相同时按主键排序，
+// probe为true时比字段相同的所有项都小，用于定位到某个字段的第一项
+type indexKey[K any] struct {
+	field interface{}
+	key   K
+	probe bool
+}
+
+// index 二级索引，每条数据最多对应一项，extractor返回false时该数据不加入索引
+type index[K, V any] struct {
+	extractor func(v V) (interface{}, bool)
+	less      func(a, b interface{}) bool
+	unique    bool
+	entries   skiplist.SkipList[indexKey[K], struct{}]
+}
+
+func newIndex[K, V any](cmp func(a, b K) int, extractor func(v V) (interface{}, bool), less func(a, b interface{}) bool, o IndexOptions) *index[K, V] {
+	ix := &index[K, V]{
+		extractor: extractor,
+		less:      less,
+		unique:    o.unique,
+	}
+	ix.entries = skiplist.NewSkipList[indexKey[K], struct{}](func(a, b indexKey[K]) int {
+		if c := ix.compare(a.field, b.field); c != 0 {
+			return c
+		}
+		switch {
+		case a.probe && b.probe:
+			return 0
+		case a.probe:
+			return -1
+		case b.probe:
+			return 1
+		}
+		return cmp(a.key, b.key)
+	})
+	return ix
+}
+
+func (ix *index[K, V]) compare(a, b interface{}) int {
+	switch {
+	case ix.less(a, b):
+		return -1
+	case ix.less(b, a):
+		return 1
+	}
+	return 0
+}
+
+func (ix *index[K, V]) add(key K, v V) {
+	if field, ok := ix.extractor(v); ok {
+		_ = ix.entries.Set(indexKey[K]{field: field, key: key}, struct{}{})
+	}
+}
+
+func (ix *index[K, V]) remove(key K, v V) {
+	if field, ok := ix.extractor(v); ok {
+		_ = ix.entries.Del(indexKey[K]{field: field, key: key})
+	}
+}
+
+// find 按主键的顺序遍历字段为field的项，fn返回false时停止
+func (ix *index[K, V]) find(field interface{}, fn func(key K) bool) {
+	iter := ix.entries.Iterator()
+	iter.Seek(indexKey[K]{field: field, probe: true})
+	for ; iter.Valid() && ix.compare(iter.Key().field, field) == 0; iter.HasNext() {
+		if !fn(iter.Key().key) {
+			break
+		}
+	}
+	iter.Close()
+}
+
+// reindex 数据从old变为it时更新所有索引，old或it为nil表示不存在，调用方需持有写锁
+func (d *TypedDB[K, V]) reindex(key K, old, it *item[V]) {
+	for _, ix := range d.indexes {
+		if old != nil {
+			ix.remove(key, old.value)
+		}
+		if it != nil {
+			ix.add(key, it.value)
+		}
+	}
+}
+
+// checkUnique 检查保存value后是否会违反唯一索引，已过期的数据不算冲突，调用方需持有写锁
+func (d *TypedDB[K, V]) checkUnique(key K, value V) error {
+	return d.checkUniqueExcept(key, value, nil)
+}
+
+// checkUniqueExcept 同checkUnique，except返回true的key不算冲突，用于事务中已删除或覆盖的数据
+func (d *TypedDB[K, V]) checkUniqueExcept(key K, value V, except func(other K) bool) error {
+	var (
+		now       = d.now()
+		duplicate bool
+	)
+	for _, ix := range d.indexes {
+		if !ix.unique {
+			continue
+		}
+		field, ok := ix.extractor(value)
+		if !ok {
+			continue
+		}
+		ix.find(field, func(other K) bool {
+			if d.cmp(other, key) == 0 || (except != nil && except(other)) {
+				return true
+			}
+			it, err := d.data.Get(other)
+			duplicate = err == nil && !d.expired(it, now)
+			return !duplicate
+		})
+		if duplicate {
+			return errors.ErrDuplicateIndex
+		}
+	}
+	return nil
+}
+
+// prepare 写入前检查唯一索引并为新数据腾出空间，调用方需持有写锁
+func (d *TypedDB[K, V]) prepare(key K, value V) error {
+	if err := d.checkUnique(key, value); err != nil {
+		return err
+	}
+	return d.makeRoom(key, value)
+}
+
+// CreateIndex 创建名为name的二级索引，extractor从value中取出索引的字段，返回false时该数据不加入索引，
+// less为字段的顺序，创建时会为已有的数据建立索引，之后在保存、删除、过期和淘汰时同步更新
+func (d *TypedDB[K, V]) CreateIndex(name string, extractor func(v V) (interface{}, bool), less func(a, b interface{}) bool, opts ...IndexOption) error {
+	if !d.life.enter() {
+		return errors.ErrClosed
+	}
+	defer d.life.leave()
+	var o IndexOptions
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	d.mu.Lock()
+	if _, ok := d.indexes[name]; ok {
+		d.mu.Unlock()
+		return errors.ErrIndexExists
+	}
+	var (
+		ix   = newIndex[K, V](d.cmp, extractor, less, o)
+		now  = d.now()
+		iter = d.data.Iterator()
+	)
+	for iter.HasNext() {
+		it := iter.Value()
+		if d.expired(it, now) {
+			continue
+		}
+		if ix.unique {
+			var duplicate bool
+			if field, ok := extractor(it.value); ok {
+				ix.find(field, func(K) bool {
+					duplicate = true
+					return false
+				})
+			}
+			if duplicate {
+				iter.Close()
+				d.mu.Unlock()
+				return errors.ErrDuplicateIndex
+			}
+		}
+		ix.add(iter.Key(), it.value)
+	}
+	iter.Close()
+	if d.indexes == nil {
+		d.indexes = make(map[string]*index[K, V])
+	}
+	d.indexes[name] = ix
+	d.mu.Unlock()
+	return nil
+}
+
+// ListByIndex 按索引的顺序返回字段在start和end之间的数据，start或end为nil时表示不限，
+// 选项与Range相同，默认包含start、不包含end
+func (d *TypedDB[K, V]) ListByIndex(name string, start, end interface{}, opts ...RangeOption) ([]V, error) {
+	if !d.life.enter() {
+		return nil, errors.ErrClosed
+	}
+	defer d.life.leave()
+	var o RangeOptions
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	d.mu.RLock()
+	ix, ok := d.indexes[name]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, errors.ErrIndexNotFound
+	}
+	var ret []V
+	d.scanIndex(ix, start, end, o, func(key K, v V) bool {
+		ret = append(ret, v)
+		return o.limit <= 0 || len(ret) < o.limit
+	})
+	return ret, nil
+}
+
+// scanIndex 按索引的顺序遍历字段在start和end之间且未过期的数据，fn返回false时停止，o中的limit不生效
+func (d *TypedDB[K, V]) scanIndex(ix *index[K, V], start, end interface{}, o RangeOptions, fn func(key K, v V) bool) {
+	afterStart := func(field interface{}) bool {
+		if start == nil {
+			return true
+		}
+		c := ix.compare(field, start)
+		return c > 0 || (c == 0 && !o.excludeStart)
+	}
+	beforeEnd := func(field interface{}) bool {
+		if end == nil {
+			return true
+		}
+		c := ix.compare(field, end)
+		return c < 0 || (c == 0 && o.includeEnd)
+	}
+	var (
+		iter = ix.entries.Iterator()
+		now  = d.now()
+	)
+	if o.reverse {
+		if end == nil {
+			iter.SeekToLast()
+		} else {
+			// 先越过所有满足终点约束的项，再向前找到最后一项
+			iter.Seek(indexKey[K]{field: end, probe: true})
+			for iter.Valid() && beforeEnd(iter.Key().field) {
+				iter.HasNext()
+			}
+			if !iter.Valid() {
+				iter.SeekToLast()
+			}
+			for iter.Valid() && !beforeEnd(iter.Key().field) {
+				iter.Prev()
+			}
+		}
+	} else if start == nil {
+		iter.SeekToFirst()
+	} else {
+		iter.Seek(indexKey[K]{field: start, probe: true})
+		for iter.Valid() && !afterStart(iter.Key().field) {
+			iter.HasNext()
+		}
+	}
+	for iter.Valid() && afterStart(iter.Key().field) && beforeEnd(iter.Key().field) {
+		key := iter.Key().key
+		// 数据在锁外重新读取，期间可能已被修改，字段与索引项不同时说明已不在该位置，跳过，
+		// 修改后的数据若仍在范围内会在新的索引项处返回
+		if it, err := d.data.Get(key); err == nil && !d.expired(it, now) {
+			if field, ok := ix.extractor(it.value); ok && ix.compare(field, iter.Key().field) == 0 {
+				if !fn(key, it.value) {
+					break
+				}
+			}
+		}
+		if o.reverse {
+			iter.Prev()
+		} else {
+			iter.HasNext()
+		}
+	}
+	iter.Close()
+}
+
+// CreateIndex 创建名为name的二级索引，extractor从value中取出索引的字段，返回false时该数据不加入索引，
+// less为字段的顺序，创建时会为已有的数据建立索引
+func (d *DB) CreateIndex(name string, extractor func(v interface{}) (interface{}, bool), less func(a, b interface{}) bool, opts ...IndexOption) error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.CreateIndex(name, extractor, less, opts...)
+}
+
+// ListByIndex 按索引的顺序返回字段在start和end之间的数据，start或end为nil时表示不限，默认包含start、不包含end
+func (d *DB) ListByIndex(name string, start, end interface{}, opts ...RangeOption) ([]interface{}, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.ListByIndex(name, start, end, opts...)
+}
@@ -0,0 +1,218 @@
+package simpledb
+
+import (
+	"encoding/gob"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/clocktest"
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+type indexUser struct {
+	Name  string
+	Age   int
+	Email string
+}
+
+func ageOf(v interface{}) (interface{}, bool) {
+	return v.(indexUser).Age, true
+}
+
+func emailOf(v interface{}) (interface{}, bool) {
+	u := v.(indexUser)
+	return u.Email, u.Email != ""
+}
+
+func lessInt(a, b interface{}) bool {
+	return a.(int) < b.(int)
+}
+
+func lessString(a, b interface{}) bool {
+	return strings.Compare(a.(string), b.(string)) < 0
+}
+
+func names(values []interface{}) string {
+	var ret []string
+	for _, v := range values {
+		ret = append(ret, v.(indexUser).Name)
+	}
+	return strings.Join(ret, ",")
+}
+
+func TestDB_CreateIndex(t *testing.T) {
+	db := NewDB()
+	_ = db.Save("a", indexUser{Name: "a", Age: 30})
+	_ = db.Save("b", indexUser{Name: "b", Age: 20})
+	// 创建时为已有的数据建立索引
+	if err := db.CreateIndex("age", ageOf, lessInt); err != nil {
+		t.Errorf("create index failed, err: %+v", err)
+		return
+	}
+	if err := db.CreateIndex("age", ageOf, lessInt); err != errors.ErrIndexExists {
+		t.Errorf("create index failed, err(%+v) should be ErrIndexExists", err)
+		return
+	}
+	_ = db.Save("c", indexUser{Name: "c", Age: 20})
+	_ = db.Save("d", indexUser{Name: "d", Age: 40})
+	if list, _ := db.ListByIndex("age", nil, nil); names(list) != "b,c,a,d" {
+		t.Errorf("list by index failed, list(%s) should be b,c,a,d", names(list))
+		return
+	}
+	if list, _ := db.ListByIndex("age", 20, 30, RangeOptionIncludeEnd()); names(list) != "b,c,a" {
+		t.Errorf("list by index failed, list(%s) should be b,c,a", names(list))
+		return
+	}
+	if list, _ := db.ListByIndex("age", 20, 40, RangeOptionExcludeStart()); names(list) != "a" {
+		t.Errorf("list by index failed, list(%s) should be a", names(list))
+		return
+	}
+	if list, _ := db.ListByIndex("age", nil, 30, RangeOptionIncludeEnd(), RangeOptionReverse(), RangeOptionLimit(2)); names(list) != "a,c" {
+		t.Errorf("list by index failed, list(%s) should be a,c", names(list))
+		return
+	}
+	// 覆盖和删除时同步更新索引
+	_ = db.Save("b", indexUser{Name: "b", Age: 50})
+	_ = db.Delete("c")
+	if list, _ := db.ListByIndex("age", nil, nil); names(list) != "a,d,b" {
+		t.Errorf("list by index failed, list(%s) should be a,d,b", names(list))
+		return
+	}
+	if _, err := db.ListByIndex("name", nil, nil); err != errors.ErrIndexNotFound {
+		t.Errorf("list by index failed, err(%+v) should be ErrIndexNotFound", err)
+	}
+}
+
+func TestDB_UniqueIndex(t *testing.T) {
+	db := NewDB()
+	_ = db.Save("a", indexUser{Name: "a", Email: "x@a"})
+	_ = db.Save("b", indexUser{Name: "b", Email: "x@a"})
+	if err := db.CreateIndex("email", emailOf, lessString, IndexOptionUnique()); err != errors.ErrDuplicateIndex {
+		t.Errorf("create index failed, err(%+v) should be ErrDuplicateIndex", err)
+		return
+	}
+	_ = db.Save("b", indexUser{Name: "b", Email: "x@b"})
+	if err := db.CreateIndex("email", emailOf, lessString, IndexOptionUnique()); err != nil {
+		t.Errorf("create index failed, err: %+v", err)
+		return
+	}
+	if err := db.Save("c", indexUser{Name: "c", Email: "x@a"}); err != errors.ErrDuplicateIndex {
+		t.Errorf("save failed, err(%+v) should be ErrDuplicateIndex", err)
+		return
+	}
+	// 保存自身以及没有字段的数据不冲突
+	if err := db.Save("a", indexUser{Name: "a2", Email: "x@a"}); err != nil {
+		t.Errorf("save failed, err: %+v", err)
+		return
+	}
+	_ = db.Save("c", indexUser{Name: "c"})
+	_ = db.Save("d", indexUser{Name: "d"})
+	if ok, err := db.SaveIfAbsent("e", indexUser{Name: "e", Email: "x@b"}); ok || err != errors.ErrDuplicateIndex {
+		t.Errorf("save if absent failed, ok: %t, err: %+v", ok, err)
+		return
+	}
+	err := db.Update(func(tx *Tx) error {
+		_ = tx.Save("e", indexUser{Name: "e", Email: "x@e"})
+		return tx.Save("f", indexUser{Name: "f", Email: "x@e"})
+	})
+	if err != errors.ErrDuplicateIndex {
+		t.Errorf("update failed, err(%+v) should be ErrDuplicateIndex", err)
+		return
+	}
+	// 删除后字段可以被其他key使用
+	_ = db.Delete("b")
+	if err := db.Save("e", indexUser{Name: "e", Email: "x@b"}); err != nil {
+		t.Errorf("save failed, err: %+v", err)
+		return
+	}
+	// 事务中删除或覆盖的数据不再冲突
+	err = db.Update(func(tx *Tx) error {
+		_ = tx.Delete("a")
+		_ = tx.Save("e", indexUser{Name: "e", Email: "x@e"})
+		_ = tx.Save("f", indexUser{Name: "f", Email: "x@a"})
+		return tx.Save("g", indexUser{Name: "g", Email: "x@b"})
+	})
+	if err != nil {
+		t.Errorf("update failed, err: %+v", err)
+		return
+	}
+	if list, _ := db.ListQuery(Where(In("email", "x@a", "x@b"))); names(list) != "f,g" {
+		t.Errorf("update failed, list(%s) should be f,g", names(list))
+	}
+}
+
+func TestDB_ScanIndexConcurrentUpdate(t *testing.T) {
+	db := NewDB()
+	for _, u := range []indexUser{{"a", 1, ""}, {"b", 2, ""}, {"c", 3, ""}} {
+		_ = db.Save(u.Name, u)
+	}
+	_ = db.CreateIndex("age", ageOf, lessInt)
+	// 遍历期间b被修改到范围之外，不应再返回
+	var got []string
+	db.typed.scanIndex(db.typed.indexes["age"], 1, 3, RangeOptions{}, func(key, v interface{}) bool {
+		if key == "a" {
+			_ = db.Save("b", indexUser{"b", 10, ""})
+		}
+		got = append(got, v.(indexUser).Name)
+		return true
+	})
+	if strings.Join(got, ",") != "a" {
+		t.Errorf("scan index failed, got(%v) should be a", got)
+	}
+}
+
+func TestDB_IndexExpireAndEvict(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock), DBOptionMaxEntries(2))
+	_ = db.CreateIndex("age", ageOf, lessInt)
+	_ = db.Save("a", indexUser{Name: "a", Age: 1}, SaveOptionTTL(1))
+	_ = db.Save("b", indexUser{Name: "b", Age: 2})
+	clock.Advance(time.Second)
+	// 已过期未清理的数据不返回
+	if list, _ := db.ListByIndex("age", nil, nil); names(list) != "b" {
+		t.Errorf("list by index failed, list(%s) should be b", names(list))
+		return
+	}
+	if n := db.typed.indexes["age"].entries.Len(); n != 1 {
+		t.Errorf("expire failed, index len(%d) should be 1", n)
+		return
+	}
+	_ = db.Save("c", indexUser{Name: "c", Age: 3})
+	_ = db.Save("d", indexUser{Name: "d", Age: 4})
+	if list, _ := db.ListByIndex("age", nil, nil); names(list) != "c,d" {
+		t.Errorf("list by index failed, list(%s) should be c,d", names(list))
+		return
+	}
+	if n := db.typed.indexes["age"].entries.Len(); n != 2 {
+		t.Errorf("evict failed, index len(%d) should be 2", n)
+	}
+}
+
+func TestDBWithLSM_IndexTx(t *testing.T) {
+	gob.Register(indexUser{})
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB(DBOptionWithLSM(dir))
+	defer db.Close()
+	_ = db.CreateIndex("age", ageOf, lessInt)
+	_ = db.Save("a", indexUser{Name: "a", Age: 1})
+	err := db.Update(func(tx *Tx) error {
+		_ = tx.Save("a", indexUser{Name: "a", Age: 3})
+		return tx.Save("b", indexUser{Name: "b", Age: 2})
+	})
+	if err != nil {
+		t.Errorf("update failed, err: %+v", err)
+		return
+	}
+	if list, _ := db.ListByIndex("age", nil, nil); names(list) != "b,a" {
+		t.Errorf("list by index failed, list(%s) should be b,a", names(list))
+		return
+	}
+	_ = db.Update(func(tx *Tx) error {
+		return tx.Delete("b")
+	})
+	if list, _ := db.ListByIndex("age", nil, nil); names(list) != "a" {
+		t.Errorf("list by index failed, list(%s) should be a", names(list))
+	}
+}
@@ -0,0 +1,134 @@
+package simpledb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/lsm"
+)
+
+// lifecycle 记录正在执行的操作，关闭后拒绝新的操作并等待已有的操作结束
+type lifecycle struct {
+	mu       sync.RWMutex
+	closed   int32
+	inflight int64
+	// drained 关闭后正在执行的操作全部结束时通知
+	drained chan struct{}
+}
+
+func newLifecycle() lifecycle {
+	return lifecycle{
+		drained: make(chan struct{}, 1),
+	}
+}
+
+// enter 开始一个操作，db已关闭时返回false，返回true时必须调用leave
+func (l *lifecycle) enter() bool {
+	l.mu.RLock()
+	if atomic.LoadInt32(&l.closed) == 1 {
+		l.mu.RUnlock()
+		return false
+	}
+	atomic.AddInt64(&l.inflight, 1)
+	l.mu.RUnlock()
+	return true
+}
+
+func (l *lifecycle) leave() {
+	if atomic.AddInt64(&l.inflight, -1) == 0 && atomic.LoadInt32(&l.closed) == 1 {
+		select {
+		case l.drained <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (l *lifecycle) isClosed() bool {
+	return atomic.LoadInt32(&l.closed) == 1
+}
+
+// close 拒绝新的操作，等待已有的操作结束或者ctx结束，重复关闭返回ErrClosed
+func (l *lifecycle) close(ctx context.Context) error {
+	l.mu.Lock()
+	if l.isClosed() {
+		l.mu.Unlock()
+		return errors.ErrClosed
+	}
+	atomic.StoreInt32(&l.closed, 1)
+	l.mu.Unlock()
+	return l.wait(ctx)
+}
+
+// wait 等待已有的操作结束或者ctx结束，只能在close之后调用
+func (l *lifecycle) wait(ctx context.Context) error {
+	for atomic.LoadInt64(&l.inflight) > 0 {
+		select {
+		case <-l.drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close 关闭db，等待正在执行的操作结束，停止后台任务并将数据刷盘，之后的操作都返回ErrClosed
+func (d *TypedDB[K, V]) Close() error {
+	return d.CloseContext(context.Background())
+}
+
+// CloseContext 同Close，ctx结束时不再等待正在执行的操作并返回ctx的错误，
+// 此时持久化保持打开，在这些操作结束后于后台关闭
+func (d *TypedDB[K, V]) CloseContext(ctx context.Context) error {
+	err := d.life.close(ctx)
+	if err == errors.ErrClosed {
+		return err
+	}
+	if d.stopSweep != nil {
+		d.stopSweep()
+	}
+	if err != nil {
+		go func() {
+			_ = d.life.wait(context.Background())
+			_ = d.closeStorage()
+		}()
+		return err
+	}
+	return d.closeStorage()
+}
+
+// closeStorage 停止过期通知并关闭持久化，必须在所有操作结束后调用
+func (d *TypedDB[K, V]) closeStorage() error {
+	if d.evictions != nil {
+		d.evictions.close()
+	}
+	if tree, ok := d.data.(*lsm.Tree[K, item[V]]); ok {
+		return tree.Close()
+	}
+	if d.log != nil {
+		return d.log.Close()
+	}
+	return nil
+}
+
+// CloseContext 同Close，ctx结束时不再等待正在执行的操作
+func (d *DB) CloseContext(ctx context.Context) error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.CloseContext(ctx)
+}
+
+// emptyIterator db关闭后返回的迭代器，没有任何数据
+type emptyIterator[K, V any] struct{}
+
+func (emptyIterator[K, V]) HasNext() bool { return false }
+func (emptyIterator[K, V]) Prev() bool    { return false }
+func (emptyIterator[K, V]) Seek(K)        {}
+func (emptyIterator[K, V]) SeekToFirst()  {}
+func (emptyIterator[K, V]) SeekToLast()   {}
+func (emptyIterator[K, V]) Valid() bool   { return false }
+func (emptyIterator[K, V]) Key() (key K)  { return key }
+func (emptyIterator[K, V]) Value() (v V)  { return v }
+func (emptyIterator[K, V]) Close()        {}
@@ -0,0 +1,103 @@
+package simpledb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_Close(t *testing.T) {
+	before := runtime.NumGoroutine()
+	db := NewDB(DBOptionWithExpired())
+	_ = db.Save("1", 1)
+	if err := db.Close(); err != nil {
+		t.Errorf("close failed, err: %+v", err)
+		return
+	}
+	if err := db.Save("2", 2); err != errors.ErrClosed {
+		t.Errorf("save failed, err(%+v) should be ErrClosed", err)
+		return
+	}
+	if _, err := db.Get("1"); err != errors.ErrClosed {
+		t.Errorf("get failed, err(%+v) should be ErrClosed", err)
+		return
+	}
+	if _, err := db.Range(nil, nil); err != errors.ErrClosed {
+		t.Errorf("range failed, err(%+v) should be ErrClosed", err)
+		return
+	}
+	if iter := db.Iterator(); iter.HasNext() {
+		t.Errorf("iterator failed, closed db should be empty")
+		return
+	}
+	if err := db.Close(); err != errors.ErrClosed {
+		t.Errorf("close failed, err(%+v) should be ErrClosed", err)
+		return
+	}
+	// 后台清理过期数据的协程已经退出
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("close failed, goroutines(%d) should not be more than %d", after, before)
+	}
+}
+
+func TestDB_CloseContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simpledb")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %+v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	db := NewDB(DBOptionWithWAL(dir))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *Tx) error {
+			close(started)
+			<-release
+			return tx.Save("1", 1)
+		})
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("close failed, err(%+v) should be DeadlineExceeded", err)
+	}
+	close(release)
+	// 超时后日志保持打开，未结束的操作仍然可以写入
+	if err = <-done; err != nil {
+		t.Errorf("update failed, in-flight update should be logged, err: %+v", err)
+		return
+	}
+	db = NewDB(DBOptionWithWAL(dir))
+	if v, _ := db.Get("1"); v != 1 {
+		t.Errorf("reopen failed, v(%+v) should be 1", v)
+		return
+	}
+	_ = db.Close()
+
+	// 等待正在执行的操作结束
+	db = NewDB()
+	started = make(chan struct{})
+	go func() {
+		done <- db.Update(func(tx *Tx) error {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			return tx.Save("1", 1)
+		})
+	}()
+	<-started
+	if err := db.Close(); err != nil {
+		t.Errorf("close failed, err: %+v", err)
+		return
+	}
+	if err := <-done; err != nil {
+		t.Errorf("update failed, in-flight update should finish, err: %+v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package simpledb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/skiplist"
+)
+
+// minNegativePurge 没有后台清理时，缓存的加载失败至少达到该数量才会在写入时清理
+const minNegativePurge = 64
+
+// loadCall 一次正在执行的加载，同一个key并发的未命中共享结果
+type loadCall[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// negative 缓存的加载失败，在expireAt(unix毫秒)之前直接返回err
+type negative struct {
+	err      error
+	expireAt int64
+}
+
+// loads 正在执行的加载和缓存的加载失败，都需持有mu
+type loads[K, V any] struct {
+	mu        sync.Mutex
+	calls     skiplist.SkipList[K, *loadCall[V]]
+	negatives skiplist.SkipList[K, negative]
+	// purgeAt 没有后台清理时，加载失败的数量达到purgeAt时清理一次已过期的，清理后翻倍
+	purgeAt int
+}
+
+func newLoads[K, V any](cmp func(a, b K) int) *loads[K, V] {
+	return &loads[K, V]{
+		calls:     skiplist.NewSkipList[K, *loadCall[V]](cmp),
+		negatives: skiplist.NewSkipList[K, negative](cmp),
+	}
+}
+
+// loadContext 加载使用的ctx，取值来自发起加载的调用方，只在所有等待者都放弃时才取消
+type loadContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c loadContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// GetOrLoad 获取key的值，未命中时调用loader加载并按loader返回的ttl保存，ttl不大于0时不过期，
+// 同一个key并发的未命中只调用一次loader，ctx结束时调用方不再等待，所有调用方都放弃时loader的ctx被取消
+func (d *TypedDB[K, V]) GetOrLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, time.Duration, error), opts ...LoadOption) (value V, err error) {
+	if any(key) == nil {
+		return value, errors.ErrNilKey
+	}
+	if value, err = d.Get(key); err != errors.ErrNotFound {
+		return value, err
+	}
+	var o LoadOptions
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	l := d.loads
+	l.mu.Lock()
+	if n, err := l.negatives.Get(key); err == nil {
+		if n.expireAt > d.now() {
+			l.mu.Unlock()
+			return value, n.err
+		}
+		_ = l.negatives.Del(key)
+	}
+	c, err := l.calls.Get(key)
+	if err != nil {
+		c = d.startLoad(ctx, key, loader, o, func(value V, ttl time.Duration) (V, error) {
+			var opts []SaveOption
+			if ttl > 0 {
+				opts = append(opts, SaveOptionTTLDuration(ttl))
+			}
+			// 加载期间key被写入过时以写入的值为准
+			value, _, err := d.modify(key, opts, func(old V, exists bool) (V, bool) {
+				return value, !exists
+			})
+			return value, err
+		})
+		_ = l.calls.Set(key, c)
+	}
+	c.waiters++
+	l.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		l.mu.Lock()
+		if c.waiters--; c.waiters == 0 {
+			// 没有调用方在等待，取消加载，之后的调用重新发起
+			c.cancel()
+			l.forget(key, c)
+		}
+		l.mu.Unlock()
+		return value, ctx.Err()
+	}
+}
+
+// startLoad 在新的协程中执行loader并通过save保存结果，调用方需持有loads的锁
+func (d *TypedDB[K, V]) startLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, time.Duration, error), o LoadOptions,
+	save func(value V, ttl time.Duration) (V, error)) *loadCall[V] {
+	lctx, cancel := context.WithCancel(context.Background())
+	c := &loadCall[V]{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go func() {
+		value, ttl, err := loader(loadContext{Context: lctx, values: ctx})
+		// 因为所有调用方都放弃而失败时不缓存错误
+		cacheErr := err != nil && o.negativeTTL > 0 && lctx.Err() == nil
+		if err == nil {
+			value, err = save(value, ttl)
+		}
+		cancel()
+		l := d.loads
+		l.mu.Lock()
+		if cacheErr {
+			now := d.now()
+			// 未开启过期时间时没有后台清理，不再访问的key只能在这里清理
+			if !d.withExpired() && l.negatives.Len() >= l.purgeAt {
+				l.purgeLocked(now)
+				l.purgeAt = 2 * l.negatives.Len()
+				if l.purgeAt < minNegativePurge {
+					l.purgeAt = minNegativePurge
+				}
+			}
+			_ = l.negatives.Set(key, negative{err: err, expireAt: now + millis(o.negativeTTL)})
+		}
+		c.value, c.err = value, err
+		l.forget(key, c)
+		l.mu.Unlock()
+		close(c.done)
+	}()
+	return c
+}
+
+// forget 加载结束或被取消后移除，key可能已经开始了新的加载，调用方需持有mu
+func (l *loads[K, V]) forget(key K, c *loadCall[V]) {
+	if cur, err := l.calls.Get(key); err == nil && cur == c {
+		_ = l.calls.Del(key)
+	}
+}
+
+// purge 清理已过期的加载失败，调用方不能持有mu
+func (l *loads[K, V]) purge(now int64) {
+	l.mu.Lock()
+	l.purgeLocked(now)
+	l.mu.Unlock()
+}
+
+// purgeLocked 同purge，调用方需持有mu
+func (l *loads[K, V]) purgeLocked(now int64) {
+	iter := l.negatives.Iterator()
+	for iter.HasNext() {
+		if iter.Value().expireAt <= now {
+			_ = l.negatives.Del(iter.Key())
+		}
+	}
+	iter.Close()
+}
+
+// GetOrLoad 获取key的值，未命中时调用loader加载并按loader返回的ttl保存，同一个key并发的未命中只调用一次loader
+func (d *DB) GetOrLoad(ctx context.Context, key interface{}, loader func(ctx context.Context) (interface{}, time.Duration, error), opts ...LoadOption) (interface{}, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if err := d.isValidKey(key); err != nil {
+		return nil, err
+	}
+	return d.typed.GetOrLoad(ctx, key, loader, opts...)
+}
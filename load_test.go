@@ -0,0 +1,138 @@
+package simpledb
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/clocktest"
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_GetOrLoad(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock))
+	var (
+		calls   int32
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+		values  = make([]interface{}, 10)
+	)
+	loader := func(ctx context.Context) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v", time.Minute, nil
+	}
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = db.GetOrLoad(context.Background(), "1", loader)
+		}(i)
+	}
+	// 等待所有调用方进入等待
+	for {
+		db.typed.loads.mu.Lock()
+		c, err := db.typed.loads.calls.Get("1")
+		waiting := err == nil && c.waiters == len(values)
+		db.typed.loads.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("get or load failed, calls(%d) should be 1", calls)
+		return
+	}
+	for _, v := range values {
+		if v != "v" {
+			t.Errorf("get or load failed, v(%+v) should be v", v)
+			return
+		}
+	}
+	if ttl, _ := db.TTL("1"); ttl != time.Minute {
+		t.Errorf("get or load failed, ttl(%s) should be 1m", ttl)
+		return
+	}
+	// 命中时不再调用loader
+	_, _ = db.GetOrLoad(context.Background(), "1", loader)
+	clock.Advance(time.Minute)
+	_, _ = db.GetOrLoad(context.Background(), "1", loader)
+	if calls != 2 {
+		t.Errorf("get or load failed, calls(%d) should be 2", calls)
+	}
+}
+
+func TestDB_GetOrLoadNegativeTTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionClock(clock))
+	var (
+		calls   int
+		errLoad = stderrors.New("load failed")
+	)
+	loader := func(ctx context.Context) (interface{}, time.Duration, error) {
+		calls++
+		return nil, 0, errLoad
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.GetOrLoad(context.Background(), "1", loader, LoadOptionNegativeTTL(time.Second)); err != errLoad {
+			t.Errorf("get or load failed, err(%+v) should be errLoad", err)
+			return
+		}
+	}
+	if calls != 1 {
+		t.Errorf("negative ttl failed, calls(%d) should be 1", calls)
+		return
+	}
+	clock.Advance(time.Second)
+	_, _ = db.GetOrLoad(context.Background(), "1", loader)
+	_, _ = db.GetOrLoad(context.Background(), "1", loader)
+	if calls != 3 {
+		t.Errorf("negative ttl failed, calls(%d) should be 3", calls)
+		return
+	}
+	// 保存后不再返回缓存的错误
+	_, _ = db.GetOrLoad(context.Background(), "2", loader, LoadOptionNegativeTTL(time.Second))
+	_ = db.Save("2", 2)
+	if v, err := db.GetOrLoad(context.Background(), "2", loader); v != 2 || err != nil {
+		t.Errorf("get or load failed, v: %+v, err: %+v", v, err)
+		return
+	}
+	// 没有开启过期时间时，不再访问的key在之后缓存新的失败时被清理
+	for i := 0; i < 3*minNegativePurge; i++ {
+		_, _ = db.GetOrLoad(context.Background(), i, loader, LoadOptionNegativeTTL(time.Second))
+		clock.Advance(100 * time.Millisecond)
+	}
+	if n := db.typed.loads.negatives.Len(); n > minNegativePurge {
+		t.Errorf("negative ttl failed, len(%d) should be at most %d", n, minNegativePurge)
+	}
+}
+
+func TestDB_GetOrLoadCancel(t *testing.T) {
+	db := NewDB()
+	canceled := make(chan error, 1)
+	loader := func(ctx context.Context) (interface{}, time.Duration, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, 0, ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.GetOrLoad(ctx, "1", loader); err != context.DeadlineExceeded {
+		t.Errorf("get or load failed, err(%+v) should be DeadlineExceeded", err)
+		return
+	}
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("get or load failed, loader ctx err(%+v) should be Canceled", err)
+		return
+	}
+	if _, err := db.GetOrLoad(context.Background(), nil, loader); err != errors.ErrNilKey {
+		t.Errorf("get or load failed, err(%+v) should be ErrNilKey", err)
+	}
+}
@@ -0,0 +1,62 @@
+package lsm
+
+import "hash/fnv"
+
+// bloom 布隆过滤器，最后一个字节记录哈希函数的个数
+type bloom []byte
+
+func newBloom(keys [][]byte, bitsPerKey int) bloom {
+	k := uint8(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+	bits := len(keys) * bitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+	n := (bits + 7) / 8
+	bits = n * 8
+	b := make(bloom, n+1)
+	b[n] = k
+	for _, key := range keys {
+		h := bloomHash(key)
+		delta := h>>17 | h<<15
+		for i := uint8(0); i < k; i++ {
+			pos := h % uint32(bits)
+			b[pos/8] |= 1 << (pos % 8)
+			h += delta
+		}
+	}
+	return b
+}
+
+// mayContain 返回false时key一定不存在
+func (b bloom) mayContain(key []byte) bool {
+	if len(b) < 2 {
+		return true
+	}
+	var (
+		n    = len(b) - 1
+		k    = b[n]
+		bits = uint32(n * 8)
+		h    = bloomHash(key)
+	)
+	delta := h>>17 | h<<15
+	for i := uint8(0); i < k; i++ {
+		pos := h % bits
+		if b[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+func bloomHash(key []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return h.Sum32()
+}
@@ -0,0 +1,356 @@
+package lsm
+
+import (
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+func (t *Tree[K, V]) background() {
+	defer close(t.done)
+	for {
+		select {
+		case <-t.closeC:
+			return
+		case <-t.flushC:
+		}
+		if err := t.flush(); err != nil {
+			t.setBGErr(err)
+			continue
+		}
+		for {
+			select {
+			case <-t.closeC:
+				return
+			default:
+			}
+			c := t.pickCompaction()
+			if c == nil {
+				break
+			}
+			if err := t.compact(c); err != nil {
+				t.setBGErr(err)
+				break
+			}
+		}
+	}
+}
+
+func (t *Tree[K, V]) setBGErr(err error) {
+	t.mu.Lock()
+	t.bgErr = err
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// flush 将imm写入L0
+func (t *Tree[K, V]) flush() error {
+	t.mu.RLock()
+	imm := t.imm
+	t.mu.RUnlock()
+	if imm == nil {
+		return nil
+	}
+	meta, err := t.writeTable(imm)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// imm之后的日志都还没有写入table
+	t.manifest.LogNum = t.mem.num
+	if err = t.installLevel0(meta); err != nil {
+		return err
+	}
+	_ = imm.log.Close()
+	t.removeObsoleteFiles()
+	t.imm = nil
+	t.cond.Broadcast()
+	return nil
+}
+
+// writeTable 将memtable中的数据(包括删除标记)写入一个新的table
+func (t *Tree[K, V]) writeTable(m *memtable[K, V]) (tableMeta, error) {
+	num := t.nextFileLocked()
+	w, err := newTableWriter(tableName(t.opts.Dir, num), t.opts.BlockSize)
+	if err != nil {
+		return tableMeta{}, err
+	}
+	iter := m.list.Iterator()
+	for iter.HasNext() {
+		v := iter.Value()
+		if err = w.add(v.rawKey, v.rawValue, v.deleted); err != nil {
+			iter.Close()
+			w.abort()
+			return tableMeta{}, err
+		}
+	}
+	iter.Close()
+	meta, err := w.finish(t.opts.BloomBitsPerKey)
+	if err != nil {
+		w.abort()
+		return meta, err
+	}
+	meta.Num = num
+	return meta, nil
+}
+
+func (t *Tree[K, V]) nextFileLocked() uint64 {
+	t.mu.Lock()
+	num := t.nextFile()
+	t.mu.Unlock()
+	return num
+}
+
+// installLevel0 把新的table放到L0最前面并写入manifest，调用方需持有写锁
+func (t *Tree[K, V]) installLevel0(meta tableMeta) error {
+	tb, err := openTable(tableName(t.opts.Dir, meta.Num), meta, &t.opts)
+	if err != nil {
+		return err
+	}
+	levels := t.current.levels
+	levels[0] = append([]*table[K, V]{tb}, levels[0]...)
+	return t.install(levels)
+}
+
+// install 写入manifest并切换到新的version，调用方需持有写锁
+func (t *Tree[K, V]) install(levels [numLevels][]*table[K, V]) error {
+	v := newVersion(levels)
+	t.manifest.Levels = v.metas()
+	if err := writeManifest(t.opts.Dir, t.manifest); err != nil {
+		v.unref()
+		return err
+	}
+	old := t.current
+	t.current = v
+	old.unref()
+	return nil
+}
+
+// compaction 一次压缩的输入，inputs[0]来自level，inputs[1]来自level+1
+type compaction[K, V any] struct {
+	level  int
+	inputs [2][]*table[K, V]
+	v      *version[K, V]
+}
+
+func (t *Tree[K, V]) maxLevelSize(level int) int64 {
+	size := t.opts.LevelSizeBase
+	for i := 1; i < level; i++ {
+		size *= t.opts.LevelSizeMultiplier
+	}
+	return size
+}
+
+// pickCompaction 选出得分最高且需要压缩的层，L0按table数计分，其余层按大小计分
+func (t *Tree[K, V]) pickCompaction() *compaction[K, V] {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	v := t.current
+	var (
+		best  = -1
+		score = 1.0
+	)
+	for level := 0; level < numLevels-1; level++ {
+		var s float64
+		if level == 0 {
+			s = float64(len(v.levels[0])) / float64(t.opts.L0CompactionTrigger)
+		} else {
+			s = float64(levelSize(v.levels[level])) / float64(t.maxLevelSize(level))
+		}
+		if s >= score {
+			best, score = level, s
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	c := &compaction[K, V]{
+		level: best,
+		v:     v,
+	}
+	if best == 0 {
+		c.inputs[0] = append(c.inputs[0], v.levels[0]...)
+	} else {
+		// 从上次压缩结束的位置开始轮流选择
+		tables := v.levels[best]
+		idx := 0
+		if ptr := t.compactPointer[best]; ptr != nil {
+			for idx < len(tables) && !t.opts.less(*ptr, tables[idx].largest) {
+				idx++
+			}
+			if idx == len(tables) {
+				idx = 0
+			}
+		}
+		c.inputs[0] = []*table[K, V]{tables[idx]}
+	}
+	smallest, largest := t.keyRange(c.inputs[0])
+	for _, tb := range v.levels[best+1] {
+		if !t.opts.less(tb.largest, smallest) && !t.opts.less(largest, tb.smallest) {
+			c.inputs[1] = append(c.inputs[1], tb)
+		}
+	}
+	v.ref()
+	return c
+}
+
+func (t *Tree[K, V]) keyRange(tables []*table[K, V]) (smallest, largest K) {
+	for i, tb := range tables {
+		if i == 0 || t.opts.less(tb.smallest, smallest) {
+			smallest = tb.smallest
+		}
+		if i == 0 || t.opts.less(largest, tb.largest) {
+			largest = tb.largest
+		}
+	}
+	return
+}
+
+// isBaseLevel 更深的层中没有table包含key时，删除标记可以被丢弃
+func (t *Tree[K, V]) isBaseLevel(v *version[K, V], level int, key K) bool {
+	for l := level + 1; l < numLevels; l++ {
+		if len(t.candidates(l, v.levels[l], key)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compact 归并输入的table，按大小切分后写入下一层
+func (t *Tree[K, V]) compact(c *compaction[K, V]) error {
+	defer c.v.unref()
+	var (
+		out     = c.level + 1
+		srcs    []source[K, V]
+		metas   []tableMeta
+		w       *tableWriter
+		lastKey *K
+	)
+	for _, tb := range c.inputs[0] {
+		srcs = append(srcs, newTableSource(&t.opts, tb))
+	}
+	if len(c.inputs[1]) > 0 {
+		srcs = append(srcs, newTableSource(&t.opts, c.inputs[1]...))
+	}
+	m := newMergeIterator(&t.opts, false, srcs...)
+	abort := func() {
+		if w != nil {
+			w.abort()
+		}
+		for _, meta := range metas {
+			_ = removeTable(t.opts.Dir, meta.Num)
+		}
+	}
+	for m.next() {
+		key := m.curKey
+		lastKey = &key
+		if m.curDeleted && t.isBaseLevel(c.v, out, m.curKey) {
+			continue
+		}
+		if w == nil {
+			num := t.nextFileLocked()
+			var err error
+			if w, err = newTableWriter(tableName(t.opts.Dir, num), t.opts.BlockSize); err != nil {
+				abort()
+				return err
+			}
+			metas = append(metas, tableMeta{Num: num})
+		}
+		if err := w.add(m.curRawKey, m.curRawValue, m.curDeleted); err != nil {
+			abort()
+			return err
+		}
+		if int64(w.size()) >= t.opts.TableSize {
+			if err := t.finishTable(w, metas); err != nil {
+				w = nil
+				abort()
+				return err
+			}
+			w = nil
+		}
+	}
+	if m.error != nil {
+		abort()
+		return m.error
+	}
+	if w != nil {
+		if err := t.finishTable(w, metas); err != nil {
+			w = nil
+			abort()
+			return err
+		}
+	}
+	var outputs []*table[K, V]
+	for _, meta := range metas {
+		tb, err := openTable(tableName(t.opts.Dir, meta.Num), meta, &t.opts)
+		if err != nil {
+			abort()
+			return err
+		}
+		outputs = append(outputs, tb)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	levels := t.current.levels
+	levels[c.level] = removeTables(levels[c.level], c.inputs[0])
+	levels[out] = removeTables(levels[out], c.inputs[1])
+	levels[out] = append(levels[out], outputs...)
+	sortTables(t.opts.less, levels[out])
+	// 不再被引用时删除输入的table
+	markObsolete(c.inputs, 1)
+	if err := t.install(levels); err != nil {
+		markObsolete(c.inputs, 0)
+		return err
+	}
+	if lastKey != nil {
+		t.compactPointer[c.level] = lastKey
+	}
+	return nil
+}
+
+func (t *Tree[K, V]) finishTable(w *tableWriter, metas []tableMeta) error {
+	meta, err := w.finish(t.opts.BloomBitsPerKey)
+	if err != nil {
+		return err
+	}
+	meta.Num = metas[len(metas)-1].Num
+	metas[len(metas)-1] = meta
+	return nil
+}
+
+func markObsolete[K, V any](inputs [2][]*table[K, V], obsolete int32) {
+	for _, tables := range inputs {
+		for _, tb := range tables {
+			atomic.StoreInt32(&tb.obsolete, obsolete)
+		}
+	}
+}
+
+func removeTable(dir string, num uint64) error {
+	return os.Remove(tableName(dir, num))
+}
+
+func removeTables[K, V any](tables, removed []*table[K, V]) []*table[K, V] {
+	ret := make([]*table[K, V], 0, len(tables))
+	for _, tb := range tables {
+		keep := true
+		for _, r := range removed {
+			if tb == r {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			ret = append(ret, tb)
+		}
+	}
+	return ret
+}
+
+func sortTables[K, V any](less func(a, b K) bool, tables []*table[K, V]) {
+	sort.Slice(tables, func(i, j int) bool {
+		return less(tables[i].smallest, tables[j].smallest)
+	})
+}
@@ -0,0 +1,421 @@
+package lsm
+
+import (
+	"sort"
+
+	"github.com/byronzhu-haha/simpledb/skiplist"
+)
+
+// source 归并时的一路有序数据，需要先定位，valid表示当前是否指向一条数据
+type source[K, V any] interface {
+	seekToFirst()
+	seekToLast()
+	// seek 定位到第一条不小于key的数据
+	seek(key K)
+	next()
+	prev()
+	valid() bool
+	key() K
+	rawKey() []byte
+	rawValue() []byte
+	// value 解码后的值，memtable中的值无需解码
+	value() (V, error)
+	deleted() bool
+	err() error
+	close()
+}
+
+// memSource 遍历memtable
+type memSource[K, V any] struct {
+	iter skiplist.Iterator[K, memValue[V]]
+}
+
+func (m *memSource[K, V]) seekToFirst()      { m.iter.SeekToFirst() }
+func (m *memSource[K, V]) seekToLast()       { m.iter.SeekToLast() }
+func (m *memSource[K, V]) seek(key K)        { m.iter.Seek(key) }
+func (m *memSource[K, V]) next()             { m.iter.HasNext() }
+func (m *memSource[K, V]) prev()             { m.iter.Prev() }
+func (m *memSource[K, V]) valid() bool       { return m.iter.Valid() }
+func (m *memSource[K, V]) key() K            { return m.iter.Key() }
+func (m *memSource[K, V]) rawKey() []byte    { return m.iter.Value().rawKey }
+func (m *memSource[K, V]) rawValue() []byte  { return m.iter.Value().rawValue }
+func (m *memSource[K, V]) value() (V, error) { return m.iter.Value().value, nil }
+func (m *memSource[K, V]) deleted() bool     { return m.iter.Value().deleted }
+func (m *memSource[K, V]) err() error        { return nil }
+func (m *memSource[K, V]) close()            { m.iter.Close() }
+
+// tableSource 遍历一组互不重叠且有序的table，按块读取数据
+type tableSource[K, V any] struct {
+	tables []*table[K, V]
+	opts   *Options[K, V]
+	// 当前所在的table、块以及块中的位置，entries为nil时无效
+	ti, bi  int
+	entries []tableEntry[K]
+	pos     int
+	error   error
+}
+
+func newTableSource[K, V any](opts *Options[K, V], tables ...*table[K, V]) *tableSource[K, V] {
+	return &tableSource[K, V]{
+		tables: tables,
+		opts:   opts,
+	}
+}
+
+func (s *tableSource[K, V]) seekToFirst() {
+	s.forward(0, 0)
+}
+
+func (s *tableSource[K, V]) seekToLast() {
+	ti := len(s.tables) - 1
+	bi := 0
+	if ti >= 0 {
+		bi = len(s.tables[ti].index) - 1
+	}
+	s.backward(ti, bi)
+}
+
+func (s *tableSource[K, V]) seek(key K) {
+	ti := sort.Search(len(s.tables), func(i int) bool {
+		return !s.opts.less(s.tables[i].largest, key)
+	})
+	if ti == len(s.tables) {
+		s.entries = nil
+		return
+	}
+	index := s.tables[ti].index
+	bi := sort.Search(len(index), func(i int) bool {
+		return !s.opts.less(index[i].lastKey, key)
+	})
+	if !s.forward(ti, bi) || s.ti != ti || s.bi != bi {
+		return
+	}
+	s.pos = sort.Search(len(s.entries), func(i int) bool {
+		return !s.opts.less(s.entries[i].key, key)
+	})
+	if s.pos == len(s.entries) {
+		s.forward(ti, bi+1)
+	}
+}
+
+func (s *tableSource[K, V]) next() {
+	s.pos++
+	if s.pos >= len(s.entries) {
+		s.forward(s.ti, s.bi+1)
+	}
+}
+
+func (s *tableSource[K, V]) prev() {
+	s.pos--
+	if s.pos < 0 {
+		s.backward(s.ti, s.bi-1)
+	}
+}
+
+// forward 从第ti个table的第bi块开始向后找到第一条数据
+func (s *tableSource[K, V]) forward(ti, bi int) bool {
+	for ti < len(s.tables) {
+		if bi >= len(s.tables[ti].index) {
+			ti, bi = ti+1, 0
+			continue
+		}
+		if !s.load(ti, bi) {
+			return false
+		}
+		if len(s.entries) > 0 {
+			s.pos = 0
+			return true
+		}
+		bi++
+	}
+	s.entries = nil
+	return false
+}
+
+// backward 从第ti个table的第bi块开始向前找到最后一条数据
+func (s *tableSource[K, V]) backward(ti, bi int) bool {
+	for ti >= 0 {
+		if bi < 0 {
+			ti--
+			if ti >= 0 {
+				bi = len(s.tables[ti].index) - 1
+			}
+			continue
+		}
+		if !s.load(ti, bi) {
+			return false
+		}
+		if len(s.entries) > 0 {
+			s.pos = len(s.entries) - 1
+			return true
+		}
+		bi--
+	}
+	s.entries = nil
+	return false
+}
+
+func (s *tableSource[K, V]) load(ti, bi int) bool {
+	entries, err := s.tables[ti].readEntries(s.tables[ti].index[bi])
+	if err != nil {
+		s.error = err
+		s.entries = nil
+		return false
+	}
+	s.ti, s.bi, s.entries = ti, bi, entries
+	return true
+}
+
+func (s *tableSource[K, V]) valid() bool {
+	return s.entries != nil && s.pos >= 0 && s.pos < len(s.entries)
+}
+
+func (s *tableSource[K, V]) key() K            { return s.entries[s.pos].key }
+func (s *tableSource[K, V]) rawKey() []byte    { return s.entries[s.pos].rawKey }
+func (s *tableSource[K, V]) rawValue() []byte  { return s.entries[s.pos].rawValue }
+func (s *tableSource[K, V]) value() (V, error) { return s.opts.DecodeValue(s.rawValue()) }
+func (s *tableSource[K, V]) deleted() bool     { return s.entries[s.pos].deleted }
+func (s *tableSource[K, V]) err() error        { return s.error }
+func (s *tableSource[K, V]) close()            { s.entries = nil }
+
+// mergeIterator 归并多路有序数据，srcs按新到旧排列，相等的key只保留最新的一条。
+// 正向遍历时所有source都位于当前key及之后，反向遍历时都位于当前key及之前，
+// 切换方向时重新定位所有的source
+type mergeIterator[K, V any] struct {
+	srcs        []source[K, V]
+	opts        *Options[K, V]
+	skipDeleted bool
+	positioned  bool
+	reverse     bool
+	cur         source[K, V]
+	curKey      K
+	curRawKey   []byte
+	curRawValue []byte
+	curValue    V
+	curDeleted  bool
+	curDecoded  bool
+	curErr      error
+	error       error
+}
+
+func newMergeIterator[K, V any](opts *Options[K, V], skipDeleted bool, srcs ...source[K, V]) *mergeIterator[K, V] {
+	return &mergeIterator[K, V]{
+		srcs:        srcs,
+		opts:        opts,
+		skipDeleted: skipDeleted,
+	}
+}
+
+func (m *mergeIterator[K, V]) seekToFirst() bool {
+	for _, src := range m.srcs {
+		src.seekToFirst()
+	}
+	m.positioned, m.reverse = true, false
+	return m.settle()
+}
+
+func (m *mergeIterator[K, V]) seekToLast() bool {
+	for _, src := range m.srcs {
+		src.seekToLast()
+	}
+	m.positioned, m.reverse = true, true
+	return m.settle()
+}
+
+func (m *mergeIterator[K, V]) seek(key K) bool {
+	for _, src := range m.srcs {
+		src.seek(key)
+	}
+	m.positioned, m.reverse = true, false
+	return m.settle()
+}
+
+// next 移动到下一条数据，未定位时从第一条开始
+func (m *mergeIterator[K, V]) next() bool {
+	if !m.positioned {
+		return m.seekToFirst()
+	}
+	if m.cur == nil {
+		return false
+	}
+	if m.reverse {
+		// 所有的source都移动到当前key及之后
+		for _, src := range m.srcs {
+			src.seek(m.curKey)
+		}
+		m.reverse = false
+	}
+	m.step()
+	return m.settle()
+}
+
+func (m *mergeIterator[K, V]) prev() bool {
+	if m.cur == nil {
+		return false
+	}
+	if !m.reverse {
+		// 所有的source都移动到当前key之前
+		for _, src := range m.srcs {
+			src.seek(m.curKey)
+			if src.valid() {
+				src.prev()
+			} else {
+				src.seekToLast()
+			}
+		}
+		m.reverse = true
+	}
+	m.step()
+	return m.settle()
+}
+
+// step 跳过所有与当前key相等的数据
+func (m *mergeIterator[K, V]) step() {
+	for _, src := range m.srcs {
+		if !src.valid() || m.opts.Cmp(src.key(), m.curKey) != 0 {
+			continue
+		}
+		if m.reverse {
+			src.prev()
+		} else {
+			src.next()
+		}
+	}
+}
+
+// settle 按方向选出当前的数据，相等的key取最新的一条，需要时跳过删除标记
+func (m *mergeIterator[K, V]) settle() bool {
+	for {
+		m.cur = nil
+		for _, src := range m.srcs {
+			if !src.valid() {
+				if err := src.err(); err != nil && m.error == nil {
+					m.error = err
+				}
+				continue
+			}
+			if m.cur == nil {
+				m.cur = src
+				continue
+			}
+			c := m.opts.Cmp(src.key(), m.cur.key())
+			if (!m.reverse && c < 0) || (m.reverse && c > 0) {
+				m.cur = src
+			}
+		}
+		if m.cur == nil || m.error != nil {
+			m.cur = nil
+			return false
+		}
+		src := m.cur
+		m.curKey = src.key()
+		m.curRawKey = src.rawKey()
+		m.curRawValue = src.rawValue()
+		m.curDeleted = src.deleted()
+		m.curDecoded = false
+		if _, ok := src.(*memSource[K, V]); ok {
+			m.curValue, _ = src.value()
+			m.curDecoded = true
+		}
+		if m.curDeleted && m.skipDeleted {
+			m.step()
+			continue
+		}
+		return true
+	}
+}
+
+func (m *mergeIterator[K, V]) close() {
+	for _, src := range m.srcs {
+		src.close()
+	}
+	m.cur = nil
+}
+
+func (m *mergeIterator[K, V]) valid() bool {
+	return m.cur != nil
+}
+
+func (m *mergeIterator[K, V]) value() (V, error) {
+	if !m.curDecoded {
+		m.curValue, m.curErr = m.opts.DecodeValue(m.curRawValue)
+		m.curDecoded = true
+	}
+	return m.curValue, m.curErr
+}
+
+// iterator 对外的迭代器，持有version直到Close
+type iterator[K, V any] struct {
+	m     *mergeIterator[K, V]
+	v     *version[K, V]
+	key   K
+	value V
+}
+
+func (i *iterator[K, V]) HasNext() bool {
+	return i.m != nil && i.update(i.m.next())
+}
+
+func (i *iterator[K, V]) Prev() bool {
+	return i.m != nil && i.update(i.m.prev())
+}
+
+func (i *iterator[K, V]) Seek(key K) {
+	if i.m != nil {
+		i.update(i.m.seek(key))
+	}
+}
+
+func (i *iterator[K, V]) SeekToFirst() {
+	if i.m != nil {
+		i.update(i.m.seekToFirst())
+	}
+}
+
+func (i *iterator[K, V]) SeekToLast() {
+	if i.m != nil {
+		i.update(i.m.seekToLast())
+	}
+}
+
+func (i *iterator[K, V]) Valid() bool {
+	return i.m != nil && i.m.valid()
+}
+
+func (i *iterator[K, V]) update(ok bool) bool {
+	if !ok {
+		i.reset()
+		return false
+	}
+	i.key = i.m.curKey
+	i.value, _ = i.m.value()
+	return true
+}
+
+func (i *iterator[K, V]) Key() K {
+	return i.key
+}
+
+func (i *iterator[K, V]) Value() V {
+	return i.value
+}
+
+func (i *iterator[K, V]) Close() {
+	if i.m != nil {
+		i.m.close()
+		i.m = nil
+	}
+	if i.v != nil {
+		i.v.unref()
+		i.v = nil
+	}
+	i.reset()
+}
+
+func (i *iterator[K, V]) reset() {
+	var (
+		key   K
+		value V
+	)
+	i.key, i.value = key, value
+}
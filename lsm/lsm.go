@@ -0,0 +1,645 @@
+package lsm
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/skiplist"
+	"github.com/byronzhu-haha/simpledb/wal"
+)
+
+const (
+	defaultMemtableSize        = 4 << 20
+	defaultTableSize           = 2 << 20
+	defaultBlockSize           = 4 << 10
+	defaultBloomBitsPerKey     = 10
+	defaultL0CompactionTrigger = 4
+	defaultLevelSizeBase       = 10 << 20
+	defaultLevelSizeMultiplier = 10
+	// memValue的估算开销
+	entryOverhead = 32
+)
+
+type Options[K, V any] struct {
+	Dir string
+	// Cmp 必须是严格的全序，返回0的key被视为同一个key
+	Cmp         func(a, b K) int
+	EncodeKey   func(key K) ([]byte, error)
+	DecodeKey   func(data []byte) (K, error)
+	EncodeValue func(value V) ([]byte, error)
+	DecodeValue func(data []byte) (V, error)
+	// MemtableSize memtable达到该大小后被冻结并写入L0
+	MemtableSize int64
+	// TableSize 压缩时输出的单个table的大小
+	TableSize       int64
+	BlockSize       int
+	BloomBitsPerKey int
+	// L0CompactionTrigger L0的table数达到该值时压缩到L1
+	L0CompactionTrigger int
+	// LevelSizeBase L1的大小上限，之后每层乘以LevelSizeMultiplier
+	LevelSizeBase       int64
+	LevelSizeMultiplier int64
+	WAL                 wal.Options
+}
+
+func (o *Options[K, V]) less(a, b K) bool {
+	return o.Cmp(a, b) < 0
+}
+
+func (o *Options[K, V]) setDefaults() {
+	if o.MemtableSize <= 0 {
+		o.MemtableSize = defaultMemtableSize
+	}
+	if o.TableSize <= 0 {
+		o.TableSize = defaultTableSize
+	}
+	if o.BlockSize <= 0 {
+		o.BlockSize = defaultBlockSize
+	}
+	if o.BloomBitsPerKey <= 0 {
+		o.BloomBitsPerKey = defaultBloomBitsPerKey
+	}
+	if o.L0CompactionTrigger <= 0 {
+		o.L0CompactionTrigger = defaultL0CompactionTrigger
+	}
+	if o.LevelSizeBase <= 0 {
+		o.LevelSizeBase = defaultLevelSizeBase
+	}
+	if o.LevelSizeMultiplier <= 1 {
+		o.LevelSizeMultiplier = defaultLevelSizeMultiplier
+	}
+}
+
+// memValue memtable中保存的值，同时保留编码结果以便写入table
+type memValue[V any] struct {
+	value    V
+	rawKey   []byte
+	rawValue []byte
+	deleted  bool
+}
+
+// record 对应的日志记录
+func (v memValue[V]) record() wal.Record {
+	rec := wal.Record{
+		Op:    wal.OpSave,
+		Key:   v.rawKey,
+		Value: v.rawValue,
+	}
+	if v.deleted {
+		rec.Op = wal.OpDelete
+	}
+	return rec
+}
+
+// memtable 以跳表作为内存中的有序表，每个memtable对应一个预写日志
+type memtable[K, V any] struct {
+	list skiplist.SkipList[K, memValue[V]]
+	log  *wal.Log
+	num  uint64
+	size int64
+}
+
+func (t *Tree[K, V]) newMemtable(num uint64) (*memtable[K, V], error) {
+	m := &memtable[K, V]{
+		list: skiplist.NewSkipList[K, memValue[V]](t.opts.Cmp),
+		num:  num,
+	}
+	if num == 0 {
+		return m, nil
+	}
+	log, err := wal.Open(logName(t.opts.Dir, num), t.opts.WAL, func(r wal.Record) error {
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.log = log
+	return m, nil
+}
+
+func (m *memtable[K, V]) set(key K, v memValue[V]) error {
+	if err := m.list.Set(key, v); err != nil {
+		return err
+	}
+	m.size += int64(len(v.rawKey)+len(v.rawValue)) + entryOverhead
+	return nil
+}
+
+// Tree LSM树，memtable写满后冻结并写入L0，后台按层合并table，
+// 实现了skiplist.SkipList接口，可以直接替换内存中的跳表
+type Tree[K, V any] struct {
+	opts Options[K, V]
+	mu   sync.RWMutex
+	// imm被写入L0之前，写满的mem需要等待
+	cond    *sync.Cond
+	mem     *memtable[K, V]
+	imm     *memtable[K, V]
+	current *version[K, V]
+	// 只在后台协程中修改
+	manifest manifest
+	// 每一层下一次压缩开始的位置
+	compactPointer [numLevels]*K
+	bgErr          error
+	closed         bool
+	// count 未删除的数据条数，打开时归并得到，之后在写入时维护
+	count  int
+	flushC chan struct{}
+	closeC chan struct{}
+	done   chan struct{}
+}
+
+// Open 打开dir下的LSM树，根据manifest恢复各层的table，并回放未写入table的日志
+func Open[K, V any](opts Options[K, V]) (*Tree[K, V], error) {
+	opts.setDefaults()
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree[K, V]{
+		opts:     opts,
+		manifest: m,
+		flushC:   make(chan struct{}, 1),
+		closeC:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	var levels [numLevels][]*table[K, V]
+	for level, metas := range m.Levels {
+		for _, meta := range metas {
+			tb, err := openTable(tableName(opts.Dir, meta.Num), meta, &t.opts)
+			if err != nil {
+				closeTables(levels)
+				return nil, err
+			}
+			levels[level] = append(levels[level], tb)
+		}
+	}
+	t.current = newVersion(levels)
+	if err = t.recover(); err != nil {
+		t.current.unref()
+		return nil, err
+	}
+	t.count = t.scanLen()
+	go t.background()
+	return t, nil
+}
+
+func closeTables[K, V any](levels [numLevels][]*table[K, V]) {
+	for _, tables := range levels {
+		for _, tb := range tables {
+			_ = tb.file.Close()
+		}
+	}
+}
+
+// recover 回放上次未写入table的日志，写入L0后删除日志，并清理无用的文件
+func (t *Tree[K, V]) recover() error {
+	logs, err := t.listFiles(".log")
+	if err != nil {
+		return err
+	}
+	replayed, _ := t.newMemtable(0)
+	for _, num := range logs {
+		if num >= t.manifest.NextFile {
+			t.manifest.NextFile = num + 1
+		}
+		if num < t.manifest.LogNum {
+			continue
+		}
+		log, err := wal.Open(logName(t.opts.Dir, num), wal.Options{Sync: wal.SyncNever}, func(r wal.Record) error {
+			return t.replay(replayed, r)
+		})
+		if err != nil {
+			return err
+		}
+		_ = log.Close()
+	}
+	if replayed.list.Len() > 0 {
+		meta, err := t.writeTable(replayed)
+		if err != nil {
+			return err
+		}
+		if err = t.installLevel0(meta); err != nil {
+			return err
+		}
+	}
+	t.mem, err = t.newMemtable(t.nextFile())
+	if err != nil {
+		return err
+	}
+	t.manifest.LogNum = t.mem.num
+	if err = writeManifest(t.opts.Dir, t.manifest); err != nil {
+		return err
+	}
+	t.removeObsoleteFiles()
+	return nil
+}
+
+func (t *Tree[K, V]) replay(m *memtable[K, V], r wal.Record) error {
+	key, err := t.opts.DecodeKey(r.Key)
+	if err != nil {
+		return err
+	}
+	v := memValue[V]{
+		rawKey:   r.Key,
+		rawValue: r.Value,
+		deleted:  r.Op == wal.OpDelete,
+	}
+	if !v.deleted {
+		if v.value, err = t.opts.DecodeValue(r.Value); err != nil {
+			return err
+		}
+	}
+	return m.set(key, v)
+}
+
+func (t *Tree[K, V]) nextFile() uint64 {
+	num := t.manifest.NextFile
+	t.manifest.NextFile++
+	return num
+}
+
+func (t *Tree[K, V]) listFiles(ext string) ([]uint64, error) {
+	infos, err := ioutil.ReadDir(t.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint64
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, ext) {
+			continue
+		}
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+// removeObsoleteFiles 删除不再被manifest引用的table和日志
+func (t *Tree[K, V]) removeObsoleteFiles() {
+	live := make(map[uint64]bool)
+	for _, metas := range t.manifest.Levels {
+		for _, meta := range metas {
+			live[meta.Num] = true
+		}
+	}
+	tables, _ := t.listFiles(".sst")
+	for _, num := range tables {
+		if !live[num] {
+			_ = os.Remove(tableName(t.opts.Dir, num))
+		}
+	}
+	logs, _ := t.listFiles(".log")
+	for _, num := range logs {
+		if num < t.manifest.LogNum {
+			_ = os.Remove(logName(t.opts.Dir, num))
+		}
+	}
+}
+
+// Set 写入memtable，写满后冻结并交给后台写入L0
+func (t *Tree[K, V]) Set(key K, value V) error {
+	if any(key) == nil {
+		return errors.ErrNilKey
+	}
+	rawKey, err := t.opts.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	rawValue, err := t.opts.EncodeValue(value)
+	if err != nil {
+		return err
+	}
+	return t.write(key, memValue[V]{
+		value:    value,
+		rawKey:   rawKey,
+		rawValue: rawValue,
+	})
+}
+
+// Del 写入一个删除标记，真正的删除发生在压缩时
+func (t *Tree[K, V]) Del(key K) error {
+	if _, err := t.Get(key); err != nil {
+		return err
+	}
+	rawKey, err := t.opts.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	return t.write(key, memValue[V]{
+		rawKey:  rawKey,
+		deleted: true,
+	})
+}
+
+func (t *Tree[K, V]) write(key K, v memValue[V]) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.makeRoom(); err != nil {
+		return err
+	}
+	exists, err := t.exists(key)
+	if err != nil {
+		return err
+	}
+	if err = t.mem.log.Append(v.record()); err != nil {
+		return err
+	}
+	if err = t.mem.set(key, v); err != nil {
+		return err
+	}
+	t.count += delta(exists, v.deleted)
+	return nil
+}
+
+// exists key当前是否存在，调用方需持有锁
+func (t *Tree[K, V]) exists(key K) (bool, error) {
+	_, _, err := t.lookup(t.mem, t.imm, t.current, key)
+	if err == errors.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// delta 写入后数据条数的变化
+func delta(exists, deleted bool) int {
+	switch {
+	case exists && deleted:
+		return -1
+	case !exists && !deleted:
+		return 1
+	}
+	return 0
+}
+
+// Mutation 批量写入中的一条数据，Deleted为true时删除Key
+type Mutation[K, V any] struct {
+	Key     K
+	Value   V
+	Deleted bool
+}
+
+// Apply 原子地写入一组数据，在日志中是一条记录，恢复后要么全部可见要么全部不可见，
+// 删除不存在的key会被忽略
+func (t *Tree[K, V]) Apply(batch []Mutation[K, V]) error {
+	var (
+		values = make([]memValue[V], len(batch))
+		recs   = make([]wal.Record, len(batch))
+		err    error
+	)
+	for i, m := range batch {
+		if any(m.Key) == nil {
+			return errors.ErrNilKey
+		}
+		v := memValue[V]{value: m.Value, deleted: m.Deleted}
+		if v.rawKey, err = t.opts.EncodeKey(m.Key); err != nil {
+			return err
+		}
+		if !m.Deleted {
+			if v.rawValue, err = t.opts.EncodeValue(m.Value); err != nil {
+				return err
+			}
+		}
+		values[i] = v
+		recs[i] = v.record()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err = t.makeRoom(); err != nil {
+		return err
+	}
+	// 写入前算出条数的变化，同一个key在批量中出现多次时以前一次写入后的状态为准
+	var (
+		diff    int
+		written = skiplist.NewSkipList[K, bool](t.opts.Cmp)
+	)
+	for _, m := range batch {
+		exists, err := written.Get(m.Key)
+		if err == errors.ErrNotFound {
+			if exists, err = t.exists(m.Key); err != nil {
+				return err
+			}
+		}
+		diff += delta(exists, m.Deleted)
+		_ = written.Set(m.Key, !m.Deleted)
+	}
+	if err = t.mem.log.AppendBatch(recs); err != nil {
+		return err
+	}
+	for i, m := range batch {
+		if err = t.mem.set(m.Key, values[i]); err != nil {
+			return err
+		}
+	}
+	t.count += diff
+	return nil
+}
+
+// makeRoom 确保mem有空间写入，调用方需持有写锁
+func (t *Tree[K, V]) makeRoom() error {
+	for {
+		switch {
+		case t.closed:
+			return errors.ErrLogClosed
+		case t.bgErr != nil:
+			return t.bgErr
+		case t.mem.size < t.opts.MemtableSize:
+			return nil
+		case t.imm != nil:
+			// 上一个memtable还没写入L0
+			t.cond.Wait()
+		default:
+			mem, err := t.newMemtable(t.nextFile())
+			if err != nil {
+				return err
+			}
+			t.imm = t.mem
+			t.mem = mem
+			select {
+			case t.flushC <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// acquire 获取当前的memtable和version，用完需要释放version
+func (t *Tree[K, V]) acquire() (mem, imm *memtable[K, V], v *version[K, V]) {
+	t.mu.RLock()
+	mem, imm, v = t.mem, t.imm, t.current
+	v.ref()
+	t.mu.RUnlock()
+	return
+}
+
+func (t *Tree[K, V]) Get(key K) (V, error) {
+	_, value, err := t.Lookup(key)
+	return value, err
+}
+
+// Lookup 依次查找mem、imm以及从新到旧的各层table，找到第一条与key相等的数据
+func (t *Tree[K, V]) Lookup(key K) (storedKey K, value V, err error) {
+	if any(key) == nil {
+		return storedKey, value, errors.ErrNilKey
+	}
+	mem, imm, v := t.acquire()
+	defer v.unref()
+	return t.lookup(mem, imm, v, key)
+}
+
+// lookup 同Lookup，在给定的memtable和version中查找
+func (t *Tree[K, V]) lookup(mem, imm *memtable[K, V], v *version[K, V], key K) (storedKey K, value V, err error) {
+	for _, m := range []*memtable[K, V]{mem, imm} {
+		if m == nil {
+			continue
+		}
+		k, mv, err := m.list.Lookup(key)
+		if err == errors.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return storedKey, value, err
+		}
+		if mv.deleted {
+			return storedKey, value, errors.ErrNotFound
+		}
+		return k, mv.value, nil
+	}
+	raw, err := t.opts.EncodeKey(key)
+	if err != nil {
+		return storedKey, value, err
+	}
+	for level, tables := range v.levels {
+		for _, tb := range t.candidates(level, tables, key) {
+			e, ok, err := tb.get(key, raw)
+			if err != nil {
+				return storedKey, value, err
+			}
+			if !ok {
+				continue
+			}
+			if e.deleted {
+				return storedKey, value, errors.ErrNotFound
+			}
+			val, err := t.opts.DecodeValue(e.rawValue)
+			if err != nil {
+				return storedKey, value, err
+			}
+			return e.key, val, nil
+		}
+	}
+	return storedKey, value, errors.ErrNotFound
+}
+
+// candidates 某一层中可能包含key的table，L0按新到旧返回所有重叠的table
+func (t *Tree[K, V]) candidates(level int, tables []*table[K, V], key K) []*table[K, V] {
+	if level == 0 {
+		var ret []*table[K, V]
+		for _, tb := range tables {
+			if tb.overlaps(key) {
+				ret = append(ret, tb)
+			}
+		}
+		return ret
+	}
+	i := sort.Search(len(tables), func(i int) bool {
+		return !t.opts.less(tables[i].largest, key)
+	})
+	if i < len(tables) && tables[i].overlaps(key) {
+		return tables[i : i+1]
+	}
+	return nil
+}
+
+// Len 未删除的数据条数，写入时维护，不需要归并
+func (t *Tree[K, V]) Len() int {
+	t.mu.RLock()
+	n := t.count
+	t.mu.RUnlock()
+	return n
+}
+
+// scanLen 归并所有层得到准确的数量，时间复杂度为O(n)，只在打开时使用
+func (t *Tree[K, V]) scanLen() int {
+	iter := t.Iterator()
+	var n int
+	for iter.HasNext() {
+		n++
+	}
+	iter.Close()
+	return n
+}
+
+// Iterator 归并mem、imm和所有层的table，按key的顺序遍历
+func (t *Tree[K, V]) Iterator() skiplist.Iterator[K, V] {
+	// 持有锁创建memtable的迭代器，保证看到的是同一时刻的数据
+	t.mu.RLock()
+	v := t.current
+	v.ref()
+	srcs := t.sources(t.mem, t.imm, v)
+	t.mu.RUnlock()
+	return &iterator[K, V]{
+		m: newMergeIterator(&t.opts, true, srcs...),
+		v: v,
+	}
+}
+
+func (t *Tree[K, V]) sources(mem, imm *memtable[K, V], v *version[K, V]) []source[K, V] {
+	var srcs []source[K, V]
+	for _, m := range []*memtable[K, V]{mem, imm} {
+		if m != nil {
+			srcs = append(srcs, &memSource[K, V]{iter: m.list.Iterator()})
+		}
+	}
+	for _, tb := range v.levels[0] {
+		srcs = append(srcs, newTableSource(&t.opts, tb))
+	}
+	for _, tables := range v.levels[1:] {
+		if len(tables) > 0 {
+			srcs = append(srcs, newTableSource(&t.opts, tables...))
+		}
+	}
+	return srcs
+}
+
+// Close 停止后台任务并关闭日志，未写入table的数据在下次打开时从日志恢复
+func (t *Tree[K, V]) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.cond.Broadcast()
+	t.mu.Unlock()
+
+	close(t.closeC)
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	for _, m := range []*memtable[K, V]{t.mem, t.imm} {
+		if m == nil || m.log == nil {
+			continue
+		}
+		if cerr := m.log.Close(); err == nil {
+			err = cerr
+		}
+	}
+	t.current.unref()
+	return err
+}
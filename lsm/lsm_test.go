@@ -0,0 +1,302 @@
+package lsm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func newOptions(dir string) Options[string, int] {
+	return Options[string, int]{
+		Dir: dir,
+		Cmp: strings.Compare,
+		EncodeKey: func(key string) ([]byte, error) {
+			return []byte(key), nil
+		},
+		DecodeKey: func(data []byte) (string, error) {
+			return string(data), nil
+		},
+		EncodeValue: func(value int) ([]byte, error) {
+			return []byte(strconv.Itoa(value)), nil
+		},
+		DecodeValue: func(data []byte) (int, error) {
+			return strconv.Atoi(string(data))
+		},
+		MemtableSize:        4 << 10,
+		TableSize:           8 << 10,
+		BlockSize:           256,
+		L0CompactionTrigger: 2,
+		LevelSizeBase:       16 << 10,
+	}
+}
+
+func openTree(t *testing.T, dir string) *Tree[string, int] {
+	tree, err := Open(newOptions(dir))
+	if err != nil {
+		t.Fatalf("open failed, err: %+v", err)
+	}
+	return tree
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "lsm")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %+v", err)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func key(i int) string {
+	return fmt.Sprintf("%06d", i)
+}
+
+func TestTree_SetGet(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	tree := openTree(t, dir)
+	defer tree.Close()
+	const n = 3000
+	for i := 0; i < n; i++ {
+		if err := tree.Set(key(i), i); err != nil {
+			t.Errorf("set failed, err: %+v", err)
+			return
+		}
+	}
+	// 覆盖一半
+	for i := 0; i < n; i += 2 {
+		_ = tree.Set(key(i), i*10)
+	}
+	for i := 0; i < n; i++ {
+		exp := i
+		if i%2 == 0 {
+			exp = i * 10
+		}
+		v, err := tree.Get(key(i))
+		if err != nil || v != exp {
+			t.Errorf("get failed, v(%+v) of %s should be %d, err: %+v", v, key(i), exp, err)
+			return
+		}
+	}
+	if _, err := tree.Get(key(n)); err != errors.ErrNotFound {
+		t.Errorf("get failed, err should be ErrNotFound")
+		return
+	}
+	tree.mu.RLock()
+	levels := tree.current.levels
+	tree.mu.RUnlock()
+	var tables int
+	for _, tbs := range levels {
+		tables += len(tbs)
+	}
+	if tables == 0 {
+		t.Errorf("set failed, memtable should be flushed to tables")
+	}
+}
+
+func TestTree_DelAndIterator(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	tree := openTree(t, dir)
+	defer tree.Close()
+	const n = 2000
+	for i := 0; i < n; i++ {
+		_ = tree.Set(key(i), i)
+	}
+	for i := 0; i < n; i += 3 {
+		if err := tree.Del(key(i)); err != nil {
+			t.Errorf("del failed, err: %+v", err)
+			return
+		}
+	}
+	if err := tree.Del(key(0)); err != errors.ErrNotFound {
+		t.Errorf("del failed, err should be ErrNotFound")
+		return
+	}
+	iter := tree.Iterator()
+	var (
+		prev  string
+		count int
+	)
+	for iter.HasNext() {
+		k := iter.Key()
+		i, _ := strconv.Atoi(k)
+		if k <= prev || i%3 == 0 || iter.Value() != i {
+			t.Errorf("iterate failed, unexpected key %s value %+v", k, iter.Value())
+			iter.Close()
+			return
+		}
+		prev = k
+		count++
+	}
+	iter.Close()
+	if exp := n - (n+2)/3; count != exp || tree.Len() != exp {
+		t.Errorf("iterate failed, count(%d) should be %d", count, exp)
+	}
+}
+
+func TestTree_Reopen(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	tree := openTree(t, dir)
+	const n = 2000
+	for i := 0; i < n; i++ {
+		_ = tree.Set(key(i), i)
+	}
+	for i := 0; i < n; i += 2 {
+		_ = tree.Del(key(i))
+	}
+	_ = tree.Close()
+
+	tree = openTree(t, dir)
+	defer tree.Close()
+	if l := tree.Len(); l != n/2 {
+		t.Errorf("reopen failed, len(%d) should be %d", l, n/2)
+		return
+	}
+	for i := 0; i < n; i++ {
+		v, err := tree.Get(key(i))
+		if i%2 == 0 && err != errors.ErrNotFound {
+			t.Errorf("reopen failed, %s should be deleted", key(i))
+			return
+		}
+		if i%2 == 1 && v != i {
+			t.Errorf("reopen failed, v(%+v) of %s should be %d", v, key(i), i)
+			return
+		}
+	}
+}
+
+func TestTree_Apply(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	tree := openTree(t, dir)
+	_ = tree.Set(key(1), 1)
+	err := tree.Apply([]Mutation[string, int]{
+		{Key: key(1), Deleted: true},
+		{Key: key(2), Value: 2},
+		{Key: key(3), Deleted: true},
+	})
+	if err != nil {
+		t.Errorf("apply failed, err: %+v", err)
+		return
+	}
+	_ = tree.Close()
+
+	tree = openTree(t, dir)
+	defer tree.Close()
+	if _, err = tree.Get(key(1)); err != errors.ErrNotFound {
+		t.Errorf("apply failed, %s should be deleted", key(1))
+		return
+	}
+	if v, _ := tree.Get(key(2)); v != 2 || tree.Len() != 1 {
+		t.Errorf("apply failed, v(%+v) should be 2, len(%d) should be 1", v, tree.Len())
+	}
+}
+
+func TestTree_Len(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	tree := openTree(t, dir)
+	defer tree.Close()
+	const n = 1000
+	// 覆盖已写入table的数据，条数不变
+	for round := 0; round < 2; round++ {
+		for i := 0; i < n; i++ {
+			_ = tree.Set(key(i), i)
+		}
+	}
+	for i := 0; i < n; i += 4 {
+		_ = tree.Del(key(i))
+	}
+	err := tree.Apply([]Mutation[string, int]{
+		{Key: key(1), Deleted: true},
+		{Key: key(1), Value: 1},
+		{Key: key(n), Value: n},
+		{Key: key(n), Deleted: true},
+		{Key: key(n + 1), Value: n + 1},
+		{Key: key(n + 1), Value: n + 2},
+	})
+	if err != nil {
+		t.Errorf("apply failed, err: %+v", err)
+		return
+	}
+	if exp := n - n/4 + 1; tree.Len() != exp || tree.scanLen() != exp {
+		t.Errorf("len failed, len(%d) and scanned(%d) should be %d", tree.Len(), tree.scanLen(), exp)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, []byte(key(i)))
+	}
+	b := newBloom(keys, 10)
+	for _, k := range keys {
+		if !b.mayContain(k) {
+			t.Errorf("bloom failed, %s should be contained", k)
+			return
+		}
+	}
+	var fp int
+	for i := 1000; i < 11000; i++ {
+		if b.mayContain([]byte(key(i))) {
+			fp++
+		}
+	}
+	if fp > 300 {
+		t.Errorf("bloom failed, too many false positives: %d", fp)
+	}
+}
+
+func TestTree_SeekAndPrev(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	tree := openTree(t, dir)
+	defer tree.Close()
+	const n = 2000
+	// 偶数的key，部分删除，数据分布在memtable和各层table中
+	for i := 0; i < n; i += 2 {
+		_ = tree.Set(key(i), i)
+	}
+	for i := 0; i < n; i += 10 {
+		_ = tree.Del(key(i))
+	}
+	iter := tree.Iterator()
+	defer iter.Close()
+	iter.Seek(key(501))
+	if !iter.Valid() || iter.Key() != key(502) {
+		t.Errorf("seek failed, key(%s) should be %s", iter.Key(), key(502))
+		return
+	}
+	// 跳过被删除的500
+	if !iter.Prev() || iter.Key() != key(498) {
+		t.Errorf("prev failed, key(%s) should be %s", iter.Key(), key(498))
+		return
+	}
+	if !iter.HasNext() || iter.Key() != key(502) {
+		t.Errorf("next failed, key(%s) should be %s", iter.Key(), key(502))
+		return
+	}
+	var (
+		prev  = key(n)
+		count int
+	)
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		k := iter.Key()
+		i, _ := strconv.Atoi(k)
+		if k >= prev || i%10 == 0 || iter.Value() != i {
+			t.Errorf("prev failed, unexpected key %s value %+v", k, iter.Value())
+			return
+		}
+		prev = k
+		count++
+	}
+	if exp := n/2 - n/10; count != exp {
+		t.Errorf("prev failed, count(%d) should be %d", count, exp)
+	}
+}
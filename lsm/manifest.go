@@ -0,0 +1,144 @@
+package lsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+const (
+	manifestName = "MANIFEST"
+	numLevels    = 7
+)
+
+// tableMeta table的元数据，保存在manifest中
+type tableMeta struct {
+	Num      uint64
+	Size     int64
+	Smallest []byte
+	Largest  []byte
+}
+
+// manifest 记录每一层有哪些table，以及当前memtable对应的日志，
+// 每次变更都整体重写，通过rename保证原子性
+type manifest struct {
+	NextFile uint64
+	LogNum   uint64
+	Levels   [numLevels][]tableMeta
+}
+
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		m.NextFile = 1
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func writeManifest(dir string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(dir, manifestName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func tableName(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.sst", num))
+}
+
+func logName(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.log", num))
+}
+
+// version 某一时刻所有层的table，L0按新到旧排列且可能重叠，其余层按key有序且互不重叠，
+// 读操作持有version期间，其中的table文件不会被删除
+type version[K, V any] struct {
+	levels [numLevels][]*table[K, V]
+	refs   int32
+}
+
+func newVersion[K, V any](levels [numLevels][]*table[K, V]) *version[K, V] {
+	v := &version[K, V]{
+		levels: levels,
+		refs:   1,
+	}
+	for _, tables := range levels {
+		for _, t := range tables {
+			t.ref()
+		}
+	}
+	return v
+}
+
+func (v *version[K, V]) ref() {
+	atomic.AddInt32(&v.refs, 1)
+}
+
+func (v *version[K, V]) unref() {
+	if atomic.AddInt32(&v.refs, -1) != 0 {
+		return
+	}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t.unref()
+		}
+	}
+}
+
+func (v *version[K, V]) metas() [numLevels][]tableMeta {
+	var levels [numLevels][]tableMeta
+	for i, tables := range v.levels {
+		for _, t := range tables {
+			levels[i] = append(levels[i], t.meta)
+		}
+	}
+	return levels
+}
+
+func levelSize[K, V any](tables []*table[K, V]) int64 {
+	var size int64
+	for _, t := range tables {
+		size += t.meta.Size
+	}
+	return size
+}
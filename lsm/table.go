@@ -0,0 +1,364 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sync/atomic"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+const (
+	footerSize  = 40
+	tableMagic  = 0x73646273737461 // "sdbssta"
+	flagValue   = 0
+	flagDeleted = 1
+)
+
+// blockHandle 稀疏索引的一项，指向一个数据块，lastKey为块中最大的key
+type blockHandle[K any] struct {
+	lastKey K
+	offset  uint64
+	length  uint64
+}
+
+// tableWriter 顺序写入有序的数据，生成不可变的table文件，
+// 文件格式: 数据块... | 索引块 | 布隆过滤器 | footer，每个块后都带有crc32
+type tableWriter struct {
+	file      *os.File
+	w         *bufio.Writer
+	path      string
+	offset    uint64
+	blockSize int
+	block     []byte
+	lastKey   []byte
+	index     []byte
+	indexN    int
+	keys      [][]byte
+	smallest  []byte
+	buf       [binary.MaxVarintLen64]byte
+}
+
+func newTableWriter(path string, blockSize int) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		file:      file,
+		w:         bufio.NewWriter(file),
+		path:      path,
+		blockSize: blockSize,
+	}, nil
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+func appendBytes(dst, p []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(p)))
+	return append(dst, p...)
+}
+
+// add 追加一条数据，key必须是递增的
+func (w *tableWriter) add(key, value []byte, deleted bool) error {
+	if w.smallest == nil {
+		w.smallest = append([]byte{}, key...)
+	}
+	w.block = appendBytes(w.block, key)
+	if deleted {
+		w.block = append(w.block, flagDeleted)
+	} else {
+		w.block = append(w.block, flagValue)
+	}
+	w.block = appendBytes(w.block, value)
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.keys = append(w.keys, append([]byte{}, key...))
+	if len(w.block) >= w.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// size 已写入的大小，用于切分table
+func (w *tableWriter) size() uint64 {
+	return w.offset + uint64(len(w.block))
+}
+
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	offset, length, err := w.writeBlock(w.block)
+	if err != nil {
+		return err
+	}
+	w.index = appendBytes(w.index, w.lastKey)
+	w.index = appendUvarint(w.index, offset)
+	w.index = appendUvarint(w.index, length)
+	w.indexN++
+	w.block = w.block[:0]
+	return nil
+}
+
+func (w *tableWriter) writeBlock(block []byte) (offset, length uint64, err error) {
+	offset = w.offset
+	if _, err = w.w.Write(block); err != nil {
+		return
+	}
+	binary.LittleEndian.PutUint32(w.buf[:4], crc32.ChecksumIEEE(block))
+	if _, err = w.w.Write(w.buf[:4]); err != nil {
+		return
+	}
+	length = uint64(len(block))
+	w.offset += length + 4
+	return
+}
+
+// finish 写入索引、布隆过滤器和footer并刷盘，返回table的元数据
+func (w *tableWriter) finish(bitsPerKey int) (tableMeta, error) {
+	meta := tableMeta{
+		Smallest: w.smallest,
+		Largest:  append([]byte{}, w.lastKey...),
+	}
+	if err := w.flushBlock(); err != nil {
+		return meta, err
+	}
+	index := appendUvarint(nil, uint64(w.indexN))
+	index = append(index, w.index...)
+	indexOffset, indexLen, err := w.writeBlock(index)
+	if err != nil {
+		return meta, err
+	}
+	bloomOffset, bloomLen, err := w.writeBlock(newBloom(w.keys, bitsPerKey))
+	if err != nil {
+		return meta, err
+	}
+	footer := make([]byte, footerSize)
+	binary.LittleEndian.PutUint64(footer, indexOffset)
+	binary.LittleEndian.PutUint64(footer[8:], indexLen)
+	binary.LittleEndian.PutUint64(footer[16:], bloomOffset)
+	binary.LittleEndian.PutUint64(footer[24:], bloomLen)
+	binary.LittleEndian.PutUint64(footer[32:], tableMagic)
+	if _, err = w.w.Write(footer); err != nil {
+		return meta, err
+	}
+	if err = w.w.Flush(); err != nil {
+		return meta, err
+	}
+	if err = w.file.Sync(); err != nil {
+		return meta, err
+	}
+	meta.Size = int64(w.offset) + footerSize
+	return meta, w.file.Close()
+}
+
+func (w *tableWriter) abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.path)
+}
+
+// table 打开的table文件，索引和布隆过滤器常驻内存，数据块按需读取
+type table[K, V any] struct {
+	meta     tableMeta
+	path     string
+	file     *os.File
+	index    []blockHandle[K]
+	bloom    bloom
+	smallest K
+	largest  K
+	opts     *Options[K, V]
+	// 被多少个version引用，归零且已被压缩掉时删除文件
+	refs     int32
+	obsolete int32
+}
+
+func openTable[K, V any](path string, meta tableMeta, opts *Options[K, V]) (*table[K, V], error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &table[K, V]{
+		meta: meta,
+		path: path,
+		file: file,
+		opts: opts,
+	}
+	if err = t.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *table[K, V]) load() error {
+	if t.meta.Size < footerSize {
+		return errors.ErrCorruptTable
+	}
+	footer := make([]byte, footerSize)
+	if _, err := t.file.ReadAt(footer, t.meta.Size-footerSize); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(footer[32:]) != tableMagic {
+		return errors.ErrCorruptTable
+	}
+	index, err := t.readBlock(binary.LittleEndian.Uint64(footer), binary.LittleEndian.Uint64(footer[8:]))
+	if err != nil {
+		return err
+	}
+	t.bloom, err = t.readBlock(binary.LittleEndian.Uint64(footer[16:]), binary.LittleEndian.Uint64(footer[24:]))
+	if err != nil {
+		return err
+	}
+	n, index, err := readUvarint(index)
+	if err != nil {
+		return err
+	}
+	t.index = make([]blockHandle[K], 0, n)
+	for i := uint64(0); i < n; i++ {
+		var (
+			raw []byte
+			h   blockHandle[K]
+		)
+		if raw, index, err = readBytes(index); err != nil {
+			return err
+		}
+		if h.lastKey, err = t.opts.DecodeKey(raw); err != nil {
+			return err
+		}
+		if h.offset, index, err = readUvarint(index); err != nil {
+			return err
+		}
+		if h.length, index, err = readUvarint(index); err != nil {
+			return err
+		}
+		t.index = append(t.index, h)
+	}
+	if t.smallest, err = t.opts.DecodeKey(t.meta.Smallest); err != nil {
+		return err
+	}
+	t.largest, err = t.opts.DecodeKey(t.meta.Largest)
+	return err
+}
+
+func (t *table[K, V]) readBlock(offset, length uint64) ([]byte, error) {
+	buf := make([]byte, length+4)
+	if _, err := t.file.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(buf[:length]) != binary.LittleEndian.Uint32(buf[length:]) {
+		return nil, errors.ErrCorruptTable
+	}
+	return buf[:length], nil
+}
+
+// tableEntry 数据块中的一条数据
+type tableEntry[K any] struct {
+	key      K
+	rawKey   []byte
+	rawValue []byte
+	deleted  bool
+}
+
+func (t *table[K, V]) readEntries(h blockHandle[K]) ([]tableEntry[K], error) {
+	block, err := t.readBlock(h.offset, h.length)
+	if err != nil {
+		return nil, err
+	}
+	var entries []tableEntry[K]
+	for len(block) > 0 {
+		var e tableEntry[K]
+		if e.rawKey, block, err = readBytes(block); err != nil {
+			return nil, err
+		}
+		if len(block) == 0 {
+			return nil, errors.ErrCorruptTable
+		}
+		e.deleted = block[0] == flagDeleted
+		if e.rawValue, block, err = readBytes(block[1:]); err != nil {
+			return nil, err
+		}
+		if e.key, err = t.opts.DecodeKey(e.rawKey); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// overlaps key是否落在table的范围内
+func (t *table[K, V]) overlaps(key K) bool {
+	return !t.opts.less(key, t.smallest) && !t.opts.less(t.largest, key)
+}
+
+// get 查找与key相等的数据，raw为key编码后的结果，用于布隆过滤器
+func (t *table[K, V]) get(key K, raw []byte) (tableEntry[K], bool, error) {
+	if !t.overlaps(key) || !t.bloom.mayContain(raw) {
+		return tableEntry[K]{}, false, nil
+	}
+	// 二分查找第一个lastKey不小于key的块
+	lo, hi := 0, len(t.index)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if t.opts.less(t.index[mid].lastKey, key) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == len(t.index) {
+		return tableEntry[K]{}, false, nil
+	}
+	entries, err := t.readEntries(t.index[lo])
+	if err != nil {
+		return tableEntry[K]{}, false, err
+	}
+	for _, e := range entries {
+		if t.opts.less(e.key, key) {
+			continue
+		}
+		if t.opts.less(key, e.key) {
+			break
+		}
+		return e, true, nil
+	}
+	return tableEntry[K]{}, false, nil
+}
+
+func (t *table[K, V]) ref() {
+	atomic.AddInt32(&t.refs, 1)
+}
+
+func (t *table[K, V]) unref() {
+	if atomic.AddInt32(&t.refs, -1) != 0 {
+		return
+	}
+	_ = t.file.Close()
+	if atomic.LoadInt32(&t.obsolete) == 1 {
+		_ = os.Remove(t.path)
+	}
+}
+
+func readUvarint(p []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(p)
+	if n <= 0 {
+		return 0, nil, errors.ErrCorruptTable
+	}
+	return v, p[n:], nil
+}
+
+func readBytes(p []byte) ([]byte, []byte, error) {
+	n, p, err := readUvarint(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(len(p)) < n {
+		return nil, nil, errors.ErrCorruptTable
+	}
+	return p[:n], p[n:], nil
+}
@@ -0,0 +1,152 @@
+package simpledb
+
+import (
+	"reflect"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// modify 在同一次写锁内读取旧值并写入fn返回的新值，fn返回false时不写入，
+// 已过期的数据视为不存在，未指定过期时间时沿用旧的过期时间
+func (d *TypedDB[K, V]) modify(key K, opts []SaveOption, fn func(old V, exists bool) (V, bool)) (value V, ok bool, err error) {
+	if any(key) == nil {
+		return value, false, errors.ErrNilKey
+	}
+	if !d.life.enter() {
+		return value, false, errors.ErrClosed
+	}
+	defer d.life.leave()
+	d.mu.Lock()
+	_, old, err := d.data.Lookup(key)
+	if err != nil && err != errors.ErrNotFound {
+		d.unlock()
+		return value, false, err
+	}
+	exists := err == nil && !d.expired(old, d.now())
+	if !exists {
+		old = item[V]{}
+	}
+	if value, ok = fn(old.value, exists); !ok {
+		d.unlock()
+		return old.value, false, nil
+	}
+	it := d.newItem(value, opts, old)
+	if err = d.prepare(key, value); err != nil {
+		d.unlock()
+		return value, false, err
+	}
+	if d.log != nil {
+		rec, err := d.saveRecord(key, value, it.expireAt)
+		if err != nil {
+			d.unlock()
+			return value, false, err
+		}
+		if err = d.log.Append(rec); err != nil {
+			d.unlock()
+			return value, false, err
+		}
+	}
+	err = d.set(key, it)
+	d.unlock()
+	if err != nil {
+		return value, false, err
+	}
+	d.maybeCompact()
+	return value, true, nil
+}
+
+// Modify 原子地读取并修改key的值，fn的exists表示key是否存在，返回false时不修改，
+// 返回修改后的值，未修改时返回当前的值
+func (d *TypedDB[K, V]) Modify(key K, fn func(old V, exists bool) (V, bool), opts ...SaveOption) (V, error) {
+	value, _, err := d.modify(key, opts, fn)
+	return value, err
+}
+
+// SaveIfAbsent key不存在时才保存，返回是否保存成功
+func (d *TypedDB[K, V]) SaveIfAbsent(key K, value V, opts ...SaveOption) (bool, error) {
+	_, ok, err := d.modify(key, opts, func(_ V, exists bool) (V, bool) {
+		return value, !exists
+	})
+	return ok, err
+}
+
+// SaveIfPresent key存在时才保存，返回是否保存成功
+func (d *TypedDB[K, V]) SaveIfPresent(key K, value V, opts ...SaveOption) (bool, error) {
+	_, ok, err := d.modify(key, opts, func(_ V, exists bool) (V, bool) {
+		return value, exists
+	})
+	return ok, err
+}
+
+// CompareAndSwap key存在且当前值等于old时保存new，返回是否保存成功，
+// 基础类型和指针使用==比较，结构体、数组等其他类型使用reflect.DeepEqual
+func (d *TypedDB[K, V]) CompareAndSwap(key K, old, new V, opts ...SaveOption) (bool, error) {
+	_, ok, err := d.modify(key, opts, func(cur V, exists bool) (V, bool) {
+		return new, exists && equal(cur, old)
+	})
+	return ok, err
+}
+
+func equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	// 结构体和数组中的interface字段保存了slice、map等时==会panic
+	if basic(reflect.TypeOf(a)) && basic(reflect.TypeOf(b)) {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func basic(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Array, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	}
+	return true
+}
+
+// Modify 原子地读取并修改key的值，fn的exists表示key是否存在，返回false时不修改，
+// 返回修改后的值，未修改时返回当前的值
+func (d *DB) Modify(key interface{}, fn func(old interface{}, exists bool) (interface{}, bool), opts ...SaveOption) (interface{}, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if err := d.isValidKey(key); err != nil {
+		return nil, err
+	}
+	return d.typed.Modify(key, fn, opts...)
+}
+
+// SaveIfAbsent key不存在时才保存，返回是否保存成功
+func (d *DB) SaveIfAbsent(key, value interface{}, opts ...SaveOption) (bool, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if err := d.isValidKey(key); err != nil {
+		return false, err
+	}
+	return d.typed.SaveIfAbsent(key, value, opts...)
+}
+
+// SaveIfPresent key存在时才保存，返回是否保存成功
+func (d *DB) SaveIfPresent(key, value interface{}, opts ...SaveOption) (bool, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if err := d.isValidKey(key); err != nil {
+		return false, err
+	}
+	return d.typed.SaveIfPresent(key, value, opts...)
+}
+
+// CompareAndSwap key存在且当前值等于old时保存new，返回是否保存成功
+func (d *DB) CompareAndSwap(key, old, new interface{}, opts ...SaveOption) (bool, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if err := d.isValidKey(key); err != nil {
+		return false, err
+	}
+	return d.typed.CompareAndSwap(key, old, new, opts...)
+}
@@ -0,0 +1,113 @@
+package simpledb
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	db := NewDB()
+	if ok, _ := db.CompareAndSwap("1", nil, 1); ok {
+		t.Errorf("cas failed, absent key should not be swapped")
+		return
+	}
+	_ = db.Save("1", 1)
+	if ok, _ := db.CompareAndSwap("1", 2, 3); ok {
+		t.Errorf("cas failed, 1 should not be swapped")
+		return
+	}
+	if ok, _ := db.CompareAndSwap("1", 1, 3); !ok {
+		t.Errorf("cas failed, 1 should be swapped")
+		return
+	}
+	if v, _ := db.Get("1"); v != 3 {
+		t.Errorf("cas failed, v(%+v) should be 3", v)
+		return
+	}
+	// 不可比较的值
+	_ = db.Save("2", []int{1})
+	if ok, _ := db.CompareAndSwap("2", []int{1}, []int{2}); !ok {
+		t.Errorf("cas failed, 2 should be swapped")
+		return
+	}
+	// 类型可比较，但interface字段中保存的是slice，不能使用==
+	type box struct {
+		V interface{}
+	}
+	_ = db.Save("3", box{V: []int{1}})
+	if ok, _ := db.CompareAndSwap("3", box{V: []int{2}}, box{V: 3}); ok {
+		t.Errorf("cas failed, 3 should not be swapped")
+		return
+	}
+	if ok, _ := db.CompareAndSwap("3", box{V: []int{1}}, box{V: 3}); !ok {
+		t.Errorf("cas failed, 3 should be swapped")
+	}
+}
+
+func TestDB_SaveIfAbsentAndPresent(t *testing.T) {
+	db := NewDB()
+	if ok, _ := db.SaveIfPresent("1", 1); ok {
+		t.Errorf("save if present failed, 1 should not be saved")
+		return
+	}
+	if ok, _ := db.SaveIfAbsent("1", 1); !ok {
+		t.Errorf("save if absent failed, 1 should be saved")
+		return
+	}
+	if ok, _ := db.SaveIfAbsent("1", 2); ok {
+		t.Errorf("save if absent failed, 1 should not be saved again")
+		return
+	}
+	if ok, _ := db.SaveIfPresent("1", 3); !ok {
+		t.Errorf("save if present failed, 1 should be saved")
+		return
+	}
+	if v, _ := db.Get("1"); v != 3 {
+		t.Errorf("save if present failed, v(%+v) should be 3", v)
+	}
+}
+
+func TestDB_Modify(t *testing.T) {
+	db := NewDB()
+	incr := func(old interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return 1, true
+		}
+		return old.(int) + 1, true
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = db.Modify("counter", incr)
+		}()
+	}
+	wg.Wait()
+	if v, _ := db.Get("counter"); v != 50 {
+		t.Errorf("modify failed, counter(%+v) should be 50", v)
+		return
+	}
+	v, _ := db.Modify("counter", func(old interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+	if v != 50 {
+		t.Errorf("modify failed, v(%+v) should be 50", v)
+	}
+}
+
+func TestDB_ModifyKeepTTL(t *testing.T) {
+	db := NewDB(DBOptionWithExpired())
+	_ = db.Save("1", 1, SaveOptionTTL(100))
+	before, _ := db.typed.data.Get("1")
+	_, _ = db.CompareAndSwap("1", 1, 2)
+	after, _ := db.typed.data.Get("1")
+	if after.value != 2 || after.expireAt != before.expireAt {
+		t.Errorf("modify failed, item(%+v) should keep expireAt %d", after, before.expireAt)
+		return
+	}
+	_, _ = db.SaveIfPresent("1", 3, SaveOptionTTL(1000))
+	if after, _ = db.typed.data.Get("1"); after.expireAt <= before.expireAt {
+		t.Errorf("modify failed, expireAt(%d) should be renewed", after.expireAt)
+	}
+}
@@ -0,0 +1,255 @@
+package simpledb
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/lsm"
+	"github.com/byronzhu-haha/simpledb/wal"
+)
+
+const (
+	walFileName           = "simpledb.wal"
+	defaultCompactRatio   = 2
+	defaultCompactMinSize = 16 << 20
+)
+
+// openWAL 打开预写日志并回放到跳表中
+func (d *TypedDB[K, V]) openWAL() error {
+	if err := os.MkdirAll(d.conf.walDir, 0755); err != nil {
+		return err
+	}
+	now := d.now()
+	log, err := wal.Open(filepath.Join(d.conf.walDir, walFileName), d.conf.walOptions, func(r wal.Record) error {
+		return d.replay(r, now)
+	})
+	if err != nil {
+		return err
+	}
+	d.log = log
+	d.compactBase = log.Size()
+	if err = d.shrink(); err != nil {
+		_ = log.Close()
+		return err
+	}
+	return nil
+}
+
+// replay 回放一条日志记录，已经过期的数据直接丢弃
+func (d *TypedDB[K, V]) replay(r wal.Record, now int64) error {
+	key, err := d.decodeKey(r.Key)
+	if err != nil {
+		return err
+	}
+	alive := !d.withExpired() || r.ExpireAt == 0 || r.ExpireAt > now
+	if r.Op == wal.OpSave && alive {
+		value, err := d.decodeValue(r.Value)
+		if err != nil {
+			return err
+		}
+		return d.set(key, item[V]{value: value, expireAt: r.ExpireAt})
+	}
+	stored, it, err := d.data.Lookup(key)
+	if err != nil {
+		return nil
+	}
+	if r.Op == wal.OpExpire && alive {
+		it.expireAt = r.ExpireAt
+		return d.set(stored, it)
+	}
+	// 删除或者已过期
+	_ = d.delete(stored, EvictDeleted)
+	return nil
+}
+
+func (d *TypedDB[K, V]) saveRecord(key K, value V, expireAt int64) (wal.Record, error) {
+	rec := wal.Record{
+		Op:       wal.OpSave,
+		ExpireAt: expireAt,
+	}
+	var err error
+	rec.Key, err = d.encodeKey(key)
+	if err != nil {
+		return rec, err
+	}
+	rec.Value, err = d.encodeValue(value)
+	return rec, err
+}
+
+func (d *TypedDB[K, V]) expireRecord(key K, expireAt int64) (wal.Record, error) {
+	raw, err := d.encodeKey(key)
+	return wal.Record{
+		Op:       wal.OpExpire,
+		ExpireAt: expireAt,
+		Key:      raw,
+	}, err
+}
+
+func (d *TypedDB[K, V]) deleteRecord(key K) (wal.Record, error) {
+	raw, err := d.encodeKey(key)
+	return wal.Record{
+		Op:  wal.OpDelete,
+		Key: raw,
+	}, err
+}
+
+func (d *TypedDB[K, V]) encodeKey(key K) ([]byte, error) {
+	if d.stringKey {
+		return []byte(any(key).(string)), nil
+	}
+	return d.conf.codec.Marshal(key)
+}
+
+func (d *TypedDB[K, V]) decodeKey(data []byte) (key K, err error) {
+	var v interface{}
+	if d.stringKey {
+		v = string(data)
+	} else if v, err = d.conf.codec.Unmarshal(data); err != nil {
+		return key, err
+	}
+	key, ok := v.(K)
+	if !ok {
+		return key, errors.ErrInvalidKey
+	}
+	return key, nil
+}
+
+func (d *TypedDB[K, V]) encodeValue(value V) ([]byte, error) {
+	return d.conf.codec.Marshal(value)
+}
+
+func (d *TypedDB[K, V]) decodeValue(data []byte) (value V, err error) {
+	v, err := d.conf.codec.Unmarshal(data)
+	if err != nil || v == nil {
+		return value, err
+	}
+	value, ok := v.(V)
+	if !ok {
+		return value, errors.ErrInvalidValue
+	}
+	return value, nil
+}
+
+// Compact 用跳表中当前的数据重写日志，丢弃已删除和已过期的数据，
+// 只在开始时短暂阻塞写操作，重写期间的写操作会在替换前补到新日志中
+func (d *TypedDB[K, V]) Compact() error {
+	if !d.life.enter() {
+		return errors.ErrClosed
+	}
+	defer d.life.leave()
+	if d.log == nil {
+		return nil
+	}
+	return d.compact()
+}
+
+func (d *TypedDB[K, V]) compact() error {
+	d.mu.RLock()
+	rw, err := d.log.StartRewrite()
+	if err != nil {
+		d.mu.RUnlock()
+		return err
+	}
+	entries := d.collect(d.now())
+	d.mu.RUnlock()
+
+	for _, e := range entries {
+		rec, err := d.saveRecord(e.key, e.value, e.expireAt)
+		if err == nil {
+			err = rw.Append(rec)
+		}
+		if err != nil {
+			rw.Abort()
+			return err
+		}
+	}
+	if err = rw.Commit(); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&d.compactBase, d.log.Size())
+	return nil
+}
+
+// maybeCompact 日志增长到一定比例时在后台压缩
+func (d *TypedDB[K, V]) maybeCompact() {
+	if d.log == nil || d.conf.compactRatio <= 0 {
+		return
+	}
+	size := d.log.Size()
+	if size < d.conf.compactMinSize || float64(size) < float64(atomic.LoadInt64(&d.compactBase))*d.conf.compactRatio {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&d.compacting, 0, 1) {
+		return
+	}
+	// 压缩也算作正在执行的操作，关闭时会等待其结束
+	if !d.life.enter() {
+		atomic.StoreInt32(&d.compacting, 0)
+		return
+	}
+	go func() {
+		_ = d.compact()
+		atomic.StoreInt32(&d.compacting, 0)
+		d.life.leave()
+	}()
+}
+
+// openLSM 打开LSM树，并根据其中的数据重建别名和过期索引
+func (d *TypedDB[K, V]) openLSM() error {
+	tree, err := lsm.Open(lsm.Options[K, item[V]]{
+		Dir:          d.conf.lsmDir,
+		Cmp:          d.cmp,
+		EncodeKey:    d.encodeKey,
+		DecodeKey:    d.decodeKey,
+		EncodeValue:  d.encodeItem,
+		DecodeValue:  d.decodeItem,
+		MemtableSize: d.conf.memtableSize,
+		WAL:          d.conf.walOptions,
+	})
+	if err != nil {
+		return err
+	}
+	d.data = tree
+	if d.alias == nil && !d.withExpired() {
+		return nil
+	}
+	iter := tree.Iterator()
+	for iter.HasNext() {
+		if d.alias != nil {
+			d.aliases[d.alias(iter.Key())] = iter.Key()
+		}
+		d.track(iter.Key(), iter.Value())
+	}
+	iter.Close()
+	return nil
+}
+
+// encodeItem 编码LSM树中保存的值，过期时间和写入戳放在最前面
+func (d *TypedDB[K, V]) encodeItem(it item[V]) ([]byte, error) {
+	data, err := d.encodeValue(it.value)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64+len(data))
+	buf = binary.AppendVarint(buf, it.expireAt)
+	buf = binary.AppendUvarint(buf, it.stamp)
+	return append(buf, data...), nil
+}
+
+func (d *TypedDB[K, V]) decodeItem(data []byte) (it item[V], err error) {
+	expireAt, n := binary.Varint(data)
+	if n <= 0 {
+		return it, errors.ErrCorruptRecord
+	}
+	stamp, m := binary.Uvarint(data[n:])
+	if m <= 0 {
+		return it, errors.ErrCorruptRecord
+	}
+	it.expireAt = expireAt
+	it.stamp = stamp
+	it.value, err = d.decodeValue(data[n+m:])
+	return it, err
+}
@@ -0,0 +1,242 @@
+package simpledb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "simpledb")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %+v", err)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestDBWithWAL_Reopen(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db, err := OpenDB(DBOptionWithWAL(dir), DBOptionWALSyncAlways())
+	if err != nil {
+		t.Errorf("open failed, err: %+v", err)
+		return
+	}
+	for i, k := range []string{"1", "2", "3"} {
+		_ = db.Save(k, i)
+	}
+	_ = db.Save("2", 20)
+	_ = db.Delete("3")
+	_ = db.Close()
+
+	db, err = OpenDB(DBOptionWithWAL(dir))
+	if err != nil {
+		t.Errorf("reopen failed, err: %+v", err)
+		return
+	}
+	defer db.Close()
+	if n, _ := db.Count(); n != 2 {
+		t.Errorf("reopen failed, count(%d) should be 2", n)
+		return
+	}
+	if v, _ := db.Get("2"); v != 20 {
+		t.Errorf("reopen failed, v(%+v) should be 20", v)
+		return
+	}
+	if _, err = db.Get("3"); err != errors.ErrNotFound {
+		t.Errorf("reopen failed, err should be ErrNotFound")
+	}
+}
+
+func TestDBWithWAL_ReopenExpired(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB(DBOptionWithExpired(), DBOptionWithWAL(dir))
+	_ = db.Save("1", 1)
+	_ = db.Save("2", 2, SaveOptionTTL(100))
+	_ = db.Save("3", 3, SaveOptionTTL(100))
+	_ = db.Delete("3")
+	_ = db.Close()
+
+	db = NewDB(DBOptionWithExpired(), DBOptionWithWAL(dir))
+	defer db.Close()
+	if n, _ := db.Count(); n != 2 {
+		t.Errorf("reopen failed, count(%d) should be 2", n)
+		return
+	}
+	if v, err := db.Get("2"); err != nil || v != 2 {
+		t.Errorf("reopen failed, v(%+v) should be 2, err: %+v", v, err)
+		return
+	}
+	if it, _ := db.typed.data.Get("2"); it.expireAt == 0 {
+		t.Errorf("reopen failed, ttl of key should be kept")
+	}
+}
+
+// jsonKeyCodec 测试用的编解码器，key为customKey，value为value
+type jsonKeyCodec struct{}
+
+type jsonKey struct {
+	Key string
+	Seq int32
+}
+
+func (jsonKeyCodec) Marshal(v interface{}) ([]byte, error) {
+	switch vv := v.(type) {
+	case customKey:
+		return json.Marshal(jsonKey{Key: vv.key, Seq: vv.seq})
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func (jsonKeyCodec) Unmarshal(data []byte) (interface{}, error) {
+	var k jsonKey
+	if err := json.Unmarshal(data, &k); err == nil && k.Key != "" {
+		return customKey{key: k.Key, seq: k.Seq}, nil
+	}
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+func TestCustomDBWithWAL_Reopen(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	less := func(l, r interface{}) bool {
+		return l.(customKey).seq < r.(customKey).seq
+	}
+	db := NewCustomDB(less, DBOptionWithWAL(dir), DBOptionWithCodec(jsonKeyCodec{}))
+	_ = db.Save(newCustomKey("1", 1), "a")
+	_ = db.Save(newCustomKey("2", 2), "b")
+	_ = db.Delete("1")
+	_ = db.Close()
+
+	db = NewCustomDB(less, DBOptionWithWAL(dir), DBOptionWithCodec(jsonKeyCodec{}))
+	defer db.Close()
+	if n, _ := db.Count(); n != 1 {
+		t.Errorf("reopen failed, count(%d) should be 1", n)
+		return
+	}
+	if v, err := db.Get("2"); err != nil || v != "b" {
+		t.Errorf("reopen failed, v(%+v) should be \"b\", err: %+v", v, err)
+	}
+}
+
+func TestDBWithWAL_Compact(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB(DBOptionWithExpired(), DBOptionWithWAL(dir), DBOptionCompactRatio(0))
+	for i := 0; i < 100; i++ {
+		_ = db.Save("1", i)
+		_ = db.Save("2", i)
+	}
+	_ = db.Delete("2")
+	_ = db.Save("3", 3, SaveOptionTTL(100))
+	before := db.typed.log.Size()
+	if err := db.Compact(); err != nil {
+		t.Errorf("compact failed, err: %+v", err)
+		return
+	}
+	if after := db.typed.log.Size(); after >= before/10 {
+		t.Errorf("compact failed, size of log(%d) should be much less than %d", after, before)
+		return
+	}
+	// 压缩后的写入追加到新日志
+	_ = db.Save("4", 4)
+	_ = db.Close()
+
+	db = NewDB(DBOptionWithExpired(), DBOptionWithWAL(dir))
+	defer db.Close()
+	if n, _ := db.Count(); n != 3 {
+		t.Errorf("reopen failed, count(%d) should be 3", n)
+		return
+	}
+	if v, _ := db.Get("1"); v != 99 {
+		t.Errorf("reopen failed, v(%+v) should be 99", v)
+		return
+	}
+	if it, _ := db.typed.data.Get("3"); it.expireAt == 0 {
+		t.Errorf("reopen failed, ttl of key should be kept")
+	}
+}
+
+func TestDBWithWAL_CompactWhileSaving(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB(DBOptionWithWAL(dir), DBOptionCompactRatio(1.5), DBOptionCompactMinSize(1024))
+	var (
+		wg  sync.WaitGroup
+		exp = make([]int, 4)
+	)
+	for g := range exp {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				_ = db.Save(strconv.Itoa(g), i)
+			}
+			exp[g] = 1999
+		}(g)
+	}
+	wg.Wait()
+	if err := db.Compact(); err != nil && err != errors.ErrRewriting {
+		t.Errorf("compact failed, err: %+v", err)
+		return
+	}
+	_ = db.Close()
+
+	db = NewDB(DBOptionWithWAL(dir))
+	defer db.Close()
+	for g, v := range exp {
+		if got, _ := db.Get(strconv.Itoa(g)); got != v {
+			t.Errorf("reopen failed, v(%+v) of key %d should be %d", got, g, v)
+			return
+		}
+	}
+}
+
+func TestDBWithLSM_Reopen(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db, err := OpenDB(DBOptionWithExpired(), DBOptionWithLSM(dir), DBOptionMemtableSize(4<<10))
+	if err != nil {
+		t.Errorf("open failed, err: %+v", err)
+		return
+	}
+	for i := 0; i < 1000; i++ {
+		_ = db.Save(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 1000; i += 2 {
+		_ = db.Save(strconv.Itoa(i), i*10, SaveOptionTTL(100))
+	}
+	_ = db.Delete("1")
+	_ = db.Close()
+
+	db = NewDB(DBOptionWithExpired(), DBOptionWithLSM(dir), DBOptionMemtableSize(4<<10))
+	defer db.Close()
+	if n, _ := db.Count(); n != 999 {
+		t.Errorf("reopen failed, count(%d) should be 999", n)
+		return
+	}
+	if v, _ := db.Get("10"); v != 100 {
+		t.Errorf("reopen failed, v(%+v) should be 100", v)
+		return
+	}
+	if v, _ := db.Get("11"); v != 11 {
+		t.Errorf("reopen failed, v(%+v) should be 11", v)
+		return
+	}
+	if _, err = db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("reopen failed, err should be ErrNotFound")
+		return
+	}
+	if it, _ := db.typed.data.Get("10"); it.expireAt == 0 {
+		t.Errorf("reopen failed, ttl of key should be kept")
+	}
+}
@@ -0,0 +1,369 @@
+package simpledb
+
+import (
+	"container/heap"
+	"container/list"
+	"math/rand"
+)
+
+// EvictionPolicy 超出容量时选择淘汰数据的策略
+type EvictionPolicy byte
+
+const (
+	// PolicyLRU 淘汰最久未访问的数据
+	PolicyLRU EvictionPolicy = iota + 1
+	// PolicyLFU 淘汰访问次数最少的数据，次数相同时淘汰最久未访问的
+	PolicyLFU
+	// PolicyTinyLFU W-TinyLFU，新数据先进入窗口，淘汰时与主区的数据比较近期的访问频率
+	PolicyTinyLFU
+	// PolicySampledLRU 随机采样若干数据，淘汰其中最久未访问的，同redis的allkeys-lru
+	PolicySampledLRU
+	// PolicyVolatileTTL 随机采样若干带有过期时间的数据，淘汰其中最快过期的，同redis的volatile-ttl，
+	// 没有带过期时间的数据时写入会返回ErrOutOfCapacity
+	PolicyVolatileTTL
+)
+
+// samples 随机采样的数量
+const samples = 5
+
+// cacheEntry 容量限制下每条数据的元信息，由跳表中的item引用，覆盖时沿用
+type cacheEntry struct {
+	key      interface{}
+	hash     uint64
+	size     int64
+	expireAt int64
+	removed  bool
+	// elem、segment 用于链表实现的策略，freq、tick、index 用于堆和采样实现的策略
+	elem    *list.Element
+	segment int8
+	freq    uint64
+	tick    uint64
+	index   int
+}
+
+// policy 淘汰策略，调用方需持有cache的锁
+type policy interface {
+	add(e *cacheEntry)
+	access(e *cacheEntry)
+	remove(e *cacheEntry)
+	// victim 选出skip以外要淘汰的数据，没有可以淘汰的数据时返回nil
+	victim(skip *cacheEntry) *cacheEntry
+}
+
+func newPolicy(p EvictionPolicy, capacity int) policy {
+	switch p {
+	case PolicyLFU:
+		return &lfuPolicy{}
+	case PolicyTinyLFU:
+		return newTinyLFUPolicy(capacity)
+	case PolicySampledLRU:
+		return &sampledPolicy{}
+	case PolicyVolatileTTL:
+		return &sampledPolicy{volatile: true}
+	}
+	return &lruPolicy{l: list.New()}
+}
+
+type lruPolicy struct {
+	l *list.List
+}
+
+func (p *lruPolicy) add(e *cacheEntry) {
+	e.elem = p.l.PushFront(e)
+}
+
+func (p *lruPolicy) access(e *cacheEntry) {
+	p.l.MoveToFront(e.elem)
+}
+
+func (p *lruPolicy) remove(e *cacheEntry) {
+	p.l.Remove(e.elem)
+	e.elem = nil
+}
+
+func (p *lruPolicy) victim(skip *cacheEntry) *cacheEntry {
+	return back(p.l, skip)
+}
+
+// back 链表中skip以外最旧的数据
+func back(l *list.List, skip *cacheEntry) *cacheEntry {
+	elem := l.Back()
+	if elem != nil && elem.Value.(*cacheEntry) == skip {
+		elem = elem.Prev()
+	}
+	if elem == nil {
+		return nil
+	}
+	return elem.Value.(*cacheEntry)
+}
+
+// lfuPolicy 按访问次数和最近访问的先后排序的最小堆
+type lfuPolicy struct {
+	entries []*cacheEntry
+	tick    uint64
+}
+
+func (p *lfuPolicy) Len() int { return len(p.entries) }
+
+func (p *lfuPolicy) Less(i, j int) bool {
+	a, b := p.entries[i], p.entries[j]
+	if a.freq != b.freq {
+		return a.freq < b.freq
+	}
+	return a.tick < b.tick
+}
+
+func (p *lfuPolicy) Swap(i, j int) {
+	p.entries[i], p.entries[j] = p.entries[j], p.entries[i]
+	p.entries[i].index = i
+	p.entries[j].index = j
+}
+
+func (p *lfuPolicy) Push(x interface{}) {
+	e := x.(*cacheEntry)
+	e.index = len(p.entries)
+	p.entries = append(p.entries, e)
+}
+
+func (p *lfuPolicy) Pop() interface{} {
+	n := len(p.entries) - 1
+	e := p.entries[n]
+	p.entries[n] = nil
+	p.entries = p.entries[:n]
+	return e
+}
+
+func (p *lfuPolicy) add(e *cacheEntry) {
+	p.tick++
+	e.freq, e.tick = 1, p.tick
+	heap.Push(p, e)
+}
+
+func (p *lfuPolicy) access(e *cacheEntry) {
+	p.tick++
+	e.freq++
+	e.tick = p.tick
+	heap.Fix(p, e.index)
+}
+
+func (p *lfuPolicy) remove(e *cacheEntry) {
+	heap.Remove(p, e.index)
+}
+
+func (p *lfuPolicy) victim(skip *cacheEntry) *cacheEntry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+	if p.entries[0] != skip {
+		return p.entries[0]
+	}
+	// 堆顶被跳过时，次小的数据是堆顶的子节点之一
+	switch len(p.entries) {
+	case 1:
+		return nil
+	case 2:
+		return p.entries[1]
+	}
+	if p.Less(2, 1) {
+		return p.entries[2]
+	}
+	return p.entries[1]
+}
+
+// sampledPolicy 随机采样，volatile为true时只采样带有过期时间的数据并淘汰最快过期的
+type sampledPolicy struct {
+	entries  []*cacheEntry
+	tick     uint64
+	volatile bool
+}
+
+func (p *sampledPolicy) add(e *cacheEntry) {
+	p.tick++
+	e.tick = p.tick
+	e.index = -1
+	if p.volatile && e.expireAt == 0 {
+		return
+	}
+	e.index = len(p.entries)
+	p.entries = append(p.entries, e)
+}
+
+func (p *sampledPolicy) access(e *cacheEntry) {
+	p.tick++
+	e.tick = p.tick
+}
+
+func (p *sampledPolicy) remove(e *cacheEntry) {
+	if e.index < 0 {
+		return
+	}
+	last := len(p.entries) - 1
+	p.entries[e.index] = p.entries[last]
+	p.entries[e.index].index = e.index
+	p.entries[last] = nil
+	p.entries = p.entries[:last]
+	e.index = -1
+}
+
+func (p *sampledPolicy) victim(skip *cacheEntry) *cacheEntry {
+	var ret *cacheEntry
+	for i := 0; i < samples && len(p.entries) > 0; i++ {
+		e := p.entries[rand.Intn(len(p.entries))]
+		switch {
+		case e == skip:
+			// 只有skip一条数据时没有可以淘汰的
+			if len(p.entries) == 1 {
+				return nil
+			}
+			i--
+		case ret == nil:
+			ret = e
+		case p.volatile && e.expireAt < ret.expireAt:
+			ret = e
+		case !p.volatile && e.tick < ret.tick:
+			ret = e
+		}
+	}
+	return ret
+}
+
+// 数据在W-TinyLFU中所处的区域
+const (
+	segmentWindow int8 = iota
+	segmentProbation
+	segmentProtected
+)
+
+// tinyLFUPolicy 窗口占1%，主区分为试用区和占80%的保护区，
+// 淘汰时窗口中最旧的数据与试用区中最旧的数据比较访问频率，频率较低的被淘汰
+type tinyLFUPolicy struct {
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	sketch    *cmSketch
+}
+
+func newTinyLFUPolicy(capacity int) *tinyLFUPolicy {
+	return &tinyLFUPolicy{
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		sketch:    newCMSketch(capacity),
+	}
+}
+
+func (p *tinyLFUPolicy) segment(s int8) *list.List {
+	switch s {
+	case segmentProbation:
+		return p.probation
+	case segmentProtected:
+		return p.protected
+	}
+	return p.window
+}
+
+// move 将数据移到另一个区域的头部
+func (p *tinyLFUPolicy) move(e *cacheEntry, s int8) {
+	p.segment(e.segment).Remove(e.elem)
+	e.segment = s
+	e.elem = p.segment(s).PushFront(e)
+}
+
+func (p *tinyLFUPolicy) add(e *cacheEntry) {
+	p.sketch.increment(e.hash)
+	e.segment = segmentWindow
+	e.elem = p.window.PushFront(e)
+	total := p.window.Len() + p.probation.Len() + p.protected.Len()
+	if p.window.Len() > total/100+1 {
+		// 窗口已满，最旧的数据进入试用区
+		p.move(p.window.Back().Value.(*cacheEntry), segmentProbation)
+	}
+}
+
+func (p *tinyLFUPolicy) access(e *cacheEntry) {
+	p.sketch.increment(e.hash)
+	switch e.segment {
+	case segmentProbation:
+		p.move(e, segmentProtected)
+		main := p.probation.Len() + p.protected.Len()
+		if p.protected.Len() > main*8/10 {
+			p.move(p.protected.Back().Value.(*cacheEntry), segmentProbation)
+		}
+	default:
+		p.segment(e.segment).MoveToFront(e.elem)
+	}
+}
+
+func (p *tinyLFUPolicy) remove(e *cacheEntry) {
+	p.segment(e.segment).Remove(e.elem)
+	e.elem = nil
+}
+
+func (p *tinyLFUPolicy) victim(skip *cacheEntry) *cacheEntry {
+	candidate, victim := back(p.window, skip), back(p.probation, skip)
+	if victim == nil {
+		victim = back(p.protected, skip)
+	}
+	switch {
+	case candidate == nil:
+		return victim
+	case victim == nil:
+		return candidate
+	case p.sketch.estimate(candidate.hash) > p.sketch.estimate(victim.hash):
+		return victim
+	}
+	return candidate
+}
+
+// cmSketch 4行的Count-Min Sketch，计数达到上限后所有计数减半，使频率反映近期的访问
+type cmSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+var sketchSeeds = [4]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+func newCMSketch(capacity int) *cmSketch {
+	width := 64
+	for width < capacity {
+		width <<= 1
+	}
+	s := &cmSketch{mask: uint64(width - 1), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *cmSketch) index(hash uint64, row int) uint64 {
+	h := (hash ^ sketchSeeds[row]) * 0x9e3779b97f4a7c15
+	return (h ^ h>>32) & s.mask
+}
+
+func (s *cmSketch) increment(hash uint64) {
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(hash, i)]; *c < 15 {
+			*c++
+		}
+	}
+	if s.additions++; s.additions >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *cmSketch) estimate(hash uint64) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(hash, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
@@ -0,0 +1,121 @@
+package simpledb
+
+import (
+	"testing"
+)
+
+func newEntries(n int) []*cacheEntry {
+	entries := make([]*cacheEntry, n)
+	for i := range entries {
+		entries[i] = &cacheEntry{key: i, hash: hashKey(i)}
+	}
+	return entries
+}
+
+func TestPolicy_LRU(t *testing.T) {
+	p := newPolicy(PolicyLRU, 0)
+	entries := newEntries(3)
+	for _, e := range entries {
+		p.add(e)
+	}
+	p.access(entries[0])
+	if v := p.victim(nil); v != entries[1] {
+		t.Errorf("lru failed, victim(%+v) should be 1", v.key)
+		return
+	}
+	p.remove(entries[1])
+	if v := p.victim(nil); v != entries[2] {
+		t.Errorf("lru failed, victim(%+v) should be 2", v.key)
+		return
+	}
+	if v := p.victim(entries[2]); v != entries[0] {
+		t.Errorf("lru failed, victim(%+v) should be 0 when skipping 2", v.key)
+	}
+}
+
+func TestPolicy_LFU(t *testing.T) {
+	p := newPolicy(PolicyLFU, 0)
+	entries := newEntries(3)
+	for _, e := range entries {
+		p.add(e)
+	}
+	p.access(entries[0])
+	p.access(entries[0])
+	p.access(entries[1])
+	if v := p.victim(nil); v != entries[2] {
+		t.Errorf("lfu failed, victim(%+v) should be 2", v.key)
+		return
+	}
+	if v := p.victim(entries[2]); v != entries[1] {
+		t.Errorf("lfu failed, victim(%+v) should be 1 when skipping 2", v.key)
+		return
+	}
+	p.access(entries[2])
+	p.access(entries[2])
+	// 1和2次数相同时淘汰最久未访问的1
+	if v := p.victim(nil); v != entries[1] {
+		t.Errorf("lfu failed, victim(%+v) should be 1", v.key)
+		return
+	}
+	p.remove(entries[1])
+	p.remove(entries[0])
+	if v := p.victim(nil); v != entries[2] {
+		t.Errorf("lfu failed, victim(%+v) should be 2", v.key)
+	}
+}
+
+func TestPolicy_TinyLFU(t *testing.T) {
+	p := newPolicy(PolicyTinyLFU, 100)
+	entries := newEntries(100)
+	for _, e := range entries {
+		p.add(e)
+	}
+	// 0~9被频繁访问，新加入的数据只访问一次，淘汰时新数据不应挤掉热点数据
+	for i := 0; i < 5; i++ {
+		for _, e := range entries[:10] {
+			p.access(e)
+		}
+	}
+	for _, e := range newEntries(200)[100:] {
+		p.add(e)
+		v := p.victim(nil)
+		if v.key.(int) < 10 {
+			t.Errorf("tiny lfu failed, hot entry %d should not be evicted", v.key)
+			return
+		}
+		p.remove(v)
+	}
+}
+
+func TestPolicy_Sampled(t *testing.T) {
+	p := newPolicy(PolicySampledLRU, 0)
+	entries := newEntries(3)
+	for _, e := range entries {
+		p.add(e)
+	}
+	p.remove(entries[0])
+	p.remove(entries[2])
+	if v := p.victim(nil); v != entries[1] {
+		t.Errorf("sampled failed, victim(%+v) should be 1", v.key)
+		return
+	}
+	p.remove(entries[1])
+	if v := p.victim(nil); v != nil {
+		t.Errorf("sampled failed, victim(%+v) should be nil", v.key)
+		return
+	}
+
+	p = newPolicy(PolicyVolatileTTL, 0)
+	entries = newEntries(3)
+	entries[1].expireAt = 200
+	entries[2].expireAt = 100
+	for _, e := range entries {
+		p.add(e)
+	}
+	for i := 0; i < 10; i++ {
+		if v := p.victim(nil); v == entries[0] {
+			t.Errorf("volatile ttl failed, entry without ttl should not be evicted")
+			return
+		}
+	}
+}
@@ -0,0 +1,609 @@
+package simpledb
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// FieldKey 查询条件和排序中表示主键的字段名，其他字段名都是索引名
+const FieldKey = "$key"
+
+type condOp byte
+
+const (
+	opEq condOp = iota + 1
+	opIn
+	opRange
+	opPrefix
+	opAnd
+	opOr
+	opNot
+)
+
+// Cond 查询条件，由Eq、In、Range、Prefix、And、Or、Not创建，零值匹配所有数据
+type Cond struct {
+	op       condOp
+	field    string
+	values   []interface{}
+	children []Cond
+}
+
+// Eq 字段等于v
+func Eq(field string, v interface{}) Cond {
+	return Cond{op: opEq, field: field, values: []interface{}{v}}
+}
+
+// In 字段等于values中的任意一个
+func In(field string, values ...interface{}) Cond {
+	return Cond{op: opIn, field: field, values: values}
+}
+
+// Range 字段在[start, end)之间，start或end为nil时表示不限
+func Range(field string, start, end interface{}) Cond {
+	return Cond{op: opRange, field: field, values: []interface{}{start, end}}
+}
+
+// Prefix 字段以prefix开头，字段需为string，主键为CustomKey时按CustomKey.Key()匹配，
+// 用于索引时要求索引的less与字符串的字典序一致
+func Prefix(field, prefix string) Cond {
+	return Cond{op: opPrefix, field: field, values: []interface{}{prefix}}
+}
+
+// And 满足所有条件
+func And(conds ...Cond) Cond {
+	return Cond{op: opAnd, children: conds}
+}
+
+// Or 满足任意一个条件
+func Or(conds ...Cond) Cond {
+	return Cond{op: opOr, children: conds}
+}
+
+// Not 不满足条件
+func Not(c Cond) Cond {
+	return Cond{op: opNot, children: []Cond{c}}
+}
+
+// Q 可组合的查询，由Where创建，默认按主键升序返回所有满足条件的数据
+type Q struct {
+	where   Cond
+	orderBy string
+	desc    bool
+	limit   int
+	offset  int
+}
+
+// Where 创建查询，多个条件之间为And的关系，没有条件时匹配所有数据
+func Where(conds ...Cond) *Q {
+	q := &Q{orderBy: FieldKey}
+	switch len(conds) {
+	case 0:
+	case 1:
+		q.where = conds[0]
+	default:
+		q.where = And(conds...)
+	}
+	return q
+}
+
+// OrderBy 按字段排序，按索引排序时字段相同的数据按主键排序，没有该字段的数据视为最大
+func (q *Q) OrderBy(field string, desc bool) *Q {
+	q.orderBy = field
+	q.desc = desc
+	return q
+}
+
+// Limit 最多返回n条数据，小于等于0时不限制
+func (q *Q) Limit(n int) *Q {
+	q.limit = n
+	return q
+}
+
+// Offset 跳过前n条数据
+func (q *Q) Offset(n int) *Q {
+	q.offset = n
+	return q
+}
+
+// ScanType 查询访问数据的方式
+type ScanType byte
+
+const (
+	// ScanFull 遍历全表
+	ScanFull ScanType = iota
+	// ScanKeyLookup 按主键逐个查找
+	ScanKeyLookup
+	// ScanKeyRange 按主键的范围扫描
+	ScanKeyRange
+	// ScanIndex 按索引扫描
+	ScanIndex
+)
+
+func (s ScanType) String() string {
+	switch s {
+	case ScanKeyLookup:
+		return "key lookup"
+	case ScanKeyRange:
+		return "key range scan"
+	case ScanIndex:
+		return "index scan"
+	}
+	return "full scan"
+}
+
+// Plan 查询计划，所有条件都会在扫描到的数据上再检查一次
+type Plan struct {
+	Scan ScanType
+	// Index 按索引扫描时使用的索引
+	Index string
+	// Sort 扫描的顺序与排序不一致，需要在内存中排序
+	Sort bool
+}
+
+func (p Plan) String() string {
+	s := p.Scan.String()
+	if p.Scan == ScanIndex {
+		s += " on " + p.Index
+	}
+	if p.Sort {
+		s += ", sort"
+	}
+	return s
+}
+
+// span 扫描的一段范围，point为true时start与end相等
+type span struct {
+	start, end interface{}
+	includeEnd bool
+	point      bool
+}
+
+// plan 编译后的查询，rank越小访问的数据越少
+type plan[K, V any] struct {
+	Plan
+	q       *Q
+	rank    int
+	indexes map[string]*index[K, V]
+	ix      *index[K, V]
+	keys    []K
+	spans   []span
+}
+
+// compile 选出访问数据的方式，只考虑顶层And中的条件，Or和Not只作为过滤条件
+func (d *TypedDB[K, V]) compile(q *Q) (*plan[K, V], error) {
+	if q == nil {
+		q = Where()
+	}
+	p := &plan[K, V]{
+		Plan:    Plan{Scan: ScanFull},
+		q:       q,
+		rank:    5,
+		indexes: make(map[string]*index[K, V]),
+	}
+	d.mu.RLock()
+	err := p.resolve(d.indexes, q.where, q.orderBy)
+	d.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	conds := []Cond{q.where}
+	if q.where.op == opAnd {
+		conds = q.where.children
+	}
+	for _, c := range conds {
+		d.choose(p, c)
+	}
+	switch {
+	case q.orderBy == FieldKey:
+		p.Sort = p.Scan == ScanIndex && !(len(p.spans) == 1 && p.spans[0].point)
+	default:
+		p.Sort = p.Scan != ScanIndex || p.Index != q.orderBy
+	}
+	return p, nil
+}
+
+// resolve 找到条件和排序中用到的索引
+func (p *plan[K, V]) resolve(indexes map[string]*index[K, V], c Cond, fields ...string) error {
+	if c.op != 0 && c.op < opAnd {
+		fields = append(fields, c.field)
+	}
+	for _, field := range fields {
+		if field == FieldKey {
+			continue
+		}
+		ix, ok := indexes[field]
+		if !ok {
+			return errors.ErrIndexNotFound
+		}
+		p.indexes[field] = ix
+	}
+	for _, child := range c.children {
+		if err := p.resolve(indexes, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// choose 条件c可以用于访问数据且比当前的方式更好时替换
+func (d *TypedDB[K, V]) choose(p *plan[K, V], c Cond) {
+	var (
+		rank  int
+		scan  ScanType
+		keys  []K
+		spans []span
+		ix    = p.indexes[c.field]
+	)
+	switch {
+	case c.op == 0 || c.op >= opAnd:
+		return
+	case c.field == FieldKey && (c.op == opEq || c.op == opIn):
+		rank, scan = 0, ScanKeyLookup
+		for _, v := range c.values {
+			if key, ok := v.(K); ok && any(key) != nil {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return d.cmp(keys[i], keys[j]) < 0 })
+		keys = dedupe(keys, func(a, b K) bool { return d.cmp(a, b) == 0 })
+	case c.op == opEq || c.op == opIn:
+		rank, scan = 3, ScanIndex
+		if ix.unique {
+			rank = 2
+		}
+		values := append([]interface{}(nil), c.values...)
+		sort.Slice(values, func(i, j int) bool { return ix.less(values[i], values[j]) })
+		values = dedupe(values, func(a, b interface{}) bool { return ix.compare(a, b) == 0 })
+		for _, v := range values {
+			spans = append(spans, span{start: v, end: v, includeEnd: true, point: true})
+		}
+	case c.op == opRange:
+		spans = []span{{start: c.values[0], end: c.values[1]}}
+	case c.op == opPrefix:
+		if c.field == FieldKey && !d.stringKey {
+			return
+		}
+		prefix := c.values[0].(string)
+		s := span{start: prefix}
+		if end, ok := prefixEnd(prefix); ok {
+			s.end = end
+		}
+		spans = []span{s}
+	}
+	if c.op == opRange || c.op == opPrefix {
+		rank, scan = 4, ScanIndex
+		if c.field == FieldKey {
+			rank, scan = 1, ScanKeyRange
+		}
+	}
+	if rank >= p.rank {
+		return
+	}
+	p.rank, p.Scan, p.keys, p.spans = rank, scan, keys, spans
+	p.Index, p.ix = "", nil
+	if scan == ScanIndex {
+		p.Index, p.ix = c.field, ix
+	}
+}
+
+func dedupe[T any](values []T, equal func(a, b T) bool) []T {
+	ret := values[:0]
+	for i, v := range values {
+		if i == 0 || !equal(v, ret[len(ret)-1]) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// scan 按计划访问数据，reverse为true时反向，fn返回false时停止
+func (d *TypedDB[K, V]) scan(p *plan[K, V], reverse bool, fn func(key K, v V) bool) {
+	switch p.Scan {
+	case ScanKeyLookup:
+		now := d.now()
+		for i := range p.keys {
+			key := p.keys[i]
+			if reverse {
+				key = p.keys[len(p.keys)-1-i]
+			}
+			if it, err := d.data.Get(key); err == nil && !d.expired(it, now) && !fn(key, it.value) {
+				return
+			}
+		}
+	case ScanKeyRange:
+		var start, end *K
+		if k, ok := p.spans[0].start.(K); ok {
+			start = &k
+		}
+		if k, ok := p.spans[0].end.(K); ok {
+			end = &k
+		}
+		d.scanKeys(start, end, RangeOptions{reverse: reverse}, fn)
+	case ScanIndex:
+		stopped := false
+		visit := func(key K, v V) bool {
+			stopped = !fn(key, v)
+			return !stopped
+		}
+		for i := range p.spans {
+			s := p.spans[i]
+			if reverse {
+				s = p.spans[len(p.spans)-1-i]
+			}
+			d.scanIndex(p.ix, s.start, s.end, RangeOptions{includeEnd: s.includeEnd, reverse: reverse}, visit)
+			if stopped {
+				return
+			}
+		}
+	default:
+		d.scanKeys(nil, nil, RangeOptions{reverse: reverse}, fn)
+	}
+}
+
+// run 按排序依次返回满足条件以及所有查询函数的数据，fn返回false时停止
+func (d *TypedDB[K, V]) run(p *plan[K, V], queries []func(v V) bool, fn func(key K, v V) bool) {
+	matched := func(key K, v V) bool {
+		return p.match(d, p.q.where, key, v) && match(v, queries)
+	}
+	if !p.Sort {
+		d.scan(p, p.q.desc, func(key K, v V) bool {
+			return !matched(key, v) || fn(key, v)
+		})
+		return
+	}
+	var rows []KV[K, V]
+	d.scan(p, false, func(key K, v V) bool {
+		if matched(key, v) {
+			rows = append(rows, KV[K, V]{Key: key, Value: v})
+		}
+		return true
+	})
+	order := p.indexes[p.q.orderBy]
+	sort.SliceStable(rows, func(i, j int) bool {
+		c := 0
+		if order != nil {
+			c = compareField(order, rows[i].Value, rows[j].Value)
+		}
+		if c == 0 {
+			c = d.cmp(rows[i].Key, rows[j].Key)
+		}
+		if p.q.desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	for _, r := range rows {
+		if !fn(r.Key, r.Value) {
+			return
+		}
+	}
+}
+
+// compareField 比较两条数据的索引字段，没有该字段的数据视为最大
+func compareField[K, V any](ix *index[K, V], a, b V) int {
+	fa, oka := ix.extractor(a)
+	fb, okb := ix.extractor(b)
+	switch {
+	case !oka && !okb:
+		return 0
+	case !oka:
+		return 1
+	case !okb:
+		return -1
+	}
+	return ix.compare(fa, fb)
+}
+
+// match 数据是否满足条件c
+func (p *plan[K, V]) match(d *TypedDB[K, V], c Cond, key K, v V) bool {
+	switch c.op {
+	case 0:
+		return true
+	case opAnd:
+		for _, child := range c.children {
+			if !p.match(d, child, key, v) {
+				return false
+			}
+		}
+		return true
+	case opOr:
+		for _, child := range c.children {
+			if p.match(d, child, key, v) {
+				return true
+			}
+		}
+		return false
+	case opNot:
+		return !p.match(d, c.children[0], key, v)
+	}
+	var (
+		field   interface{} = key
+		compare             = func(a, b interface{}) (int, bool) {
+			bk, ok := b.(K)
+			if !ok {
+				return 0, false
+			}
+			return d.cmp(a.(K), bk), true
+		}
+	)
+	if c.field != FieldKey {
+		ix := p.indexes[c.field]
+		var ok bool
+		if field, ok = ix.extractor(v); !ok {
+			return false
+		}
+		compare = func(a, b interface{}) (int, bool) {
+			return ix.compare(a, b), true
+		}
+	}
+	switch c.op {
+	case opEq, opIn:
+		for _, value := range c.values {
+			if r, ok := compare(field, value); ok && r == 0 {
+				return true
+			}
+		}
+		return false
+	case opRange:
+		if start := c.values[0]; start != nil {
+			if r, ok := compare(field, start); !ok || r < 0 {
+				return false
+			}
+		}
+		if end := c.values[1]; end != nil {
+			if r, ok := compare(field, end); !ok || r >= 0 {
+				return false
+			}
+		}
+		return true
+	case opPrefix:
+		s, ok := field.(string)
+		if custom, isCustom := field.(CustomKey); isCustom {
+			s, ok = custom.Key(), true
+		}
+		return ok && strings.HasPrefix(s, c.values[0].(string))
+	}
+	return false
+}
+
+// ListQuery 按查询返回数据，queries作为额外的过滤条件，q为nil时返回所有数据
+func (d *TypedDB[K, V]) ListQuery(q *Q, queries ...func(v V) bool) ([]V, error) {
+	if !d.life.enter() {
+		return nil, errors.ErrClosed
+	}
+	defer d.life.leave()
+	p, err := d.compile(q)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ret  []V
+		skip = p.q.offset
+	)
+	d.run(p, queries, func(key K, v V) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		ret = append(ret, v)
+		return p.q.limit <= 0 || len(ret) < p.q.limit
+	})
+	return ret, nil
+}
+
+// CountQuery 统计满足查询条件以及所有查询函数的数据，忽略排序、Limit和Offset
+func (d *TypedDB[K, V]) CountQuery(q *Q, queries ...func(v V) bool) (int, error) {
+	if !d.life.enter() {
+		return 0, errors.ErrClosed
+	}
+	defer d.life.leave()
+	p, err := d.compile(q)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	d.scan(p, false, func(key K, v V) bool {
+		if p.match(d, p.q.where, key, v) && match(v, queries) {
+			count++
+		}
+		return true
+	})
+	return count, nil
+}
+
+// Explain 返回查询的执行计划
+func (d *TypedDB[K, V]) Explain(q *Q) (Plan, error) {
+	p, err := d.compile(q)
+	if err != nil {
+		return Plan{}, err
+	}
+	return p.Plan, nil
+}
+
+// ListQuery 按查询返回数据，queries作为额外的过滤条件，q为nil时返回所有数据，
+// 主键的条件同Get支持CustomKey.Key()
+func (d *DB) ListQuery(q *Q, queries ...Query) ([]interface{}, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	q, err := d.normalize(q)
+	if err != nil {
+		return nil, err
+	}
+	return d.typed.ListQuery(q, funcs(queries)...)
+}
+
+// CountQuery 统计满足查询条件以及所有查询函数的数据，忽略排序、Limit和Offset
+func (d *DB) CountQuery(q *Q, queries ...Query) (int, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	q, err := d.normalize(q)
+	if err != nil {
+		return 0, err
+	}
+	return d.typed.CountQuery(q, funcs(queries)...)
+}
+
+// Explain 返回查询的执行计划
+func (d *DB) Explain(q *Q) (Plan, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	q, err := d.normalize(q)
+	if err != nil {
+		return Plan{}, err
+	}
+	return d.typed.Explain(q)
+}
+
+// normalize 将主键条件中的值转换为实际存储的key，Eq和In中找不到的key不会匹配任何数据
+func (d *DB) normalize(q *Q) (*Q, error) {
+	if q == nil {
+		return nil, nil
+	}
+	where, err := d.normalizeCond(q.where)
+	if err != nil {
+		return nil, err
+	}
+	nq := *q
+	nq.where = where
+	return &nq, nil
+}
+
+func (d *DB) normalizeCond(c Cond) (Cond, error) {
+	if c.op >= opAnd {
+		children := make([]Cond, 0, len(c.children))
+		for _, child := range c.children {
+			child, err := d.normalizeCond(child)
+			if err != nil {
+				return c, err
+			}
+			children = append(children, child)
+		}
+		c.children = children
+		return c, nil
+	}
+	if c.field != FieldKey || c.op == opPrefix {
+		return c, nil
+	}
+	values := make([]interface{}, 0, len(c.values))
+	for _, v := range c.values {
+		if v == nil && c.op == opRange {
+			values = append(values, nil)
+			continue
+		}
+		k, err := d.lookupKey(v)
+		if err != nil {
+			if c.op == opRange {
+				return c, err
+			}
+			continue
+		}
+		values = append(values, k)
+	}
+	c.values = values
+	return c, nil
+}
@@ -0,0 +1,125 @@
+package simpledb
+
+import (
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func newQueryDB() *DB {
+	db := NewDB()
+	_ = db.CreateIndex("age", ageOf, lessInt)
+	_ = db.CreateIndex("email", emailOf, lessString, IndexOptionUnique())
+	_ = db.Save("user:a", indexUser{Name: "a", Age: 30, Email: "a@x"})
+	_ = db.Save("user:b", indexUser{Name: "b", Age: 20, Email: "b@x"})
+	_ = db.Save("user:c", indexUser{Name: "c", Age: 20})
+	_ = db.Save("user:d", indexUser{Name: "d", Age: 40, Email: "d@x"})
+	_ = db.Save("group:e", indexUser{Name: "e", Age: 50})
+	return db
+}
+
+func TestDB_Explain(t *testing.T) {
+	db := newQueryDB()
+	cases := []struct {
+		q    *Q
+		plan string
+	}{
+		{Where(), "full scan"},
+		{Where(Eq(FieldKey, "user:a")), "key lookup"},
+		{Where(Prefix(FieldKey, "user:"), Eq("age", 20)), "key range scan"},
+		{Where(Eq("age", 20), Eq("email", "b@x")), "index scan on email"},
+		{Where(Eq("age", 20)).OrderBy("age", true), "index scan on age"},
+		{Where(Range("age", 20, 40)), "index scan on age, sort"},
+		{Where(Range("age", 20, 40)).OrderBy("age", false), "index scan on age"},
+		{Where(Or(Eq("age", 20), Eq(FieldKey, "user:a"))), "full scan"},
+		{Where().OrderBy("age", false), "full scan, sort"},
+	}
+	for _, c := range cases {
+		plan, err := db.Explain(c.q)
+		if err != nil {
+			t.Errorf("explain failed, err: %+v", err)
+			return
+		}
+		if plan.String() != c.plan {
+			t.Errorf("explain failed, plan(%s) should be %s", plan, c.plan)
+			return
+		}
+	}
+	if _, err := db.Explain(Where(Eq("name", "a"))); err != errors.ErrIndexNotFound {
+		t.Errorf("explain failed, err(%+v) should be ErrIndexNotFound", err)
+		return
+	}
+}
+
+func TestDB_ListQuery(t *testing.T) {
+	db := newQueryDB()
+	list, err := db.ListQuery(Where(Prefix(FieldKey, "user:"), Range("age", 20, 40)))
+	if err != nil {
+		t.Errorf("list query failed, err: %+v", err)
+		return
+	}
+	if names(list) != "a,b,c" {
+		t.Errorf("list query failed, list(%s) should be a,b,c", names(list))
+		return
+	}
+	// 按索引排序，字段相同时按主键排序
+	list, _ = db.ListQuery(Where(Not(Eq("age", 30))).OrderBy("age", true).Offset(1).Limit(3))
+	if names(list) != "d,c,b" {
+		t.Errorf("list query failed, list(%s) should be d,c,b", names(list))
+		return
+	}
+	// 没有该字段的数据排在最后
+	list, _ = db.ListQuery(Where(In(FieldKey, "user:a", "user:c", "user:x")).OrderBy("email", false))
+	if names(list) != "a,c" {
+		t.Errorf("list query failed, list(%s) should be a,c", names(list))
+		return
+	}
+	list, _ = db.ListQuery(Where(Or(Eq("email", "d@x"), Eq("age", 50))).OrderBy(FieldKey, true))
+	if names(list) != "d,e" {
+		t.Errorf("list query failed, list(%s) should be d,e", names(list))
+		return
+	}
+	// 普通的查询函数作为额外的过滤条件
+	list, _ = db.ListQuery(Where(Eq("age", 20)), func(v interface{}) bool {
+		return v.(indexUser).Email == ""
+	})
+	if names(list) != "c" {
+		t.Errorf("list query failed, list(%s) should be c", names(list))
+		return
+	}
+	count, err := db.CountQuery(Where(Prefix(FieldKey, "user:")).Limit(1), func(v interface{}) bool {
+		return v.(indexUser).Age >= 30
+	})
+	if err != nil || count != 2 {
+		t.Errorf("count query failed, count(%d) should be 2, err: %+v", count, err)
+		return
+	}
+	// 已删除的数据不会从索引中返回
+	_ = db.Delete("user:b")
+	if list, _ = db.ListQuery(Where(Eq("age", 20))); names(list) != "c" {
+		t.Errorf("list query failed, list(%s) should be c", names(list))
+		return
+	}
+}
+
+func TestTypedDB_ListQuery(t *testing.T) {
+	db, err := OpenTypedDB[int, string](func(a, b int) int { return a - b })
+	if err != nil {
+		t.Errorf("open typed db failed, err: %+v", err)
+		return
+	}
+	defer db.Close()
+	for i, v := range []string{"a", "b", "c", "d", "e"} {
+		_ = db.Save(i, v)
+	}
+	plan, _ := db.Explain(Where(Range(FieldKey, 1, 4)))
+	if plan.Scan != ScanKeyRange {
+		t.Errorf("explain failed, plan(%s) should be key range scan", plan)
+		return
+	}
+	list, _ := db.ListQuery(Where(Range(FieldKey, 1, 4), Not(Eq(FieldKey, 2))).OrderBy(FieldKey, true))
+	if len(list) != 2 || list[0] != "d" || list[1] != "b" {
+		t.Errorf("list query failed, list(%v) should be [d b]", list)
+		return
+	}
+}
@@ -0,0 +1,167 @@
+package simpledb
+
+import (
+	"strings"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// KV 范围查询返回的一条数据
+type KV[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// Range 返回key在start和end之间的数据，start或end为nil时表示不限，
+// 通过跳表寻址直接定位到边界，不会扫描范围之外的数据
+func (d *TypedDB[K, V]) Range(start, end *K, opts ...RangeOption) []KV[K, V] {
+	var o RangeOptions
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	var ret []KV[K, V]
+	d.scanKeys(start, end, o, func(key K, v V) bool {
+		ret = append(ret, KV[K, V]{Key: key, Value: v})
+		return o.limit <= 0 || len(ret) < o.limit
+	})
+	return ret
+}
+
+// scanKeys 按key的顺序遍历start和end之间且未过期的数据，fn返回false时停止，o中的limit不生效
+func (d *TypedDB[K, V]) scanKeys(start, end *K, o RangeOptions, fn func(key K, v V) bool) {
+	iter := d.Iterator()
+	// afterStart、beforeEnd 判断key是否满足起点和终点的约束
+	afterStart := func(key K) bool {
+		if start == nil {
+			return true
+		}
+		c := d.cmp(key, *start)
+		return c > 0 || (c == 0 && !o.excludeStart)
+	}
+	beforeEnd := func(key K) bool {
+		if end == nil {
+			return true
+		}
+		c := d.cmp(key, *end)
+		return c < 0 || (c == 0 && o.includeEnd)
+	}
+	if o.reverse {
+		if end == nil {
+			iter.SeekToLast()
+		} else {
+			iter.Seek(*end)
+			if !iter.Valid() {
+				iter.SeekToLast()
+			}
+			for iter.Valid() && !beforeEnd(iter.Key()) {
+				iter.Prev()
+			}
+		}
+	} else {
+		if start == nil {
+			iter.SeekToFirst()
+		} else {
+			iter.Seek(*start)
+			for iter.Valid() && !afterStart(iter.Key()) {
+				iter.HasNext()
+			}
+		}
+	}
+	for iter.Valid() && afterStart(iter.Key()) && beforeEnd(iter.Key()) {
+		if !fn(iter.Key(), iter.Value()) {
+			break
+		}
+		if o.reverse {
+			iter.Prev()
+		} else {
+			iter.HasNext()
+		}
+	}
+	iter.Close()
+}
+
+// Range 返回key在start和end之间的数据，start或end为nil时表示不限，
+// 顺序由key的类型决定，string按字典序，CustomKey按创建db时传入的less，
+// 同Get支持CustomKey.Key()作为边界
+func (d *DB) Range(start, end interface{}, opts ...RangeOption) ([]KV[interface{}, interface{}], error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if d.typed.life.isClosed() {
+		return nil, errors.ErrClosed
+	}
+	var bounds [2]*interface{}
+	for i, key := range []interface{}{start, end} {
+		if key == nil {
+			continue
+		}
+		k, err := d.lookupKey(key)
+		if err != nil {
+			return nil, err
+		}
+		bounds[i] = &k
+	}
+	return d.typed.Range(bounds[0], bounds[1], opts...), nil
+}
+
+// Prefix 返回key以prefix开头的数据，string类型的key会直接定位到前缀所在的范围，
+// CustomKey按CustomKey.Key()匹配前缀，由于其顺序由less决定，需要遍历全表
+func (d *DB) Prefix(prefix string, opts ...RangeOption) ([]KV[interface{}, interface{}], error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if d.typed.life.isClosed() {
+		return nil, errors.ErrClosed
+	}
+	var o RangeOptions
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	if d.typ == String {
+		var start, end interface{} = prefix, nil
+		if next, ok := prefixEnd(prefix); ok {
+			end = next
+		}
+		// 前缀的范围固定为[prefix, end)，只保留数量和顺序的选项
+		opts = []RangeOption{RangeOptionLimit(o.limit)}
+		if o.reverse {
+			opts = append(opts, RangeOptionReverse())
+		}
+		return d.Range(start, end, opts...)
+	}
+	var (
+		iter = d.typed.Iterator()
+		ret  []KV[interface{}, interface{}]
+	)
+	if o.reverse {
+		iter.SeekToLast()
+	} else {
+		iter.SeekToFirst()
+	}
+	for iter.Valid() {
+		custom, ok := iter.Key().(CustomKey)
+		if ok && strings.HasPrefix(custom.Key(), prefix) {
+			ret = append(ret, KV[interface{}, interface{}]{Key: iter.Key(), Value: iter.Value()})
+			if o.limit > 0 && len(ret) >= o.limit {
+				break
+			}
+		}
+		if o.reverse {
+			iter.Prev()
+		} else {
+			iter.HasNext()
+		}
+	}
+	iter.Close()
+	return ret, nil
+}
+
+// prefixEnd 大于所有以prefix开头的字符串的最小字符串，prefix全为0xff时不存在
+func prefixEnd(prefix string) (string, bool) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1}), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,105 @@
+package simpledb
+
+import (
+	"testing"
+)
+
+func keysOf(kvs []KV[interface{}, interface{}]) []string {
+	var keys []string
+	for _, kv := range kvs {
+		if custom, ok := kv.Key.(CustomKey); ok {
+			keys = append(keys, custom.Key())
+			continue
+		}
+		keys = append(keys, kv.Key.(string))
+	}
+	return keys
+}
+
+func equalKeys(out []string, exp ...string) bool {
+	if len(out) != len(exp) {
+		return false
+	}
+	for i := range out {
+		if out[i] != exp[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDB_Range(t *testing.T) {
+	db := NewDB()
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		_ = db.Save(k, k)
+	}
+	cases := []struct {
+		start, end interface{}
+		opts       []RangeOption
+		exp        []string
+	}{
+		{"b", "d", nil, []string{"b", "c"}},
+		{"b", "d", []RangeOption{RangeOptionIncludeEnd()}, []string{"b", "c", "d"}},
+		{"b", "d", []RangeOption{RangeOptionExcludeStart(), RangeOptionIncludeEnd()}, []string{"c", "d"}},
+		{"bb", nil, nil, []string{"c", "d", "e"}},
+		{nil, "c", []RangeOption{RangeOptionReverse()}, []string{"b", "a"}},
+		{"a", "e", []RangeOption{RangeOptionReverse(), RangeOptionIncludeEnd(), RangeOptionLimit(2)}, []string{"e", "d"}},
+		{"b", "z", []RangeOption{RangeOptionReverse(), RangeOptionExcludeStart()}, []string{"e", "d", "c"}},
+		{"f", nil, nil, nil},
+	}
+	for i, c := range cases {
+		out, err := db.Range(c.start, c.end, c.opts...)
+		if err != nil {
+			t.Errorf("range failed, case %d, err: %+v", i, err)
+			return
+		}
+		if keys := keysOf(out); !equalKeys(keys, c.exp...) {
+			t.Errorf("range failed, case %d, keys(%+v) should be %+v", i, keys, c.exp)
+			return
+		}
+	}
+}
+
+func TestDB_Prefix(t *testing.T) {
+	db := NewDB()
+	for _, k := range []string{"user:1", "user:1:name", "user:2", "user:3", "users", "user\xff"} {
+		_ = db.Save(k, k)
+	}
+	out, _ := db.Prefix("user:")
+	if keys := keysOf(out); !equalKeys(keys, "user:1", "user:1:name", "user:2", "user:3") {
+		t.Errorf("prefix failed, keys(%+v) should start with user:", keys)
+		return
+	}
+	out, _ = db.Prefix("user:", RangeOptionReverse(), RangeOptionLimit(2))
+	if keys := keysOf(out); !equalKeys(keys, "user:3", "user:2") {
+		t.Errorf("prefix failed, keys(%+v) should be in descending order", keys)
+		return
+	}
+	if out, _ = db.Prefix("user\xff"); len(out) != 1 {
+		t.Errorf("prefix failed, len(%d) should be 1", len(out))
+	}
+}
+
+func TestCustomDB_Range(t *testing.T) {
+	db := NewCustomDB(func(l, r interface{}) bool {
+		return l.(customKey).seq < r.(customKey).seq
+	})
+	for _, v := range newKVN() {
+		_ = db.Save(newCustomKey(v.key, v.seq), v)
+	}
+	out, err := db.Range(newCustomKey("", 2), nil)
+	if keys := keysOf(out); err != nil || !equalKeys(keys, "2", "3", "4") {
+		t.Errorf("range failed, keys(%+v) should be from 2, err: %+v", keys, err)
+		return
+	}
+	// 支持CustomKey.Key()作为边界
+	out, err = db.Range("1", "3", RangeOptionReverse())
+	if keys := keysOf(out); err != nil || !equalKeys(keys, "2", "1") {
+		t.Errorf("range failed, keys(%+v) should be 2 and 1, err: %+v", keys, err)
+		return
+	}
+	out, _ = db.Prefix("3")
+	if keys := keysOf(out); !equalKeys(keys, "3") {
+		t.Errorf("prefix failed, keys(%+v) should be 3", keys)
+	}
+}
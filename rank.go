@@ -0,0 +1,160 @@
+package simpledb
+
+import (
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/skiplist"
+)
+
+// ranker 存储支持按排名访问且没有已过期但还未清理的数据时返回跳表，此时排名与可见的数据一致，
+// 调用方需持有锁
+func (d *TypedDB[K, V]) ranker(now int64) (skiplist.Ranker[K, item[V]], bool) {
+	r, ok := d.data.(skiplist.Ranker[K, item[V]])
+	if !ok {
+		return nil, false
+	}
+	// 过期索引中的时间不晚于数据实际的过期时间，堆顶未过期时所有数据都未过期
+	if d.withExpired() && len(d.expiries) > 0 && d.expiries[0].expireAt <= now {
+		return nil, false
+	}
+	return r, true
+}
+
+// Rank key在所有未过期的数据中的排名，从0开始，key不存在时返回ErrNotFound，
+// 没有待清理的过期数据时为O(log n)，否则需要遍历
+func (d *TypedDB[K, V]) Rank(key K) (int, error) {
+	if any(key) == nil {
+		return 0, errors.ErrNilKey
+	}
+	if !d.life.enter() {
+		return 0, errors.ErrClosed
+	}
+	defer d.life.leave()
+	d.mu.RLock()
+	if r, ok := d.ranker(d.now()); ok {
+		rank, err := r.Rank(key)
+		d.mu.RUnlock()
+		return rank, err
+	}
+	d.mu.RUnlock()
+	var (
+		iter = d.Iterator()
+		rank int
+	)
+	defer iter.Close()
+	for iter.HasNext() {
+		switch c := d.cmp(iter.Key(), key); {
+		case c == 0:
+			return rank, nil
+		case c > 0:
+			return 0, errors.ErrNotFound
+		}
+		rank++
+	}
+	return 0, errors.ErrNotFound
+}
+
+// ByRank 返回排名为rank的数据，超出范围时返回ErrNotFound
+func (d *TypedDB[K, V]) ByRank(rank int) (key K, value V, err error) {
+	if !d.life.enter() {
+		return key, value, errors.ErrClosed
+	}
+	defer d.life.leave()
+	ret := d.rangeByRank(rank, rank+1)
+	if len(ret) == 0 {
+		return key, value, errors.ErrNotFound
+	}
+	return ret[0].Key, ret[0].Value, nil
+}
+
+// RangeByRank 返回排名在[start, end)之间的数据，超出范围的部分被忽略
+func (d *TypedDB[K, V]) RangeByRank(start, end int) []KV[K, V] {
+	if !d.life.enter() {
+		return nil
+	}
+	defer d.life.leave()
+	return d.rangeByRank(start, end)
+}
+
+func (d *TypedDB[K, V]) rangeByRank(start, end int) []KV[K, V] {
+	if start < 0 {
+		start = 0
+	}
+	if start >= end {
+		return nil
+	}
+	var ret []KV[K, V]
+	d.mu.RLock()
+	if r, ok := d.ranker(d.now()); ok {
+		for _, e := range r.RangeByRank(start, end) {
+			ret = append(ret, KV[K, V]{Key: e.Key, Value: e.Value.value})
+		}
+		d.mu.RUnlock()
+		return ret
+	}
+	d.mu.RUnlock()
+	iter := d.Iterator()
+	for rank := 0; rank < end && iter.HasNext(); rank++ {
+		if rank >= start {
+			ret = append(ret, KV[K, V]{Key: iter.Key(), Value: iter.Value()})
+		}
+	}
+	iter.Close()
+	return ret
+}
+
+// rankIterator 返回定位到排名为rank的迭代器，超出范围时迭代器失效，迭代器与排名在同一个读锁内取得，
+// 期间的写入不会使两者不一致。存在已过期或值为nil的数据时排名与List可见的数据不一致，返回false
+func (d *TypedDB[K, V]) rankIterator(rank int) (skiplist.Iterator[K, V], bool) {
+	d.mu.RLock()
+	now := d.now()
+	r, ok := d.ranker(now)
+	if !ok || d.nils > 0 {
+		d.mu.RUnlock()
+		return nil, false
+	}
+	iter := &iterator[K, V]{
+		db:   d,
+		iter: d.data.Iterator(),
+		now:  now,
+	}
+	key, _, err := r.ByRank(rank)
+	d.mu.RUnlock()
+	if err != nil {
+		iter.SeekToLast()
+		iter.HasNext()
+		return iter, true
+	}
+	iter.Seek(key)
+	return iter, true
+}
+
+// Rank key的排名，从0开始，同Get支持CustomKey.Key()作为寻址key
+func (d *DB) Rank(key interface{}) (int, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	k, err := d.lookupKey(key)
+	if err != nil {
+		return 0, err
+	}
+	return d.typed.Rank(k)
+}
+
+// ByRank 返回排名为rank的数据，超出范围时返回ErrNotFound
+func (d *DB) ByRank(rank int) (key, value interface{}, err error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.ByRank(rank)
+}
+
+// RangeByRank 返回排名在[start, end)之间的数据，超出范围的部分被忽略
+func (d *DB) RangeByRank(start, end int) ([]KV[interface{}, interface{}], error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	if d.typed.life.isClosed() {
+		return nil, errors.ErrClosed
+	}
+	return d.typed.RangeByRank(start, end), nil
+}
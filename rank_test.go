@@ -0,0 +1,120 @@
+package simpledb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/clocktest"
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_Rank(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 100; i++ {
+		_ = db.Save(fmt.Sprintf("%03d", i), i)
+	}
+	_ = db.Delete("010")
+	if rank, err := db.Rank("050"); err != nil || rank != 49 {
+		t.Errorf("rank failed, rank(%d) should be 49, err: %+v", rank, err)
+		return
+	}
+	if _, err := db.Rank("010"); err != errors.ErrNotFound {
+		t.Errorf("rank failed, err(%+v) should be ErrNotFound", err)
+		return
+	}
+	if key, v, err := db.ByRank(10); err != nil || key != "011" || v != 11 {
+		t.Errorf("by rank failed, key(%v) should be 011, err: %+v", key, err)
+		return
+	}
+	list, _ := db.RangeByRank(97, 200)
+	if len(list) != 2 || list[0].Key != "098" || list[1].Key != "099" {
+		t.Errorf("range by rank failed, list(%v) should be 098,099", list)
+		return
+	}
+	// 没有查询函数时直接定位到该页
+	out, hasNextPage, _ := db.List(5, 20)
+	if len(out) != 19 || out[0] != 81 || hasNextPage {
+		t.Errorf("list failed, out(%v) should start with 81 and be the last page", out)
+		return
+	}
+	if out, hasNextPage, _ = db.List(3, 10); len(out) != 10 || out[0] != 21 || !hasNextPage {
+		t.Errorf("list failed, out(%v) should start with 21", out)
+		return
+	}
+	if out, _, _ = db.List(20, 10); len(out) != 0 {
+		t.Errorf("list failed, out(%v) should be empty", out)
+		return
+	}
+}
+
+func TestDB_RankWithExpired(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock))
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		var opts []SaveOption
+		if i < 3 {
+			opts = append(opts, SaveOptionTTLDuration(300*time.Millisecond))
+		}
+		_ = db.Save(fmt.Sprintf("%d", i), i, opts...)
+	}
+	// 还没到后台清理的时间，已过期但还未清理的数据不计入排名
+	clock.Advance(500 * time.Millisecond)
+	if rank, err := db.Rank("5"); err != nil || rank != 2 {
+		t.Errorf("rank failed, rank(%d) should be 2, err: %+v", rank, err)
+		return
+	}
+	if out, _, _ := db.List(2, 3); len(out) != 3 || out[0] != 6 {
+		t.Errorf("list failed, out(%v) should start with 6", out)
+		return
+	}
+}
+
+func TestDB_ListByRankWithInvisible(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock))
+	defer db.Close()
+	for i := 0; i < 30; i++ {
+		var opts []SaveOption
+		if i%4 == 0 {
+			opts = append(opts, SaveOptionTTLDuration(300*time.Millisecond))
+		}
+		_ = db.Save(fmt.Sprintf("%02d", i), i, opts...)
+	}
+	all := func(v interface{}) bool { return true }
+	// 按排名定位与逐个遍历的结果应该一致
+	check := func(state string) bool {
+		for page := int32(1); page <= 6; page++ {
+			exp, expNext, _ := db.List(page, 5, all)
+			out, hasNext, _ := db.List(page, 5)
+			if fmt.Sprint(out) != fmt.Sprint(exp) || hasNext != expNext {
+				t.Errorf("list failed with %s, page %d(%v, %v) should be %v, %v", state, page, out, hasNext, exp, expNext)
+				return false
+			}
+		}
+		return true
+	}
+	if !check("all visible") {
+		return
+	}
+	// 已过期但还未清理
+	clock.Advance(500 * time.Millisecond)
+	if !check("expired keys") {
+		return
+	}
+	clock.Advance(time.Second)
+	_ = db.Save("05", nil)
+	_ = db.Save("17", nil)
+	if !check("nil values") {
+		return
+	}
+	_ = db.Save("05", 5)
+	_ = db.Delete("17")
+	if !check("nil values removed") {
+		return
+	}
+	if n := db.typed.nils; n != 0 {
+		t.Errorf("list failed, nils(%d) should be 0", n)
+	}
+}
@@ -0,0 +1,133 @@
+package simpledb
+
+import (
+	"context"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// staleAt 计算软过期时间，指定了软过期时长时为now之后soft，否则按提前刷新的比例计算，不过期的数据没有软过期时间
+func (d *TypedDB[K, V]) staleAt(now, expireAt int64, soft time.Duration) int64 {
+	switch {
+	case expireAt == 0:
+		return 0
+	case soft > 0:
+		return now + millis(soft)
+	case d.conf.refreshAhead > 0 && expireAt > now:
+		return now + int64(float64(expireAt-now)*d.conf.refreshAhead)
+	}
+	return 0
+}
+
+// minRefreshBackoff 刷新失败后至少推迟的时间
+const minRefreshBackoff = time.Second
+
+// refresh 在后台刷新软过期的数据，key正在刷新或者加载时不重复发起，失败时推迟下一次刷新
+func (d *TypedDB[K, V]) refresh(key K, stamp uint64) {
+	l := d.loads
+	l.mu.Lock()
+	if _, err := l.calls.Get(key); err != nil {
+		loader := d.refreshLoader(key)
+		c := d.startLoad(context.Background(), key, func(ctx context.Context) (V, time.Duration, error) {
+			value, ttl, err := loader(ctx)
+			if err != nil {
+				d.delayRefresh(key, stamp)
+			}
+			return value, ttl, err
+		}, LoadOptions{}, func(value V, ttl time.Duration) (V, error) {
+			return d.replace(key, stamp, value, ttl)
+		})
+		_ = l.calls.Set(key, c)
+	}
+	l.mu.Unlock()
+}
+
+// delayRefresh 刷新失败后将软过期时间推迟剩余有效期的一半，至少minRefreshBackoff，
+// 避免之后每次读取都重新发起刷新，只修改内存中的软过期时间，不改变写入戳
+func (d *TypedDB[K, V]) delayRefresh(key K, stamp uint64) {
+	if !d.life.enter() {
+		return
+	}
+	defer d.life.leave()
+	d.mu.Lock()
+	stored, cur, err := d.data.Lookup(key)
+	if err != nil || cur.stamp != stamp || cur.staleAt == 0 {
+		d.unlock()
+		return
+	}
+	now := d.now()
+	delay := (cur.deadline() - now) / 2
+	if floor := millis(minRefreshBackoff); delay < floor {
+		delay = floor
+	}
+	cur.staleAt = now + delay
+	_ = d.data.Set(stored, cur)
+	d.unlock()
+}
+
+// refreshLoader 将注册的刷新方法转换为key的loader
+func (d *TypedDB[K, V]) refreshLoader(key K) func(ctx context.Context) (V, time.Duration, error) {
+	return func(ctx context.Context) (value V, ttl time.Duration, err error) {
+		v, ttl, err := d.conf.refresh(ctx, key)
+		if err != nil || v == nil {
+			return value, ttl, err
+		}
+		value, ok := v.(V)
+		if !ok {
+			return value, 0, errors.ErrInvalidValue
+		}
+		return value, ttl, nil
+	}
+}
+
+// replace 保存刷新的结果，未指定软过期比例时保持与原数据相同的宽限期，
+// 刷新期间key被修改或删除过时不保存，以当前的数据为准
+func (d *TypedDB[K, V]) replace(key K, stamp uint64, value V, ttl time.Duration) (V, error) {
+	if !d.life.enter() {
+		return value, errors.ErrClosed
+	}
+	defer d.life.leave()
+	d.mu.Lock()
+	stored, cur, err := d.data.Lookup(key)
+	if err != nil || cur.stamp != stamp {
+		d.unlock()
+		if err == nil {
+			return cur.value, nil
+		}
+		return value, nil
+	}
+	now := d.now()
+	it := item[V]{value: value}
+	if ttl > 0 && d.withExpired() {
+		it.expireAt = now + millis(ttl)
+		it.staleAt = d.staleAt(now, it.expireAt, 0)
+		if it.staleAt == 0 && cur.staleAt != 0 && cur.deadline() != 0 {
+			it.staleAt = it.expireAt - (cur.deadline() - cur.staleAt)
+			if it.staleAt < now {
+				it.staleAt = now
+			}
+		}
+	}
+	if err = d.prepare(stored, value); err != nil {
+		d.unlock()
+		return value, err
+	}
+	if d.log != nil {
+		rec, err := d.saveRecord(stored, value, it.expireAt)
+		if err != nil {
+			d.unlock()
+			return value, err
+		}
+		if err = d.log.Append(rec); err != nil {
+			d.unlock()
+			return value, err
+		}
+	}
+	err = d.set(stored, it)
+	d.unlock()
+	if err == nil {
+		d.maybeCompact()
+	}
+	return value, err
+}
@@ -0,0 +1,145 @@
+package simpledb
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/clocktest"
+)
+
+// waitLoads 等待后台的刷新和加载结束
+func waitLoads[K, V any](t *testing.T, db *TypedDB[K, V]) {
+	for i := 0; i < 1000; i++ {
+		db.loads.mu.Lock()
+		n := db.loads.calls.Len()
+		db.loads.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("wait loads timeout")
+}
+
+func TestDB_SoftTTL(t *testing.T) {
+	var calls int32
+	clock := clocktest.NewFake(time.Now())
+	refresh := func(ctx context.Context, key interface{}) (interface{}, time.Duration, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return int(n) + 1, time.Minute, nil
+	}
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock), DBOptionRefresh(refresh))
+	_ = db.Save("1", 1, SaveOptionTTLDuration(10*time.Second), SaveOptionSoftTTL(5*time.Second))
+	clock.Advance(4 * time.Second)
+	_, _ = db.Get("1")
+	waitLoads(t, db.typed)
+	if calls != 0 {
+		t.Errorf("soft ttl failed, calls(%d) should be 0", calls)
+		return
+	}
+	clock.Advance(2 * time.Second)
+	// 软过期后仍返回旧值，后台刷新
+	if v, _ := db.Get("1"); v != 1 {
+		t.Errorf("soft ttl failed, v(%+v) should be 1", v)
+		return
+	}
+	waitLoads(t, db.typed)
+	if v, _ := db.Get("1"); v != 2 || calls != 1 {
+		t.Errorf("soft ttl failed, v(%+v) should be 2, calls: %d", v, calls)
+		return
+	}
+	// 刷新后保持5秒的宽限期
+	if ttl, _ := db.TTL("1"); ttl != time.Minute {
+		t.Errorf("soft ttl failed, ttl(%s) should be 1m", ttl)
+		return
+	}
+	it, _ := db.typed.data.Get("1")
+	if it.expireAt-it.staleAt != 5000 {
+		t.Errorf("soft ttl failed, grace(%d) should be 5000", it.expireAt-it.staleAt)
+		return
+	}
+	// 没有软过期时间的数据不刷新
+	_ = db.Save("2", 2, SaveOptionTTLDuration(time.Second))
+	clock.Advance(500 * time.Millisecond)
+	_, _ = db.Get("2")
+	waitLoads(t, db.typed)
+	if calls != 1 {
+		t.Errorf("soft ttl failed, calls(%d) should be 1", calls)
+	}
+}
+
+func TestDB_RefreshAhead(t *testing.T) {
+	var calls int32
+	clock := clocktest.NewFake(time.Now())
+	refresh := func(ctx context.Context, key interface{}) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return "new", 10 * time.Second, nil
+	}
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock), DBOptionRefresh(refresh), DBOptionRefreshAhead(0.8))
+	_ = db.Save("1", "old", SaveOptionTTLDuration(10*time.Second))
+	clock.Advance(7 * time.Second)
+	_, _ = db.Get("1")
+	waitLoads(t, db.typed)
+	if calls != 0 {
+		t.Errorf("refresh ahead failed, calls(%d) should be 0", calls)
+		return
+	}
+	clock.Advance(time.Second)
+	_, _ = db.Get("1")
+	waitLoads(t, db.typed)
+	if v, _ := db.Get("1"); v != "new" || calls != 1 {
+		t.Errorf("refresh ahead failed, v(%+v) should be new, calls: %d", v, calls)
+		return
+	}
+	if ttl, _ := db.TTL("1"); ttl != 10*time.Second {
+		t.Errorf("refresh ahead failed, ttl(%s) should be 10s", ttl)
+		return
+	}
+	// 刷新期间key被修改过时以修改的值为准
+	clock.Advance(8 * time.Second)
+	_, _ = db.Get("1")
+	_ = db.Save("1", "saved")
+	waitLoads(t, db.typed)
+	if v, _ := db.Get("1"); v != "saved" {
+		t.Errorf("refresh ahead failed, v(%+v) should be saved", v)
+	}
+}
+
+func TestDB_RefreshBackoff(t *testing.T) {
+	var calls int32
+	clock := clocktest.NewFake(time.Now())
+	refresh := func(ctx context.Context, key interface{}) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, 0, context.DeadlineExceeded
+	}
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock), DBOptionRefresh(refresh))
+	defer db.Close()
+	_ = db.Save("1", 1, SaveOptionTTLDuration(10*time.Second), SaveOptionSoftTTL(5*time.Second))
+	clock.Advance(6 * time.Second)
+	_, _ = db.Get("1")
+	waitLoads(t, db.typed)
+	// 失败后推迟剩余4秒的一半，期间的读取不再发起刷新
+	for i := 0; i < 3; i++ {
+		_, _ = db.Get("1")
+		waitLoads(t, db.typed)
+	}
+	clock.Advance(time.Second)
+	_, _ = db.Get("1")
+	waitLoads(t, db.typed)
+	if calls != 1 {
+		t.Errorf("refresh backoff failed, calls(%d) should be 1", calls)
+		return
+	}
+	clock.Advance(1500 * time.Millisecond)
+	_, _ = db.Get("1")
+	waitLoads(t, db.typed)
+	if calls != 2 {
+		t.Errorf("refresh backoff failed, calls(%d) should be 2", calls)
+		return
+	}
+	if v, _ := db.Get("1"); v != 1 {
+		t.Errorf("refresh backoff failed, v(%+v) should be 1", v)
+	}
+}
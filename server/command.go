@@ -0,0 +1,488 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb"
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+const (
+	// serverVersion HELLO返回的版本号
+	serverVersion = "1.0.0"
+	// defaultScanCount SCAN未指定COUNT时每次最多检查的key
+	defaultScanCount = 10
+)
+
+var (
+	errSyntax     = replyError("ERR syntax error")
+	errNotInteger = replyError("ERR value is not an integer or out of range")
+)
+
+// command 命令的处理函数，arity与redis相同，包括命令名，为负数时表示最少的参数个数
+type command struct {
+	arity int
+	fn    func(c *conn, args [][]byte) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":    {-1, cmdPing},
+		"echo":    {2, cmdEcho},
+		"hello":   {-1, cmdHello},
+		"quit":    {1, cmdQuit},
+		"command": {-1, cmdCommand},
+		"get":     {2, cmdGet},
+		"set":     {-3, cmdSet},
+		"del":     {-2, cmdDel},
+		"exists":  {-2, cmdExists},
+		"ttl":     {2, cmdTTL},
+		"pttl":    {2, cmdTTL},
+		"expire":  {3, cmdExpire},
+		"pexpire": {3, cmdExpire},
+		"persist": {2, cmdPersist},
+		"scan":    {-2, cmdScan},
+		"dbsize":  {1, cmdDBSize},
+	}
+}
+
+func cmdPing(c *conn, args [][]byte) error {
+	switch len(args) {
+	case 0:
+		c.w.writeSimple("PONG")
+	case 1:
+		c.w.writeBulk(args[0])
+	default:
+		return replyError("ERR wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func cmdEcho(c *conn, args [][]byte) error {
+	c.w.writeBulk(args[0])
+	return nil
+}
+
+// cmdHello 切换协议版本并返回服务的信息，不支持AUTH和SETNAME
+func cmdHello(c *conn, args [][]byte) error {
+	proto := c.w.proto
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return replyError("ERR Protocol version is not an integer or out of range")
+		}
+		if v != 2 && v != 3 {
+			return replyError("NOPROTO unsupported protocol version")
+		}
+		if len(args) > 1 {
+			return errSyntax
+		}
+		proto = v
+	}
+	c.w.proto = proto
+	c.w.writeMap(6)
+	c.w.writeBulkString("server")
+	c.w.writeBulkString("simpledb")
+	c.w.writeBulkString("version")
+	c.w.writeBulkString(serverVersion)
+	c.w.writeBulkString("proto")
+	c.w.writeInt(int64(proto))
+	c.w.writeBulkString("id")
+	c.w.writeInt(c.id)
+	c.w.writeBulkString("mode")
+	c.w.writeBulkString("standalone")
+	c.w.writeBulkString("role")
+	c.w.writeBulkString("master")
+	return nil
+}
+
+func cmdQuit(c *conn, args [][]byte) error {
+	c.w.writeSimple("OK")
+	return nil
+}
+
+// cmdCommand 客户端连接时会查询命令的信息，返回空数组
+func cmdCommand(c *conn, args [][]byte) error {
+	c.w.writeArray(0)
+	return nil
+}
+
+func cmdGet(c *conn, args [][]byte) error {
+	v, err := c.srv.db.Get(string(args[0]))
+	if err == errors.ErrNotFound {
+		c.w.writeNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.writeBulkString(valueString(v))
+	return nil
+}
+
+// cmdSet SET key value [NX|XX] [EX seconds|PX milliseconds]，未指定过期时间时去掉原有的过期时间
+func cmdSet(c *conn, args [][]byte) error {
+	var (
+		key, value = string(args[0]), string(args[1])
+		nx, xx     bool
+		ttl        time.Duration
+	)
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if ttl != 0 || i+1 >= len(args) {
+				return errSyntax
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			if n <= 0 {
+				return replyError("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Millisecond
+			if opt == "EX" {
+				unit = time.Second
+			}
+			var ok bool
+			if ttl, ok = expireDuration(n, unit); !ok {
+				return replyError("ERR invalid expire time in 'set' command")
+			}
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+	var opts []simpledb.SaveOption
+	if ttl > 0 {
+		opts = append(opts, simpledb.SaveOptionTTLDuration(ttl))
+	}
+	var (
+		ok  = true
+		err error
+	)
+	switch {
+	case nx:
+		ok, err = c.srv.db.SaveIfAbsent(key, value, opts...)
+	case xx:
+		ok, err = saveIfPresent(c.srv.db, key, value, opts)
+	default:
+		err = c.srv.db.Save(key, value, opts...)
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		c.w.writeNull()
+		return nil
+	}
+	c.w.writeSimple("OK")
+	return nil
+}
+
+// saveIfPresent 在事务中覆盖已存在的key，与DB.SaveIfPresent不同，未指定过期时间时不沿用原有的过期时间
+func saveIfPresent(db *simpledb.DB, key, value string, opts []simpledb.SaveOption) (bool, error) {
+	for {
+		var ok bool
+		err := db.Update(func(tx *simpledb.Tx) error {
+			if _, err := tx.Get(key); err != nil {
+				if err == errors.ErrNotFound {
+					return nil
+				}
+				return err
+			}
+			ok = true
+			return tx.Save(key, value, opts...)
+		})
+		if err != errors.ErrConflict {
+			return ok, err
+		}
+	}
+}
+
+func cmdDel(c *conn, args [][]byte) error {
+	var n int64
+	for _, arg := range args {
+		err := c.srv.db.Delete(string(arg))
+		if err == errors.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	c.w.writeInt(n)
+	return nil
+}
+
+// cmdExists 返回存在的key的个数，重复的key重复计数
+func cmdExists(c *conn, args [][]byte) error {
+	var n int64
+	for _, arg := range args {
+		_, err := c.srv.db.Get(string(arg))
+		if err == errors.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	c.w.writeInt(n)
+	return nil
+}
+
+// cmdTTL TTL和PTTL，key不存在时返回-2，不会过期时返回-1
+func cmdTTL(c *conn, args [][]byte) error {
+	ttl, err := c.srv.db.TTL(string(args[0]))
+	switch {
+	case err == errors.ErrNotFound:
+		c.w.writeInt(-2)
+	case err != nil:
+		return err
+	case ttl == simpledb.NoExpiration:
+		c.w.writeInt(-1)
+	case c.name == "pttl":
+		c.w.writeInt(ttl.Milliseconds())
+	default:
+		c.w.writeInt((ttl.Milliseconds() + 500) / 1000)
+	}
+	return nil
+}
+
+// cmdExpire EXPIRE和PEXPIRE，设置成功返回1，key不存在时返回0，过期时间不大于0时key立即被删除
+func cmdExpire(c *conn, args [][]byte) error {
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	unit := time.Millisecond
+	if c.name == "expire" {
+		unit = time.Second
+	}
+	ttl, ok := expireDuration(n, unit)
+	if !ok {
+		return replyError("ERR invalid expire time in '" + c.name + "' command")
+	}
+	err = c.srv.db.Expire(string(args[0]), ttl)
+	if err == errors.ErrNotFound {
+		c.w.writeInt(0)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.writeInt(1)
+	return nil
+}
+
+// expireDuration n个unit的时长，超出time.Duration的范围时返回false
+func expireDuration(n int64, unit time.Duration) (time.Duration, bool) {
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// cmdPersist 去掉过期时间返回1，key不存在或没有过期时间时返回0
+func cmdPersist(c *conn, args [][]byte) error {
+	removed, err := c.srv.db.Persist(string(args[0]))
+	if err == errors.ErrNotFound {
+		c.w.writeInt(0)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if removed {
+		c.w.writeInt(1)
+	} else {
+		c.w.writeInt(0)
+	}
+	return nil
+}
+
+// cmdScan SCAN cursor [MATCH pattern] [COUNT count]，游标为key的排名，按key的顺序从游标的位置继续，
+// COUNT为每次检查的key的个数，MATCH只过滤返回的key，返回的游标为0时遍历结束，
+// 遍历期间删除游标之前的key会使之后的key前移而被跳过
+func cmdScan(c *conn, args [][]byte) error {
+	offset, err := strconv.Atoi(string(args[0]))
+	if err != nil || offset < 0 {
+		return replyError("ERR invalid cursor")
+	}
+	var (
+		pattern string
+		count   = defaultScanCount
+	)
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return errSyntax
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return errNotInteger
+			}
+			if count < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+		i++
+	}
+	// 游标为下一次开始的排名，key少于count+1时说明已经到结尾，返回0
+	kvs, err := c.srv.db.RangeByRank(offset, scanEnd(offset, count))
+	if err != nil {
+		return err
+	}
+	next := "0"
+	if len(kvs) > count {
+		kvs = kvs[:count]
+		next = strconv.Itoa(offset + count)
+	}
+	var keys []string
+	for _, kv := range kvs {
+		if key := keyString(kv.Key); pattern == "" || matchGlob(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	c.w.writeArray(2)
+	c.w.writeBulkString(next)
+	c.w.writeArray(len(keys))
+	for _, key := range keys {
+		c.w.writeBulkString(key)
+	}
+	return nil
+}
+
+func cmdDBSize(c *conn, args [][]byte) error {
+	n, err := c.srv.db.Count()
+	if err != nil {
+		return err
+	}
+	c.w.writeInt(int64(n))
+	return nil
+}
+
+// scanEnd 多取一个key用于判断是否已经到结尾，避免溢出
+func scanEnd(offset, count int) int {
+	if count >= math.MaxInt-offset {
+		return math.MaxInt
+	}
+	return offset + count + 1
+}
+
+func keyString(key interface{}) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case simpledb.CustomKey:
+		return k.Key()
+	}
+	return fmt.Sprint(key)
+}
+
+// valueString 通过其他方式写入的非string的值按fmt的默认格式返回
+func valueString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// matchGlob 与redis的模式匹配相同，支持*、?、[abc]、[^a]、[a-z]以及\转义
+func matchGlob(pattern, s string) bool {
+	// star为最后一个*之后的模式位置，next为*已匹配到的s的位置，
+	// 不匹配时只回退到最后一个*多吃一个字符，避免多个*时指数级的回溯
+	var (
+		p, i       int
+		star, next = -1, 0
+	)
+	for p < len(pattern) || i < len(s) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				p++
+				star, next = p, i
+				continue
+			case '?':
+				if i < len(s) {
+					p, i = p+1, i+1
+					continue
+				}
+			case '[':
+				if i < len(s) {
+					if rest, ok := matchClass(pattern[p+1:], s[i]); ok {
+						p, i = len(pattern)-len(rest), i+1
+						continue
+					}
+				}
+			default:
+				width := 1
+				if c == '\\' && p+1 < len(pattern) {
+					c, width = pattern[p+1], 2
+				}
+				if i < len(s) && s[i] == c {
+					p, i = p+width, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 || next >= len(s) {
+			return false
+		}
+		next++
+		p, i = star, next
+	}
+	return true
+}
+
+// matchClass c是否匹配[]中的字符，pattern从[之后开始，返回]之后的部分，没有]时与redis相同，视为到模式的末尾
+func matchClass(pattern string, c byte) (string, bool) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			pattern = pattern[1:]
+			matched = matched || pattern[0] == c
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			pattern = pattern[2:]
+		default:
+			matched = matched || pattern[0] == c
+		}
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return pattern, matched != not
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb"
+	"github.com/byronzhu-haha/simpledb/clocktest"
+)
+
+func TestCommand_SetGet(t *testing.T) {
+	srv, db, addr := startServer(t)
+	defer db.Close()
+	defer srv.Close()
+	c := dial(t, "tcp", addr)
+	defer c.close()
+	cases := []struct {
+		args []string
+		exp  interface{}
+	}{
+		{[]string{"GET", "a"}, nil},
+		{[]string{"SET", "a", "1", "XX"}, nil},
+		{[]string{"SET", "a", "1", "NX"}, "OK"},
+		{[]string{"SET", "a", "2", "NX"}, nil},
+		{[]string{"GET", "a"}, "1"},
+		{[]string{"SET", "a", "3", "xx"}, "OK"},
+		{[]string{"GET", "a"}, "3"},
+		{[]string{"SET", "a", "4", "NX", "XX"}, "-ERR syntax error"},
+		{[]string{"SET", "a", "4", "EX", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"SET", "a", "4", "PX", "0"}, "-ERR invalid expire time in 'set' command"},
+		{[]string{"SET", "b", "1"}, "OK"},
+		{[]string{"EXISTS", "a", "b", "c", "a"}, int64(3)},
+		{[]string{"DEL", "a", "c", "b"}, int64(2)},
+		{[]string{"EXISTS", "a"}, int64(0)},
+		{[]string{"PING", "hi"}, "hi"},
+		{[]string{"ECHO", "hello"}, "hello"},
+	}
+	for _, cs := range cases {
+		if reply := c.do(cs.args...); reply != cs.exp {
+			t.Errorf("%v failed, reply(%v) should be %v", cs.args, reply, cs.exp)
+			return
+		}
+	}
+	// 非string的值按fmt的默认格式返回
+	_ = db.Save("n", 42)
+	if reply := c.do("GET", "n"); reply != "42" {
+		t.Errorf("get failed, reply(%v) should be 42", reply)
+		return
+	}
+}
+
+func TestCommand_TTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	srv, db, addr := startServer(t, simpledb.DBOptionWithExpired(), simpledb.DBOptionClock(clock))
+	defer db.Close()
+	defer srv.Close()
+	c := dial(t, "tcp", addr)
+	defer c.close()
+	cases := []struct {
+		args []string
+		exp  interface{}
+	}{
+		{[]string{"SET", "a", "1", "EX", "10"}, "OK"},
+		{[]string{"TTL", "a"}, int64(10)},
+		{[]string{"PTTL", "a"}, int64(10000)},
+		{[]string{"SET", "b", "1"}, "OK"},
+		{[]string{"TTL", "b"}, int64(-1)},
+		{[]string{"TTL", "c"}, int64(-2)},
+		{[]string{"PERSIST", "b"}, int64(0)},
+		{[]string{"PEXPIRE", "b", "1500"}, int64(1)},
+		{[]string{"PEXPIRE", "c", "1500"}, int64(0)},
+		{[]string{"EXPIRE", "b", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"EXPIRE", "b", "9223372036854775807"}, "-ERR invalid expire time in 'expire' command"},
+		{[]string{"PEXPIRE", "b", "-9223372036854775807"}, "-ERR invalid expire time in 'pexpire' command"},
+		{[]string{"SET", "b", "1", "EX", "9223372036854775"}, "-ERR invalid expire time in 'set' command"},
+		{[]string{"TTL", "b"}, int64(2)},
+		{[]string{"PERSIST", "a"}, int64(1)},
+		{[]string{"PERSIST", "a"}, int64(0)},
+		{[]string{"PERSIST", "c"}, int64(0)},
+		{[]string{"TTL", "a"}, int64(-1)},
+		// 未指定过期时间时覆盖会去掉原有的过期时间
+		{[]string{"SET", "a", "2", "PX", "500"}, "OK"},
+		{[]string{"SET", "a", "3", "XX"}, "OK"},
+		{[]string{"TTL", "a"}, int64(-1)},
+	}
+	for _, cs := range cases {
+		if reply := c.do(cs.args...); reply != cs.exp {
+			t.Errorf("%v failed, reply(%v) should be %v", cs.args, reply, cs.exp)
+			return
+		}
+	}
+	clock.Advance(2 * time.Second)
+	if reply := c.do("GET", "b"); reply != nil {
+		t.Errorf("get failed, reply(%v) should be nil after expired", reply)
+		return
+	}
+	if reply := c.do("DBSIZE"); reply != int64(1) {
+		t.Errorf("dbsize failed, reply(%v) should be 1", reply)
+		return
+	}
+}
+
+func TestCommand_Scan(t *testing.T) {
+	srv, db, addr := startServer(t)
+	defer db.Close()
+	defer srv.Close()
+	c := dial(t, "tcp", addr)
+	defer c.close()
+	for i := 0; i < 25; i++ {
+		_ = db.Save(fmt.Sprintf("user:%02d", i), "v")
+		_ = db.Save(fmt.Sprintf("group:%02d", i), "v")
+	}
+	var (
+		cursor = "0"
+		keys   []string
+		rounds int
+	)
+	for {
+		reply, ok := c.do("SCAN", cursor, "MATCH", "user:1?", "COUNT", "7").([]interface{})
+		if !ok || len(reply) != 2 {
+			t.Errorf("scan failed, reply(%v) should be [cursor keys]", reply)
+			return
+		}
+		for _, key := range reply[1].([]interface{}) {
+			keys = append(keys, key.(string))
+		}
+		rounds++
+		// 遍历期间的删除不影响之后的结果
+		if rounds == 2 {
+			_ = db.Delete("group:20")
+		}
+		if cursor = reply[0].(string); cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(keys)
+	var exp []string
+	for i := 10; i < 20; i++ {
+		exp = append(exp, fmt.Sprintf("user:%02d", i))
+	}
+	if !reflect.DeepEqual(keys, exp) {
+		t.Errorf("scan failed, keys(%v) should be %v", keys, exp)
+		return
+	}
+	// 最后一页正好取完时直接返回0，不会多一次空的遍历
+	if rounds != 7 {
+		t.Errorf("scan failed, rounds(%d) should be 7", rounds)
+		return
+	}
+	// 游标为排名，换一个服务也可以继续遍历
+	reply := c.do("SCAN", "0", "COUNT", "30").([]interface{})
+	srv2 := New(db)
+	defer srv2.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("listen failed, err: %+v", err)
+		return
+	}
+	go func() {
+		_ = srv2.Serve(l)
+	}()
+	c2 := dial(t, "tcp", l.Addr().String())
+	defer c2.close()
+	reply = c2.do("SCAN", reply[0].(string), "COUNT", "30").([]interface{})
+	if reply[0] != "0" || len(reply[1].([]interface{})) != 19 {
+		t.Errorf("scan failed, reply(%v) should have the last 19 keys", reply)
+		return
+	}
+	for _, cursor := range []string{"-1", "!!", "a=b"} {
+		if reply := c.do("SCAN", cursor); reply != "-ERR invalid cursor" {
+			t.Errorf("scan failed, reply(%v) of cursor %q should be invalid cursor", reply, cursor)
+			return
+		}
+	}
+}
+
+func TestCommand_Hello(t *testing.T) {
+	srv, db, addr := startServer(t)
+	defer db.Close()
+	defer srv.Close()
+	c := dial(t, "tcp", addr)
+	defer c.close()
+	if reply := c.do("HELLO", "4"); reply != "-NOPROTO unsupported protocol version" {
+		t.Errorf("hello failed, reply(%v) should be NOPROTO", reply)
+		return
+	}
+	reply, ok := c.do("HELLO", "3").([]interface{})
+	if !ok || len(reply) != 12 || reply[4] != "proto" || reply[5] != int64(3) {
+		t.Errorf("hello failed, reply(%v) should be a map with proto 3", reply)
+		return
+	}
+	// RESP3的null
+	_ = c.send("GET", "a")
+	if line, _ := c.r.ReadString('\n'); line != "_\r\n" {
+		t.Errorf("get failed, reply(%q) should be RESP3 null", line)
+		return
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		exp        bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "group:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+		{"h[ab", "ha", true},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"a*b*c", "abxbxc", true},
+		{"a*b*c", "abxbx", false},
+		{"\\", "\\", true},
+		// 多个*时不会指数级回溯
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100), false},
+	}
+	for _, cs := range cases {
+		if got := matchGlob(cs.pattern, cs.s); got != cs.exp {
+			t.Errorf("match failed, match(%q, %q) should be %v", cs.pattern, cs.s, cs.exp)
+			return
+		}
+	}
+}
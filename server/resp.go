@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxArgs、maxBulkLen 单条命令的参数个数和单个参数的长度上限，与redis相同
+	maxArgs    = 1024 * 1024
+	maxBulkLen = 512 * 1024 * 1024
+	// maxInlineLen inline命令一行的长度上限
+	maxInlineLen = 64 * 1024
+	// maxPrealloc、bulkChunk 按客户端声明的长度预先分配的上限，超出的部分随数据到达增长，
+	// 避免只发送长度的请求占用大量内存
+	maxPrealloc = 1024
+	bulkChunk   = 64 * 1024
+)
+
+// protocolError 客户端发送的数据不符合RESP协议，回复错误后关闭连接
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// reader 读取客户端的命令，支持RESP数组和inline两种格式
+type reader struct {
+	r *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// buffered 已读入但还未解析的字节数，为0时说明流水线中的命令都已处理
+func (r *reader) buffered() int {
+	return r.r.Buffered()
+}
+
+// readCommand 读取一条命令，空行返回长度为0的命令
+func (r *reader) readCommand() ([][]byte, error) {
+	b, err := r.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		return r.readInline()
+	}
+	n, err := r.readLength('*', maxArgs)
+	if err != nil {
+		return nil, err
+	}
+	prealloc := n
+	if prealloc > maxPrealloc {
+		prealloc = maxPrealloc
+	}
+	args := make([][]byte, 0, prealloc)
+	for i := 0; i < n; i++ {
+		size, err := r.readLength('$', maxBulkLen)
+		if err != nil {
+			return nil, err
+		}
+		arg, err := r.readBulk(size + 2)
+		if err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, protocolError("invalid bulk length")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readBulk 读取n个字节，较大时按块读取，内存随读到的数据增长
+func (r *reader) readBulk(n int) ([]byte, error) {
+	if n <= bulkChunk {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r.r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(bulkChunk)
+	if _, err := io.CopyN(&buf, r.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readLength 读取以prefix开头的长度行，如"*3\r\n"
+func (r *reader) readLength(prefix byte, max int) (int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != prefix {
+		return 0, protocolError("expected '" + string(prefix) + "', got '" + string(line) + "'")
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > max {
+		if prefix == '$' {
+			return 0, protocolError("invalid bulk length")
+		}
+		return 0, protocolError("invalid multibulk length")
+	}
+	return n, nil
+}
+
+// readInline 读取以空白分隔参数的一行，用于telnet等直接输入命令的场景
+func (r *reader) readInline() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Fields(line), nil
+}
+
+// readLine 读取一行并去掉行尾的\r\n，只有\n结尾时也接受
+func (r *reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineLen {
+			return nil, protocolError("too big inline request")
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// writer 按连接协商的协议版本写回复，写入缓冲区，需要调用flush发送
+type writer struct {
+	w *bufio.Writer
+	// proto 协议版本，2或3，由HELLO切换
+	proto int
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w), proto: 2}
+}
+
+func (w *writer) writeSimple(s string) {
+	w.writeLine('+', s)
+}
+
+// writeError 错误信息中的换行替换为空格
+func (w *writer) writeError(msg string) {
+	w.writeLine('-', strings.NewReplacer("\r", " ", "\n", " ").Replace(msg))
+}
+
+func (w *writer) writeInt(n int64) {
+	w.writeLine(':', strconv.FormatInt(n, 10))
+}
+
+func (w *writer) writeBulk(b []byte) {
+	w.writeLine('$', strconv.Itoa(len(b)))
+	_, _ = w.w.Write(b)
+	_, _ = w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeBulkString(s string) {
+	w.writeLine('$', strconv.Itoa(len(s)))
+	_, _ = w.w.WriteString(s)
+	_, _ = w.w.WriteString("\r\n")
+}
+
+// writeNull RESP3为"_"，RESP2为空的bulk string
+func (w *writer) writeNull() {
+	if w.proto == 3 {
+		_, _ = w.w.WriteString("_\r\n")
+		return
+	}
+	_, _ = w.w.WriteString("$-1\r\n")
+}
+
+func (w *writer) writeArray(n int) {
+	w.writeLine('*', strconv.Itoa(n))
+}
+
+// writeMap n对键值，RESP2中为长度为2n的数组
+func (w *writer) writeMap(n int) {
+	if w.proto == 3 {
+		w.writeLine('%', strconv.Itoa(n))
+		return
+	}
+	w.writeArray(2 * n)
+}
+
+func (w *writer) writeLine(prefix byte, s string) {
+	_ = w.w.WriteByte(prefix)
+	_, _ = w.w.WriteString(s)
+	_, _ = w.w.WriteString("\r\n")
+}
+
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
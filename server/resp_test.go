@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReader_ReadCommand(t *testing.T) {
+	r := newReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\na\r\nPING  hello\n\r\n*1\r\n$0\r\n\r\n"))
+	cases := []string{"GET,a", "PING,hello", "", ""}
+	for _, exp := range cases {
+		args, err := r.readCommand()
+		if err != nil {
+			t.Errorf("read command failed, err: %+v", err)
+			return
+		}
+		if got := string(bytes.Join(args, []byte(","))); got != exp {
+			t.Errorf("read command failed, args(%s) should be %s", got, exp)
+			return
+		}
+	}
+	for _, in := range []string{"*1\r\n+OK\r\n", "*-2\r\n", "*1\r\n$3\r\nabcd\r\n", "*x\r\n"} {
+		if _, err := newReader(strings.NewReader(in)).readCommand(); err == nil {
+			t.Errorf("read command failed, %q should be a protocol error", in)
+			return
+		} else if _, ok := err.(protocolError); !ok {
+			t.Errorf("read command failed, err(%+v) of %q should be a protocol error", err, in)
+			return
+		}
+	}
+}
+
+func TestReader_Prealloc(t *testing.T) {
+	// 只声明了很大的长度而没有数据时不应按声明的长度分配内存
+	for _, in := range []string{"*1048576\r\n$1\r\na\r\n", "*1\r\n$536870912\r\nabc"} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := newReader(strings.NewReader(in)).readCommand(); err == nil {
+			t.Errorf("read command failed, %q should be an error", in)
+			return
+		}
+		runtime.ReadMemStats(&after)
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+			t.Errorf("read command failed, %q allocated %d bytes", in, alloc)
+			return
+		}
+	}
+	// 较大的参数按块读取后内容不变
+	big := strings.Repeat("x", 3*bulkChunk+1)
+	args, err := newReader(strings.NewReader("*1\r\n$" + strconv.Itoa(len(big)) + "\r\n" + big + "\r\n")).readCommand()
+	if err != nil || len(args) != 1 || string(args[0]) != big {
+		t.Errorf("read command failed, big bulk should be read, err: %+v", err)
+	}
+}
+
+func TestWriter_Proto(t *testing.T) {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	w.writeNull()
+	w.writeMap(1)
+	w.writeError("ERR a\r\nb")
+	w.proto = 3
+	w.writeNull()
+	w.writeMap(1)
+	_ = w.flush()
+	if exp := "$-1\r\n*2\r\n-ERR a  b\r\n_\r\n%1\r\n"; buf.String() != exp {
+		t.Errorf("write failed, out(%q) should be %q", buf.String(), exp)
+		return
+	}
+}
@@ -0,0 +1,190 @@
+// Package server 通过RESP2/RESP3协议对外提供DB，兼容redis的客户端，
+// 每个连接一个协程，支持流水线，key和value都按string保存
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/byronzhu-haha/simpledb"
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// Server RESP服务，TTL相关的命令需要db开启过期时间
+type Server struct {
+	db *simpledb.DB
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	wg        sync.WaitGroup
+	lastID    int64
+}
+
+// New 创建使用db的服务，关闭服务不会关闭db
+func New(db *simpledb.DB) *Server {
+	return &Server{
+		db:        db,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe 监听network(tcp或unix)上的addr并提供服务，服务关闭后返回ErrServerClosed
+func (s *Server) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 接受l上的连接，每个连接一个协程，服务关闭后返回ErrServerClosed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return errors.ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			_ = l.Close()
+			if closed {
+				return errors.ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = nc.Close()
+			continue
+		}
+		s.lastID++
+		c := newConn(s, nc, s.lastID)
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Close 停止接受新的连接，关闭所有连接并等待正在执行的命令结束
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for c := range s.conns {
+		_ = c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) remove(c *conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// conn 一个客户端连接，命令按顺序执行，读完流水线中已到达的命令后才发送回复
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	id  int64
+	r   *reader
+	w   *writer
+	// name 正在执行的命令名，小写
+	name string
+}
+
+func newConn(s *Server, nc net.Conn, id int64) *conn {
+	return &conn{
+		srv: s,
+		nc:  nc,
+		id:  id,
+		r:   newReader(nc),
+		w:   newWriter(nc),
+	}
+}
+
+func (c *conn) serve() {
+	defer c.srv.remove(c)
+	defer c.nc.Close()
+	for {
+		args, err := c.r.readCommand()
+		if err != nil {
+			if pe, ok := err.(protocolError); ok {
+				c.w.writeError("ERR " + pe.Error())
+				_ = c.w.flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := c.exec(args)
+		if quit || c.r.buffered() == 0 {
+			if err = c.w.flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// exec 执行一条命令并写入回复，返回是否需要关闭连接
+func (c *conn) exec(args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	c.name = name
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.writeError("ERR unknown command '" + string(args[0]) + "'")
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		c.w.writeError("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+	if err := cmd.fn(c, args[1:]); err != nil {
+		c.writeErr(err)
+	}
+	return name == "quit"
+}
+
+// writeErr 将db返回的错误写为错误回复
+func (c *conn) writeErr(err error) {
+	if r, ok := err.(replyError); ok {
+		c.w.writeError(string(r))
+		return
+	}
+	c.w.writeError("ERR " + err.Error())
+}
+
+// replyError 直接回复给客户端的错误，以错误类型开头
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
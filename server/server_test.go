@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb"
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// client 测试用的客户端，按RESP发送命令并解析回复
+type client struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func dial(t *testing.T, network, addr string) *client {
+	nc, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("dial failed, err: %+v", err)
+	}
+	return &client{nc: nc, r: bufio.NewReader(nc)}
+}
+
+func (c *client) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.nc.Write([]byte(b.String()))
+	return err
+}
+
+// do 发送命令并读取回复，错误回复以"-"开头的string返回，null为nil，数组为[]interface{}
+func (c *client) do(args ...string) interface{} {
+	if err := c.send(args...); err != nil {
+		return err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return err
+	}
+	return reply
+}
+
+func (c *client) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return line, nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		ret := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("unknown reply %q", line)
+}
+
+func (c *client) close() {
+	_ = c.nc.Close()
+}
+
+// startServer 在回环地址上启动服务
+func startServer(t *testing.T, opts ...simpledb.DBOption) (*Server, *simpledb.DB, string) {
+	db := simpledb.NewDB(opts...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, err: %+v", err)
+	}
+	srv := New(db)
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	return srv, db, l.Addr().String()
+}
+
+func TestServer_Pipeline(t *testing.T) {
+	srv, db, addr := startServer(t)
+	defer db.Close()
+	defer srv.Close()
+	c := dial(t, "tcp", addr)
+	defer c.close()
+	// 一次写入多条命令，按顺序返回回复
+	const n = 100
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "*3\r\n$3\r\nSET\r\n$%d\r\nk%d\r\n$1\r\nv\r\n", len(strconv.Itoa(i))+1, i)
+		fmt.Fprintf(&b, "*2\r\n$3\r\nGET\r\n$%d\r\nk%d\r\n", len(strconv.Itoa(i))+1, i)
+	}
+	b.WriteString("PING\r\n")
+	if _, err := c.nc.Write([]byte(b.String())); err != nil {
+		t.Errorf("write failed, err: %+v", err)
+		return
+	}
+	for i := 0; i < n; i++ {
+		if reply, err := c.read(); err != nil || reply != "OK" {
+			t.Errorf("pipeline failed, reply(%v) of set should be OK, err: %+v", reply, err)
+			return
+		}
+		if reply, err := c.read(); err != nil || reply != "v" {
+			t.Errorf("pipeline failed, reply(%v) of get should be v, err: %+v", reply, err)
+			return
+		}
+	}
+	if reply, err := c.read(); err != nil || reply != "PONG" {
+		t.Errorf("pipeline failed, reply(%v) of inline ping should be PONG, err: %+v", reply, err)
+		return
+	}
+	if reply := c.do("DBSIZE"); reply != int64(n) {
+		t.Errorf("dbsize failed, reply(%v) should be %d", reply, n)
+		return
+	}
+}
+
+func TestServer_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simpledb-server")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %+v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	var (
+		db   = simpledb.NewDB()
+		srv  = New(db)
+		path = filepath.Join(dir, "simpledb.sock")
+		done = make(chan error, 1)
+	)
+	defer db.Close()
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Errorf("listen failed, err: %+v", err)
+		return
+	}
+	go func() {
+		done <- srv.Serve(l)
+	}()
+	c := dial(t, "unix", path)
+	if reply := c.do("SET", "a", "1"); reply != "OK" {
+		t.Errorf("set failed, reply(%v) should be OK", reply)
+		return
+	}
+	if v, _ := db.Get("a"); v != "1" {
+		t.Errorf("set failed, v(%v) should be 1", v)
+		return
+	}
+	// 关闭服务时断开所有连接
+	_ = srv.Close()
+	if err = <-done; err != errors.ErrServerClosed {
+		t.Errorf("serve failed, err(%+v) should be ErrServerClosed", err)
+		return
+	}
+	_ = c.nc.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c.read(); err == nil {
+		t.Errorf("close failed, connection should be closed")
+		return
+	}
+}
+
+func TestServer_ProtocolError(t *testing.T) {
+	srv, db, addr := startServer(t)
+	defer db.Close()
+	defer srv.Close()
+	c := dial(t, "tcp", addr)
+	defer c.close()
+	if reply := c.do("NOPE"); reply != "-ERR unknown command 'NOPE'" {
+		t.Errorf("exec failed, reply(%v) should be unknown command", reply)
+		return
+	}
+	if reply := c.do("GET"); reply != "-ERR wrong number of arguments for 'get' command" {
+		t.Errorf("exec failed, reply(%v) should be wrong number of arguments", reply)
+		return
+	}
+	_, _ = c.nc.Write([]byte("*1\r\n$x\r\n"))
+	if reply, _ := c.read(); reply != "-ERR Protocol error: invalid bulk length" {
+		t.Errorf("exec failed, reply(%v) should be protocol error", reply)
+		return
+	}
+	if _, err := c.read(); err == nil {
+		t.Errorf("exec failed, connection should be closed after protocol error")
+		return
+	}
+}
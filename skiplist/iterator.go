@@ -1,38 +1,124 @@
 package skiplist
 
-type Iterator interface {
+// Iterator 有序遍历跳表，创建后位于第一条数据之前，HasNext移动到下一条数据，
+// 也可以通过Seek系列方法定位后用Prev反向遍历，例如:
+//
+//	for iter.SeekToLast(); iter.Valid(); iter.Prev() {}
+type Iterator[K, V any] interface {
+	// HasNext 移动到下一条数据，返回是否有效
 	HasNext() bool
-	Key() interface{}
-	Value() interface{}
+	// Prev 移动到上一条数据，返回是否有效
+	Prev() bool
+	// Seek 定位到第一条不小于key的数据
+	Seek(key K)
+	SeekToFirst()
+	SeekToLast()
+	// Valid 当前是否指向一条数据
+	Valid() bool
+	Key() K
+	Value() V
 	Close()
 }
 
-type iterator struct {
-	key, value interface{}
-	currNode   *node
+// iterator 只能看到版本号不大于seq的数据，遍历时不加锁
+type iterator[K, V any] struct {
+	list     *skipList[K, V]
+	seq      uint64
+	key      K
+	value    V
+	currNode *node[K, V]
+	closed   bool
 }
 
-func (i *iterator) HasNext() bool {
-	if !i.currNode.hasNext() {
+func (i *iterator[K, V]) HasNext() bool {
+	if i.currNode == nil {
 		return false
 	}
-	i.currNode = i.currNode.next()
-	i.key = i.currNode.key
-	i.value = i.currNode.value
+	return i.forward(i.currNode.next())
+}
+
+// Prev 与跳表寻址的方式一样，从head开始查找上一个节点
+func (i *iterator[K, V]) Prev() bool {
+	if !i.Valid() {
+		return false
+	}
+	_, prev := i.list.addressing(i.currNode.key, nil)
+	for prev != nil && prev.visible(i.seq) == nil {
+		_, prev = i.list.addressing(prev.key, nil)
+	}
+	return i.moveTo(prev)
+}
+
+func (i *iterator[K, V]) Seek(key K) {
+	if isNil(key) {
+		i.moveTo(nil)
+		return
+	}
+	dest, _ := i.list.addressing(key, nil)
+	i.forward(dest)
+}
+
+func (i *iterator[K, V]) SeekToFirst() {
+	i.forward(i.list.head.next())
+}
+
+func (i *iterator[K, V]) SeekToLast() {
+	last := i.list.last()
+	if last == nil || last.visible(i.seq) != nil {
+		i.moveTo(last)
+		return
+	}
+	i.currNode = last
+	i.Prev()
+}
+
+func (i *iterator[K, V]) Valid() bool {
+	return i.currNode != nil && i.currNode != i.list.head
+}
+
+// forward 从n开始向后找到第一个可见的节点
+func (i *iterator[K, V]) forward(n *node[K, V]) bool {
+	for n != nil && n.visible(i.seq) == nil {
+		n = n.next()
+	}
+	return i.moveTo(n)
+}
 
+// moveTo 指向n，n为nil时迭代器失效
+func (i *iterator[K, V]) moveTo(n *node[K, V]) bool {
+	i.currNode = n
+	if n == nil {
+		i.reset()
+		return false
+	}
+	v := n.visible(i.seq)
+	i.key = v.key
+	i.value = v.value
 	return true
 }
 
-func (i *iterator) Key() interface{} {
+func (i *iterator[K, V]) Key() K {
 	return i.key
 }
 
-func (i *iterator) Value() interface{} {
+func (i *iterator[K, V]) Value() V {
 	return i.value
 }
 
-func (i *iterator) Close() {
-	i.key = nil
-	i.value = nil
+func (i *iterator[K, V]) Close() {
+	i.reset()
 	i.currNode = nil
+	if !i.closed {
+		i.closed = true
+		i.list.release(i.seq)
+	}
+}
+
+func (i *iterator[K, V]) reset() {
+	var (
+		key   K
+		value V
+	)
+	i.key = key
+	i.value = value
 }
@@ -1,18 +1,58 @@
 package skiplist
 
-type node struct {
-	forward    []*node
-	backward   *node
-	key, value interface{}
+import (
+	"sync/atomic"
+)
+
+// node 跳表的节点，key只用于排序，节点上的数据保存在按新到旧串联的版本中，
+// forward和versions在节点可见之后只通过原子操作修改，读取时无需加锁，
+// span[i]为该节点到forward[i](不存在时到末尾)之间未删除的节点数，包括forward[i]自身，需持有跳表的锁
+type node[K, V any] struct {
+	key      K
+	forward  []atomic.Pointer[node[K, V]]
+	span     []int
+	versions atomic.Pointer[version[K, V]]
+}
+
+// version 节点的一个版本，seq为写入时的版本号，deleted表示在该版本被删除
+type version[K, V any] struct {
+	seq     uint64
+	key     K
+	value   V
+	deleted bool
+	next    atomic.Pointer[version[K, V]]
 }
 
-func (n *node) next() *node {
+func newNode[K, V any](key K, level int) *node[K, V] {
+	return &node[K, V]{
+		key:     key,
+		forward: make([]atomic.Pointer[node[K, V]], level+1),
+		span:    make([]int, level+1),
+	}
+}
+
+func (n *node[K, V]) next() *node[K, V] {
 	if len(n.forward) == 0 {
 		return nil
 	}
-	return n.forward[0]
+	return n.forward[0].Load()
+}
+
+// latest 最新的版本
+func (n *node[K, V]) latest() *version[K, V] {
+	return n.versions.Load()
 }
 
-func (n *node) hasNext() bool {
-	return n.next() != nil
+// visible 版本号为seq时可见的版本，不存在或者已被删除时返回nil
+func (n *node[K, V]) visible(seq uint64) *version[K, V] {
+	for v := n.versions.Load(); v != nil; v = v.next.Load() {
+		if v.seq > seq {
+			continue
+		}
+		if v.deleted {
+			return nil
+		}
+		return v
+	}
+	return nil
 }
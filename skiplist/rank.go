@@ -0,0 +1,105 @@
+package skiplist
+
+import (
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// Ranker 支持按排名访问的有序表，排名从0开始，只计算未删除的数据，
+// 通过节点上的跨度寻址，都是O(log n)
+type Ranker[K, V any] interface {
+	// Rank key的排名，key不存在时返回ErrNotFound
+	Rank(key K) (int, error)
+	// ByRank 排名为rank的数据，超出范围时返回ErrNotFound
+	ByRank(rank int) (key K, value V, err error)
+	// RangeByRank 排名在[start, end)之间的数据，超出范围的部分被忽略
+	RangeByRank(start, end int) []Entry[K, V]
+}
+
+var _ Ranker[int, int] = (*skipList[int, int])(nil)
+
+// Entry 按排名返回的一条数据
+type Entry[K, V any] struct {
+	Key   K
+	Value V
+}
+
+func (s *skipList[K, V]) Rank(key K) (int, error) {
+	if isNil(key) {
+		return 0, errors.ErrNilKey
+	}
+	s.mu.RLock()
+	var (
+		current = s.head
+		r       int
+	)
+	for i := s.level(); i >= 0; i-- {
+		for next := current.forward[i].Load(); next != nil && s.cmp(next.key, key) <= 0; next = current.forward[i].Load() {
+			r += current.span[i]
+			current = next
+		}
+	}
+	found := current != s.head && s.cmp(current.key, key) == 0 && !current.latest().deleted
+	s.mu.RUnlock()
+	if !found {
+		return 0, errors.ErrNotFound
+	}
+	return r - 1, nil
+}
+
+func (s *skipList[K, V]) ByRank(rank int) (key K, value V, err error) {
+	s.mu.RLock()
+	n := s.seekRank(rank)
+	if n == nil {
+		s.mu.RUnlock()
+		return key, value, errors.ErrNotFound
+	}
+	v := n.latest()
+	s.mu.RUnlock()
+	return v.key, v.value, nil
+}
+
+func (s *skipList[K, V]) RangeByRank(start, end int) []Entry[K, V] {
+	if start < 0 {
+		start = 0
+	}
+	s.mu.RLock()
+	if end > s.len {
+		end = s.len
+	}
+	if start >= end {
+		s.mu.RUnlock()
+		return nil
+	}
+	ret := make([]Entry[K, V], 0, end-start)
+	for n := s.seekRank(start); n != nil && len(ret) < end-start; n = n.next() {
+		if v := n.latest(); !v.deleted {
+			ret = append(ret, Entry[K, V]{Key: v.key, Value: v.value})
+		}
+	}
+	s.mu.RUnlock()
+	return ret
+}
+
+// seekRank 排名为rank的未删除的节点，超出范围时返回nil，调用方需持有锁
+func (s *skipList[K, V]) seekRank(rank int) *node[K, V] {
+	if rank < 0 || rank >= s.len {
+		return nil
+	}
+	var (
+		current = s.head
+		target  = rank + 1
+		r       int
+	)
+	// 停在最后一个之前未删除的节点数小于target的节点，其后第一个未删除的节点即为所求
+	for i := s.level(); i >= 0; i-- {
+		for next := current.forward[i].Load(); next != nil && r+current.span[i] < target; next = current.forward[i].Load() {
+			r += current.span[i]
+			current = next
+		}
+	}
+	n := current.next()
+	for n != nil && n.latest().deleted {
+		n = n.next()
+	}
+	return n
+}
@@ -0,0 +1,82 @@
+package skiplist
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestSkipList_Rank(t *testing.T) {
+	list := NewSkipList[string, int](strings.Compare)
+	ranker := list.(Ranker[string, int])
+	model := make(map[string]int)
+	// 迭代器未关闭时被删除的节点不会摘除，排名需要跳过这些节点
+	iter := list.Iterator()
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("%03d", rand.Intn(300))
+		if rand.Intn(3) == 0 {
+			_ = list.Del(k)
+			delete(model, k)
+		} else {
+			_ = list.Set(k, i)
+			model[k] = i
+		}
+		if i == 1000 {
+			iter.Close()
+			iter = list.Iterator()
+		}
+	}
+	keys := make([]string, 0, len(model))
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	check := func() bool {
+		for i, k := range keys {
+			if r, err := ranker.Rank(k); err != nil || r != i {
+				t.Errorf("rank failed, rank(%d) of %s should be %d, err: %+v", r, k, i, err)
+				return false
+			}
+			if key, v, err := ranker.ByRank(i); err != nil || key != k || v != model[k] {
+				t.Errorf("by rank failed, key(%s) should be %s, err: %+v", key, k, err)
+				return false
+			}
+		}
+		return true
+	}
+	if !check() {
+		iter.Close()
+		return
+	}
+	iter.Close()
+	if !check() {
+		return
+	}
+	if _, _, err := ranker.ByRank(len(keys)); err != errors.ErrNotFound {
+		t.Errorf("by rank failed, err(%+v) should be ErrNotFound", err)
+		return
+	}
+	if _, err := ranker.Rank("xyz"); err != errors.ErrNotFound {
+		t.Errorf("rank failed, err(%+v) should be ErrNotFound", err)
+		return
+	}
+	entries := ranker.RangeByRank(10, 20)
+	if len(entries) != 10 {
+		t.Errorf("range by rank failed, len(%d) should be 10", len(entries))
+		return
+	}
+	for i, e := range entries {
+		if e.Key != keys[10+i] {
+			t.Errorf("range by rank failed, key(%s) should be %s", e.Key, keys[10+i])
+			return
+		}
+	}
+	if entries = ranker.RangeByRank(len(keys)-2, len(keys)+5); len(entries) != 2 {
+		t.Errorf("range by rank failed, len(%d) should be 2", len(entries))
+		return
+	}
+}
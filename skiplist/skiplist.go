@@ -4,6 +4,7 @@ import (
 	"github.com/byronzhu-haha/simpledb/errors"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,209 +13,358 @@ const (
 	probability = 0.5
 )
 
-type SkipList interface {
-	Set(key, value interface{}) error
-	Get(key interface{}) (value interface{}, err error)
-	Del(key interface{}) error
+// SkipList 有序表，key的顺序和相等性都由cmp决定，cmp返回0的key被视为同一个key
+type SkipList[K, V any] interface {
+	Set(key K, value V) error
+	Get(key K) (value V, err error)
+	// Lookup 查找与key相等的数据，返回实际存储的key和value
+	Lookup(key K) (storedKey K, value V, err error)
+	Del(key K) error
 	Len() int
-	Iterator() Iterator
+	// Iterator 创建迭代器，迭代器只能看到创建时的数据，不受之后写操作的影响，用完必须Close
+	Iterator() Iterator[K, V]
 }
 
-type skipList struct {
-	head     *node
-	len      int
-	maxLevel int
-	less     func(l, r interface{}) bool
-	mu       sync.RWMutex
+// skipList 多版本的跳表，写操作加锁并为数据生成新的版本，迭代器不加锁，只读取创建时已有的版本。
+// 被删除的节点在所有迭代器都能看到删除之后才从跳表中摘除，只有迭代器可能看到的旧版本才会被保留
+type skipList[K, V any] struct {
+	head *node[K, V]
+	// height 当前的最高层级，只通过原子操作读写
+	height int32
+	len    int
+	cmp    func(a, b K) int
+	mu     sync.RWMutex
+	// seq 最新的已提交的版本号，只通过原子操作读写
+	seq uint64
+	// snapshots 未关闭的迭代器的版本号及其数量
+	snapMu    sync.Mutex
+	snapshots map[uint64]int
+	// removed 已删除但还没有摘除的节点
+	removed []*node[K, V]
 }
 
-func NewSkipList(less func(l, r interface{}) bool) SkipList {
-	return &skipList{
-		head: &node{
-			forward: []*node{nil},
-		},
-		maxLevel: maxLevel,
-		less:     less,
+// NewSkipList 创建跳表，cmp与strings.Compare的约定相同
+func NewSkipList[K, V any](cmp func(a, b K) int) SkipList[K, V] {
+	var key K
+	return &skipList[K, V]{
+		head:      newNode[K, V](key, maxLevel),
+		cmp:       cmp,
+		snapshots: make(map[uint64]int),
 	}
 }
 
-// level 当前跳表的最高层级
-func (s *skipList) level() int {
-	return len(s.head.forward) - 1
+// isNil key为接口类型时不允许为nil
+func isNil[K any](key K) bool {
+	return any(key) == nil
 }
 
-// maxEffectiveLevel 跳表当前允许的最大有效层级
-func (s *skipList) maxEffectiveLevel() int {
-	if s.maxLevel > s.level() {
-		return s.maxLevel
-	}
-	return s.level()
+func (s *skipList[K, V]) less(l, r K) bool {
+	return s.cmp(l, r) < 0
+}
+
+// level 当前跳表的最高层级
+func (s *skipList[K, V]) level() int {
+	return int(atomic.LoadInt32(&s.height))
 }
 
 // getNewLevel 获取一个新的层级(随机选择)
-func (s *skipList) getNewLevel() int {
+func (s *skipList[K, V]) getNewLevel() int {
 	rand.Seed(time.Now().UnixNano())
 	level := 0
-	for level < s.maxEffectiveLevel() && rand.Float64() < probability {
+	for level < maxLevel && rand.Float64() < probability {
 		level++
 	}
 	return level
 }
 
-// addressing 从source节点开始寻址，找到符合key的节点
-func (s *skipList) addressing(key interface{}, source *node, beUpdatedNodes []*node) (*node, error) {
-	var (
-		height  = len(source.forward) - 1
-		current = source
-	)
-	if beUpdatedNodes != nil && len(beUpdatedNodes) < height {
-		return nil, errors.ErrUpdateSliceIdxOutRange
-	}
-	for i := height; i >= 0; i-- {
-		for current.forward[i] != nil && s.less(current.forward[i].key, key) {
-			current = current.forward[i]
+// addressing 从head开始寻址，返回第一个不小于key的节点以及最后一个小于key的节点，
+// beUpdatedNodes不为nil时记录每一层的前驱节点，只读取原子变量，可以在不加锁时调用
+func (s *skipList[K, V]) addressing(key K, beUpdatedNodes []*node[K, V]) (dest, prev *node[K, V]) {
+	current := s.head
+	for i := s.level(); i >= 0; i-- {
+		for next := current.forward[i].Load(); next != nil && s.less(next.key, key); next = current.forward[i].Load() {
+			current = next
 		}
 		if beUpdatedNodes != nil {
 			beUpdatedNodes[i] = current
 		}
 	}
-	return current.next(), nil
+	if current != s.head {
+		prev = current
+	}
+	return current.next(), prev
+}
+
+// locate 同addressing，同时在rank中记录每一层的前驱之前(包括前驱)未删除的节点数，调用方需持有锁
+func (s *skipList[K, V]) locate(key K, update []*node[K, V], rank []int) *node[K, V] {
+	var (
+		current = s.head
+		r       int
+	)
+	for i := s.level(); i >= 0; i-- {
+		for next := current.forward[i].Load(); next != nil && s.less(next.key, key); next = current.forward[i].Load() {
+			r += current.span[i]
+			current = next
+		}
+		update[i] = current
+		rank[i] = r
+	}
+	return current.next()
+}
+
+// last 最后一个节点，跳表为空时返回nil
+func (s *skipList[K, V]) last() *node[K, V] {
+	current := s.head
+	for i := s.level(); i >= 0; i-- {
+		for next := current.forward[i].Load(); next != nil; next = current.forward[i].Load() {
+			current = next
+		}
+	}
+	if current == s.head {
+		return nil
+	}
+	return current
 }
 
-// Set 设置新值，根据less函数，保证每次插入都是有序的
-func (s *skipList) Set(key, value interface{}) error {
-	if key == nil {
+// find 查找与key相等且未被删除的节点及其最新的版本
+func (s *skipList[K, V]) find(key K) (*node[K, V], *version[K, V]) {
+	dest, _ := s.addressing(key, nil)
+	if dest == nil || s.cmp(dest.key, key) != 0 {
+		return nil, nil
+	}
+	v := dest.latest()
+	if v.deleted {
+		return nil, nil
+	}
+	return dest, v
+}
+
+// Set 设置新值，根据cmp函数，保证每次插入都是有序的
+func (s *skipList[K, V]) Set(key K, value V) error {
+	if isNil(key) {
 		return errors.ErrNilKey
 	}
 
 	s.mu.Lock()
-	// 存在该key，直接更新
-	update := make([]*node, s.level()+1, s.maxEffectiveLevel()+1)
-	dest, err := s.addressing(key, s.head, update)
-	if err != nil {
-		s.mu.Unlock()
-		return err
+	var (
+		update = make([]*node[K, V], maxLevel+1)
+		rank   = make([]int, maxLevel+1)
+	)
+	dest := s.locate(key, update, rank)
+	v := &version[K, V]{
+		seq:   atomic.LoadUint64(&s.seq) + 1,
+		key:   key,
+		value: value,
 	}
-	if dest != nil && dest.key == key {
-		dest.value = value
+	// 存在与key相等的节点，为其追加新的版本
+	if dest != nil && s.cmp(dest.key, key) == 0 {
+		if dest.latest().deleted {
+			s.len++
+			for i := 0; i <= s.level(); i++ {
+				update[i].span[i]++
+			}
+		}
+		s.push(dest, v)
+		atomic.StoreUint64(&s.seq, v.seq)
 		s.mu.Unlock()
 		return nil
 	}
 
 	level := s.getNewLevel()
-	for i := s.level() + 1; i <= level; i++ {
-		update = append(update, s.head)
-		s.head.forward = append(s.head.forward, nil)
+	if cur := s.level(); level > cur {
+		for i := cur + 1; i <= level; i++ {
+			update[i] = s.head
+			rank[i] = 0
+			s.head.span[i] = s.len
+		}
+		atomic.StoreInt32(&s.height, int32(level))
 	}
 
 	// 长度加1
 	s.len++
 
-	newNode := &node{
-		forward: make([]*node, level+1, s.maxEffectiveLevel()+1),
-		key:     key,
-		value:   value,
+	newNode := newNode[K, V](key, level)
+	newNode.versions.Store(v)
+	// 先设置新节点的后继再链接到前驱，并发的读取看到的总是完整的节点
+	for i := 0; i <= level; i++ {
+		newNode.forward[i].Store(update[i].forward[i].Load())
 	}
-
-	if backward := update[0]; backward.key != nil {
-		newNode.backward = backward
+	for i := 0; i <= level; i++ {
+		update[i].forward[i].Store(newNode)
 	}
-
+	// 与redis的zskiplist相同，新节点拆分前驱的跨度，更高层的前驱跨度加1
 	for i := 0; i <= level; i++ {
-		newNode.forward[i] = update[i].forward[i]
-		update[i].forward[i] = newNode
+		newNode.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
 	}
-
-	if newNode.forward[0] != nil && newNode.forward[0].backward != newNode {
-		newNode.forward[0].backward = newNode
+	for i := level + 1; i <= s.level(); i++ {
+		update[i].span[i]++
 	}
+	atomic.StoreUint64(&s.seq, v.seq)
 
 	s.mu.Unlock()
 
 	return nil
 }
 
-func (s *skipList) Get(key interface{}) (value interface{}, err error) {
-	if key == nil {
-		return nil, errors.ErrNilKey
+// push 为节点追加新的版本，并丢弃所有迭代器都看不到的旧版本，调用方需持有写锁
+func (s *skipList[K, V]) push(n *node[K, V], v *version[K, V]) {
+	v.next.Store(n.latest())
+	n.versions.Store(v)
+	oldest := s.oldest()
+	for p := v; p != nil; p = p.next.Load() {
+		if p.seq <= oldest {
+			p.next.Store(nil)
+			return
+		}
 	}
-	s.mu.RLock()
-	dest, err := s.addressing(key, s.head, nil)
-	if err != nil {
-		s.mu.RUnlock()
-		return nil, err
+}
+
+// oldest 最旧的迭代器的版本号，没有迭代器时为最新的版本号，调用方需持有写锁
+func (s *skipList[K, V]) oldest() uint64 {
+	s.snapMu.Lock()
+	oldest := atomic.LoadUint64(&s.seq)
+	for seq := range s.snapshots {
+		if seq < oldest {
+			oldest = seq
+		}
 	}
-	if dest == nil || dest.key != key {
-		s.mu.RUnlock()
-		return nil, errors.ErrNotFound
+	s.snapMu.Unlock()
+	return oldest
+}
+
+func (s *skipList[K, V]) Get(key K) (value V, err error) {
+	if isNil(key) {
+		return value, errors.ErrNilKey
 	}
+	s.mu.RLock()
+	_, v := s.find(key)
 	s.mu.RUnlock()
-	return dest.value, nil
+	if v == nil {
+		return value, errors.ErrNotFound
+	}
+	return v.value, nil
 }
 
-func (s *skipList) Del(key interface{}) error {
-	if key == nil {
-		return errors.ErrNilKey
+func (s *skipList[K, V]) Lookup(key K) (storedKey K, value V, err error) {
+	if isNil(key) {
+		return storedKey, value, errors.ErrNilKey
 	}
-
 	s.mu.RLock()
-	update := make([]*node, s.level()+1, s.maxEffectiveLevel())
-	dest, err := s.addressing(key, s.head, update)
-	if err != nil {
-		s.mu.RUnlock()
-		return err
-	}
-	if dest == nil || dest.key != key {
-		s.mu.RUnlock()
-		return errors.ErrNotFound
-	}
+	_, v := s.find(key)
 	s.mu.RUnlock()
+	if v == nil {
+		return storedKey, value, errors.ErrNotFound
+	}
+	return v.key, v.value, nil
+}
 
-	s.mu.Lock()
-	// 双重检测
-	update = make([]*node, s.level()+1, s.maxEffectiveLevel())
-	dest, err = s.addressing(key, s.head, update)
-	if err != nil {
-		s.mu.Unlock()
-		return nil
+func (s *skipList[K, V]) Del(key K) error {
+	if isNil(key) {
+		return errors.ErrNilKey
 	}
-	if dest == nil || dest.key != key {
+
+	s.mu.Lock()
+	update := make([]*node[K, V], maxLevel+1)
+	dest, _ := s.addressing(key, update)
+	if dest == nil || s.cmp(dest.key, key) != 0 || dest.latest().deleted {
 		s.mu.Unlock()
 		return errors.ErrNotFound
 	}
+	cur := dest.latest()
 
-	// 长度减1
+	// 长度减1，删除的节点不再计入跨度
 	s.len--
-
-	next := dest.next()
-	if next != nil {
-		next.backward = dest.backward
+	for i := 0; i <= s.level(); i++ {
+		update[i].span[i]--
 	}
 
-	// 删除所有层的dest
-	for i := 0; i <= s.level() && update[i].forward[i] == dest; i++ {
-		update[i].forward[i] = dest.forward[i]
-	}
-	// 删去空层
-	for s.level() > 0 && s.head.forward[s.level()] == nil {
-		s.head.forward = s.head.forward[:s.level()]
+	// 先写入删除标记，所有迭代器都能看到删除之后再摘除节点
+	v := &version[K, V]{
+		seq:     atomic.LoadUint64(&s.seq) + 1,
+		key:     cur.key,
+		deleted: true,
 	}
+	s.push(dest, v)
+	atomic.StoreUint64(&s.seq, v.seq)
+	s.removed = append(s.removed, dest)
+	s.collect()
 
 	s.mu.Unlock()
 
 	return nil
 }
 
-func (s *skipList) Iterator() Iterator {
-	s.mu.RLock()
-	iter := &iterator{
+// collect 摘除所有迭代器都能看到删除的节点，调用方需持有写锁
+func (s *skipList[K, V]) collect() {
+	var (
+		oldest  = s.oldest()
+		removed = s.removed[:0]
+	)
+	for _, n := range s.removed {
+		v := n.latest()
+		switch {
+		case !v.deleted:
+			// 删除后又被重新设置
+		case v.seq <= oldest:
+			s.unlink(n)
+		default:
+			removed = append(removed, n)
+		}
+	}
+	for i := len(removed); i < len(s.removed); i++ {
+		s.removed[i] = nil
+	}
+	s.removed = removed
+}
+
+// unlink 从所有层中摘除节点，节点自身的forward保持不变，停在该节点上的迭代器仍然可以向后遍历
+func (s *skipList[K, V]) unlink(n *node[K, V]) {
+	update := make([]*node[K, V], maxLevel+1)
+	s.addressing(n.key, update)
+	for i := 0; i <= s.level() && i < len(n.forward); i++ {
+		if update[i].forward[i].Load() == n {
+			// 被删除的节点已不计入跨度，前驱直接接管其跨度
+			update[i].span[i] += n.span[i]
+			update[i].forward[i].Store(n.forward[i].Load())
+		}
+	}
+	// 删去空层
+	level := s.level()
+	for level > 0 && s.head.forward[level].Load() == nil {
+		level--
+	}
+	atomic.StoreInt32(&s.height, int32(level))
+}
+
+func (s *skipList[K, V]) Iterator() Iterator[K, V] {
+	s.snapMu.Lock()
+	seq := atomic.LoadUint64(&s.seq)
+	s.snapshots[seq]++
+	s.snapMu.Unlock()
+	return &iterator[K, V]{
+		list:     s,
+		seq:      seq,
 		currNode: s.head,
 	}
-	s.mu.RUnlock()
-	return iter
 }
 
-func (s *skipList) Len() int {
+// release 迭代器关闭后，摘除只有它还能看到的节点
+func (s *skipList[K, V]) release(seq uint64) {
+	s.snapMu.Lock()
+	if s.snapshots[seq]--; s.snapshots[seq] <= 0 {
+		delete(s.snapshots, seq)
+	}
+	s.snapMu.Unlock()
+
+	s.mu.Lock()
+	if len(s.removed) > 0 {
+		s.collect()
+	}
+	s.mu.Unlock()
+}
+
+func (s *skipList[K, V]) Len() int {
 	s.mu.RLock()
 	length := s.len
 	s.mu.RUnlock()
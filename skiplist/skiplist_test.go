@@ -2,15 +2,17 @@ package skiplist
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
 )
 
-func newList() SkipList {
-	return NewSkipList(func(l, r interface{}) bool {
-		if l.(string) < r.(string) {
-			return true
-		}
-		return false
+func newList() SkipList[interface{}, interface{}] {
+	return NewSkipList[interface{}, interface{}](func(l, r interface{}) int {
+		return strings.Compare(l.(string), r.(string))
 	})
 }
 
@@ -81,7 +83,7 @@ func TestSkipList_Del(t *testing.T) {
 		return
 	}
 	v, err := list.Get("1")
-	if err != ErrNotFound || v != nil {
+	if err != errors.ErrNotFound || v != nil {
 		t.Errorf("test failed, err should be ErrNotFound or v should be nil")
 	}
 }
@@ -120,3 +122,176 @@ func TestSkipList_Iterator(t *testing.T) {
 	}
 
 }
+
+func TestSkipList_Lookup(t *testing.T) {
+	type pair struct {
+		k string
+		v int
+	}
+	list := NewSkipList[pair, int](func(l, r pair) int {
+		return strings.Compare(l.k, r.k)
+	})
+	_ = list.Set(pair{"1", 1}, 1)
+	// 比较相等的key视为同一个key
+	_ = list.Set(pair{"1", 2}, 2)
+	if list.Len() != 1 {
+		t.Errorf("test failed, len should be 1")
+		return
+	}
+	k, v, err := list.Lookup(pair{"1", 0})
+	if err != nil || k != (pair{"1", 2}) || v != 2 {
+		t.Errorf("test failed, k(%+v) v(%+v) should be the latest, err: %+v", k, v, err)
+		return
+	}
+	if v, err = list.Get(pair{"1", 1}); err != nil || v != 2 {
+		t.Errorf("test failed, v(%+v) should be 2, err: %+v", v, err)
+	}
+}
+
+func TestSkipList_Typed(t *testing.T) {
+	list := NewSkipList[string, int](strings.Compare)
+	for _, k := range []string{"b", "c", "a"} {
+		_ = list.Set(k, int(k[0]))
+	}
+	v, err := list.Get("a")
+	if err != nil || v != 'a' {
+		t.Errorf("test failed, v(%+v) should be %d, err: %+v", v, 'a', err)
+		return
+	}
+	if v, err = list.Get("d"); err != errors.ErrNotFound || v != 0 {
+		t.Errorf("test failed, err should be ErrNotFound and v should be zero")
+		return
+	}
+	iter := list.Iterator()
+	var out []string
+	for iter.HasNext() {
+		out = append(out, iter.Key())
+	}
+	iter.Close()
+	if strings.Join(out, "") != "abc" {
+		t.Errorf("test failed, out(%+v) should be ordered", out)
+	}
+}
+
+func TestSkipList_SeekAndPrev(t *testing.T) {
+	list := NewSkipList[string, int](strings.Compare)
+	for _, k := range []string{"b", "d", "f", "h"} {
+		_ = list.Set(k, int(k[0]))
+	}
+	iter := list.Iterator()
+	defer iter.Close()
+	if iter.Valid() {
+		t.Errorf("test failed, iterator should not be valid before seeking")
+		return
+	}
+	iter.Seek("c")
+	if !iter.Valid() || iter.Key() != "d" {
+		t.Errorf("test failed, key(%s) should be d", iter.Key())
+		return
+	}
+	if !iter.Prev() || iter.Key() != "b" {
+		t.Errorf("test failed, key(%s) should be b", iter.Key())
+		return
+	}
+	if iter.Prev() || iter.Valid() {
+		t.Errorf("test failed, iterator should be invalid before the first key")
+		return
+	}
+	iter.Seek("i")
+	if iter.Valid() {
+		t.Errorf("test failed, iterator should be invalid after the last key")
+		return
+	}
+	var out []string
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		out = append(out, iter.Key())
+	}
+	if strings.Join(out, "") != "hfdb" {
+		t.Errorf("test failed, out(%+v) should be in descending order", out)
+		return
+	}
+	iter.SeekToFirst()
+	if !iter.Valid() || iter.Key() != "b" || !iter.HasNext() || iter.Key() != "d" {
+		t.Errorf("test failed, key(%s) should be d", iter.Key())
+	}
+}
+
+func TestSkipList_IteratorSnapshot(t *testing.T) {
+	list := NewSkipList[string, int](strings.Compare)
+	for _, k := range []string{"a", "b", "c"} {
+		_ = list.Set(k, 1)
+	}
+	iter := list.Iterator()
+	_ = list.Set("a", 2)
+	_ = list.Set("bb", 2)
+	_ = list.Del("c")
+	var out []string
+	for iter.HasNext() {
+		out = append(out, iter.Key()+strconv.Itoa(iter.Value()))
+	}
+	if strings.Join(out, ",") != "a1,b1,c1" {
+		t.Errorf("test failed, out(%+v) should be the data before iterator created", out)
+		iter.Close()
+		return
+	}
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		if iter.Key() == "bb" {
+			t.Errorf("test failed, bb should not be visible")
+			break
+		}
+	}
+	iter.Close()
+	s := list.(*skipList[string, int])
+	if len(s.removed) != 0 || len(s.snapshots) != 0 {
+		t.Errorf("test failed, deleted node should be unlinked after iterator closed")
+		return
+	}
+	if v, err := list.Get("a"); err != nil || v != 2 || list.Len() != 3 {
+		t.Errorf("test failed, v(%d) should be 2 and len(%d) should be 3", v, list.Len())
+	}
+}
+
+func TestSkipList_IterateWhileWriting(t *testing.T) {
+	list := NewSkipList[string, int](strings.Compare)
+	const n = 1000
+	for i := 0; i < n; i++ {
+		_ = list.Set(fmt.Sprintf("%04d", i), i)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			k := fmt.Sprintf("%04d", i)
+			if i%2 == 0 {
+				_ = list.Del(k)
+			} else {
+				_ = list.Set(k, -i)
+			}
+			_ = list.Set(k+"x", i)
+		}
+	}()
+	for r := 0; r < 20; r++ {
+		iter := list.Iterator()
+		var (
+			prev  string
+			count int
+		)
+		for iter.HasNext() {
+			if iter.Key() <= prev {
+				t.Errorf("test failed, key(%s) should be greater than %s", iter.Key(), prev)
+			}
+			prev = iter.Key()
+			count++
+		}
+		iter.Close()
+		// 每个key要么被删除，要么多出一个带x的key，数量保持在[n/2, 2n]之间
+		if count < n/2 || count > 2*n {
+			t.Errorf("test failed, count(%d) is out of range", count)
+		}
+	}
+	wg.Wait()
+	if l := list.Len(); l != n+n/2 {
+		t.Errorf("test failed, len(%d) should be %d", l, n+n/2)
+	}
+}
@@ -0,0 +1,315 @@
+package simpledb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+const (
+	snapshotMagic   = "SDBSNAP"
+	snapshotVersion = 2
+	// snapshotChunk 超过该长度的数据按块读取，长度被损坏时不会直接分配过大的内存
+	snapshotChunk = 64 * 1024
+)
+
+// entry 某一时刻db中的一条数据
+type entry[K, V any] struct {
+	key      K
+	value    V
+	expireAt int64
+}
+
+// entries 按key的顺序取出db中所有未过期的数据，期间会阻塞写操作
+func (d *TypedDB[K, V]) entries() []entry[K, V] {
+	d.mu.RLock()
+	ret := d.collect(d.now())
+	d.mu.RUnlock()
+	return ret
+}
+
+// collect 同entries，调用方需持有锁
+func (d *TypedDB[K, V]) collect(now int64) []entry[K, V] {
+	var (
+		ret  = make([]entry[K, V], 0, d.data.Len())
+		iter = d.data.Iterator()
+	)
+	for iter.HasNext() {
+		it := iter.Value()
+		expireAt := it.deadline()
+		if expireAt != 0 && expireAt <= now {
+			continue
+		}
+		ret = append(ret, entry[K, V]{
+			key:      iter.Key(),
+			value:    it.value,
+			expireAt: expireAt,
+		})
+	}
+	iter.Close()
+	return ret
+}
+
+// keyType 快照中记录的key的类型
+func (d *TypedDB[K, V]) keyType() keyType {
+	if d.stringKey {
+		return String
+	}
+	return Custom
+}
+
+// SnapshotTo 将db某一时刻的数据写入w，保留剩余的过期时间(毫秒)，
+// 只在取数据时短暂阻塞写操作，编码和写入期间不影响并发的Save
+func (d *TypedDB[K, V]) SnapshotTo(w io.Writer) error {
+	if !d.life.enter() {
+		return errors.ErrClosed
+	}
+	defer d.life.leave()
+	var (
+		entries = d.entries()
+		now     = d.now()
+		sw      = newSnapshotWriter(w)
+	)
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{snapshotVersion, byte(d.keyType())})
+	sw.uvarint(uint64(len(entries)))
+	for _, e := range entries {
+		key, err := d.encodeKey(e.key)
+		if err != nil {
+			return err
+		}
+		value, err := d.encodeValue(e.value)
+		if err != nil {
+			return err
+		}
+		// 剩余的过期时间，0表示不过期
+		var ttl int64
+		if e.expireAt != 0 {
+			ttl = e.expireAt - now
+			if ttl <= 0 {
+				ttl = 1
+			}
+		}
+		sw.bytes(key)
+		sw.bytes(value)
+		sw.uvarint(uint64(ttl))
+	}
+	return sw.close()
+}
+
+// LoadTypedSnapshot 从快照中恢复出TypedDB，参数同NewTypedDB
+func LoadTypedSnapshot[K, V any](r io.Reader, cmp func(a, b K) int, opts ...DBOption) (*TypedDB[K, V], error) {
+	db, err := OpenTypedDB[K, V](cmp, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.load(r); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// LoadSnapshot 从快照中恢复出key为string的db，opts同NewDB
+func LoadSnapshot(r io.Reader, opts ...DBOption) (*DB, error) {
+	db, err := OpenDB(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.typed.load(r); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// LoadCustomSnapshot 从快照中恢复出key可定制的db，参数同NewCustomDB
+func LoadCustomSnapshot(r io.Reader, less func(l, r interface{}) bool, opts ...DBOption) (*DB, error) {
+	db, err := OpenCustomDB(less, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.typed.load(r); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (d *TypedDB[K, V]) load(r io.Reader) error {
+	sr := newSnapshotReader(r)
+	header := sr.read(len(snapshotMagic) + 2)
+	if sr.err != nil {
+		return sr.err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic ||
+		header[len(snapshotMagic)] != snapshotVersion ||
+		keyType(header[len(snapshotMagic)+1]) != d.keyType() {
+		return errors.ErrInvalidSnapshot
+	}
+	var (
+		count = sr.uvarint()
+		now   = d.now()
+	)
+	// 先解码所有数据并校验，校验通过后再作为一个事务写入，损坏的快照不会写入任何数据和日志
+	tx := d.begin(true)
+	defer tx.discard()
+	for i := uint64(0); i < count && sr.err == nil; i++ {
+		var (
+			key   = sr.bytes()
+			value = sr.bytes()
+			ttl   = int64(sr.uvarint())
+		)
+		if sr.err != nil {
+			break
+		}
+		k, err := d.decodeKey(key)
+		if err != nil {
+			return err
+		}
+		v, err := d.decodeValue(value)
+		if err != nil {
+			return err
+		}
+		var expireAt int64
+		if ttl > 0 && d.withExpired() {
+			expireAt = now + ttl
+		}
+		if err = tx.writes.Set(k, txWrite[V]{item: item[V]{value: v, expireAt: expireAt}}); err != nil {
+			return err
+		}
+	}
+	if err := sr.close(); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// snapshotWriter 写入的同时计算校验和，出错后的写入都会被忽略
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	return &snapshotWriter{
+		w:   bufio.NewWriter(w),
+		crc: crc32.NewIEEE(),
+	}
+}
+
+func (s *snapshotWriter) write(p []byte) {
+	if s.err != nil {
+		return
+	}
+	_, _ = s.crc.Write(p)
+	_, s.err = s.w.Write(p)
+}
+
+func (s *snapshotWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(s.buf[:], v)
+	s.write(s.buf[:n])
+}
+
+func (s *snapshotWriter) bytes(p []byte) {
+	s.uvarint(uint64(len(p)))
+	s.write(p)
+}
+
+func (s *snapshotWriter) close() error {
+	if s.err != nil {
+		return s.err
+	}
+	binary.LittleEndian.PutUint32(s.buf[:4], s.crc.Sum32())
+	if _, err := s.w.Write(s.buf[:4]); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	return &snapshotReader{
+		r:   bufio.NewReader(r),
+		crc: crc32.NewIEEE(),
+	}
+}
+
+func (s *snapshotReader) read(n int) []byte {
+	if s.err != nil {
+		return nil
+	}
+	if n > snapshotChunk {
+		return s.readChunks(n)
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(s.r, p); err != nil {
+		s.err = errors.ErrInvalidSnapshot
+		return nil
+	}
+	_, _ = s.crc.Write(p)
+	return p
+}
+
+// readChunks 随读入的数据扩容，分配的内存不会超过实际数据长度的两倍
+func (s *snapshotReader) readChunks(n int) []byte {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, s.r, int64(n)); err != nil {
+		s.err = errors.ErrInvalidSnapshot
+		return nil
+	}
+	_, _ = s.crc.Write(buf.Bytes())
+	return buf.Bytes()
+}
+
+func (s *snapshotReader) ReadByte() (byte, error) {
+	p := s.read(1)
+	if s.err != nil {
+		return 0, s.err
+	}
+	return p[0], nil
+}
+
+func (s *snapshotReader) uvarint() uint64 {
+	v, err := binary.ReadUvarint(s)
+	if err != nil {
+		s.err = errors.ErrInvalidSnapshot
+	}
+	return v
+}
+
+func (s *snapshotReader) bytes() []byte {
+	n := s.uvarint()
+	if n > math.MaxInt32 {
+		s.err = errors.ErrInvalidSnapshot
+	}
+	if s.err != nil {
+		return nil
+	}
+	return s.read(int(n))
+}
+
+func (s *snapshotReader) close() error {
+	if s.err != nil {
+		return s.err
+	}
+	sum := s.crc.Sum32()
+	p := make([]byte, 4)
+	if _, err := io.ReadFull(s.r, p); err != nil || binary.LittleEndian.Uint32(p) != sum {
+		return errors.ErrInvalidSnapshot
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package simpledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	db := NewDB(DBOptionWithExpired())
+	_ = db.Save("1", 1)
+	_ = db.Save("2", "2", SaveOptionTTL(100))
+	_ = db.Save("3", 3.5)
+
+	var buf bytes.Buffer
+	if err := db.SnapshotTo(&buf); err != nil {
+		t.Errorf("snapshot failed, err: %+v", err)
+		return
+	}
+	loaded, err := LoadSnapshot(&buf, DBOptionWithExpired())
+	if err != nil {
+		t.Errorf("load failed, err: %+v", err)
+		return
+	}
+	iter := loaded.Iterator()
+	var keys []string
+	for iter.HasNext() {
+		keys = append(keys, iter.Key().(string))
+	}
+	iter.Close()
+	if len(keys) != 3 || keys[0] != "1" || keys[1] != "2" || keys[2] != "3" {
+		t.Errorf("load failed, keys(%+v) should be in order", keys)
+		return
+	}
+	if v, _ := loaded.Get("2"); v != "2" {
+		t.Errorf("load failed, v(%+v) should be \"2\"", v)
+		return
+	}
+	if v, _ := loaded.Get("3"); v != 3.5 {
+		t.Errorf("load failed, v(%+v) should be 3.5", v)
+		return
+	}
+	if it, _ := loaded.typed.data.Get("2"); it.expireAt == 0 {
+		t.Errorf("load failed, ttl of key should be kept")
+		return
+	}
+	if it, _ := loaded.typed.data.Get("1"); it.expireAt != 0 {
+		t.Errorf("load failed, key without ttl should not expire")
+	}
+}
+
+func TestDB_SnapshotCorrupt(t *testing.T) {
+	db := NewDB()
+	_ = db.Save("1", 1)
+	var buf bytes.Buffer
+	_ = db.SnapshotTo(&buf)
+	data := buf.Bytes()
+	data[len(data)-5]++
+	if _, err := LoadSnapshot(bytes.NewReader(data)); err != errors.ErrInvalidSnapshot {
+		t.Errorf("load failed, err(%+v) should be ErrInvalidSnapshot", err)
+		return
+	}
+	if _, err := LoadCustomSnapshot(bytes.NewReader(buf.Bytes()), func(l, r interface{}) bool {
+		return false
+	}); err != errors.ErrInvalidSnapshot {
+		t.Errorf("load failed, key type should be checked, err: %+v", err)
+		return
+	}
+	// key的长度被改为远大于剩余的数据
+	data = append(binary.AppendUvarint(append([]byte(nil), buf.Bytes()[:len(snapshotMagic)+3]...), math.MaxInt32),
+		buf.Bytes()[len(snapshotMagic)+4:]...)
+	if _, err := LoadSnapshot(bytes.NewReader(data)); err != errors.ErrInvalidSnapshot {
+		t.Errorf("load failed, err(%+v) of corrupt length should be ErrInvalidSnapshot", err)
+	}
+}
+
+func TestDB_SnapshotCorruptWAL(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB()
+	for i := 0; i < 3; i++ {
+		_ = db.Save(strconv.Itoa(i), i)
+	}
+	var buf bytes.Buffer
+	_ = db.SnapshotTo(&buf)
+	// 只有校验和被损坏，所有数据都能解码
+	data := buf.Bytes()
+	data[len(data)-1]++
+	if _, err := LoadSnapshot(bytes.NewReader(data), DBOptionWithWAL(dir)); err != errors.ErrInvalidSnapshot {
+		t.Errorf("load failed, err(%+v) should be ErrInvalidSnapshot", err)
+		return
+	}
+	reopened, err := OpenDB(DBOptionWithWAL(dir))
+	if err != nil {
+		t.Errorf("open failed, err: %+v", err)
+		return
+	}
+	defer reopened.Close()
+	if n, _ := reopened.Count(); n != 0 {
+		t.Errorf("load failed, count(%d) should be 0, corrupt snapshot should not be logged", n)
+	}
+}
+
+func TestDB_SnapshotWhileSaving(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 1000; i++ {
+		_ = db.Save(strconv.Itoa(i), i)
+	}
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = db.Save(strconv.Itoa(i), i)
+		}
+	}()
+	var buf bytes.Buffer
+	err := db.SnapshotTo(&buf)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Errorf("snapshot failed, err: %+v", err)
+		return
+	}
+	loaded, err := LoadSnapshot(&buf)
+	if err != nil {
+		t.Errorf("load failed, err: %+v", err)
+		return
+	}
+	if n, _ := loaded.Count(); n < 1000 {
+		t.Errorf("load failed, count(%d) should not be less than 1000", n)
+	}
+}
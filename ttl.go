@@ -0,0 +1,209 @@
+package simpledb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+// NoExpiration TTL的返回值，表示key不会过期
+const NoExpiration time.Duration = -1
+
+// TTL key剩余的过期时间，精确到毫秒，不会过期时返回NoExpiration
+func (d *TypedDB[K, V]) TTL(key K) (time.Duration, error) {
+	if !d.life.enter() {
+		return 0, errors.ErrClosed
+	}
+	defer d.life.leave()
+	it, err := d.data.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	now := d.now()
+	if d.expired(it, now) {
+		return 0, errors.ErrNotFound
+	}
+	expireAt := it.deadline()
+	if expireAt == 0 {
+		return NoExpiration, nil
+	}
+	return time.Duration(expireAt-now) * time.Millisecond, nil
+}
+
+// Expire 修改key的过期时间为ttl之后，ttl不大于0时key立即被删除
+func (d *TypedDB[K, V]) Expire(key K, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	_, err := d.setExpireAt(key, deadline(d.now()+millis(ttl)))
+	return err
+}
+
+// ExpireAt 修改key的过期时间为t，t已经过去时key立即被删除
+func (d *TypedDB[K, V]) ExpireAt(key K, t time.Time) error {
+	_, err := d.setExpireAt(key, deadline(t.UnixMilli()))
+	return err
+}
+
+// deadline 将unix毫秒作为过期时间，0表示不过期，恰好为0时(unix纪元)改为-1，仍是已经过去的时间
+func deadline(ms int64) int64 {
+	if ms == 0 {
+		return -1
+	}
+	return ms
+}
+
+// Persist 去掉key的过期时间，返回是否去掉了过期时间，key原本不会过期时返回false
+func (d *TypedDB[K, V]) Persist(key K) (bool, error) {
+	return d.setExpireAt(key, 0)
+}
+
+// setExpireAt 只修改过期时间，日志中不记录value，滑动过期变为固定的过期时间，软过期时间只按提前刷新的比例重新计算，
+// expireAt为0时去掉过期时间，不晚于当前时间时直接删除，返回是否修改了过期时间，去掉时原本不会过期则不修改
+func (d *TypedDB[K, V]) setExpireAt(key K, expireAt int64) (bool, error) {
+	if any(key) == nil {
+		return false, errors.ErrNilKey
+	}
+	if !d.withExpired() {
+		return false, errors.ErrExpireDisabled
+	}
+	if !d.life.enter() {
+		return false, errors.ErrClosed
+	}
+	defer d.life.leave()
+	d.mu.Lock()
+	now := d.now()
+	stored, it, err := d.data.Lookup(key)
+	if err == nil && d.expired(it, now) {
+		err = errors.ErrNotFound
+	}
+	if err != nil {
+		d.unlock()
+		return false, err
+	}
+	if expireAt == 0 && it.deadline() == 0 {
+		d.unlock()
+		return false, nil
+	}
+	if expireAt != 0 && expireAt <= now {
+		err = d.expireNow(stored)
+		d.unlock()
+		if err != nil {
+			return false, err
+		}
+		d.maybeCompact()
+		return true, nil
+	}
+	if d.log != nil {
+		rec, err := d.expireRecord(stored, expireAt)
+		if err != nil {
+			d.unlock()
+			return false, err
+		}
+		if err = d.log.Append(rec); err != nil {
+			d.unlock()
+			return false, err
+		}
+	}
+	it.expireAt = expireAt
+	it.staleAt = d.staleAt(now, expireAt, 0)
+	it.sliding = nil
+	if d.cache != nil {
+		it.meta = d.cache.put(it.meta, stored, it.value, expireAt)
+	}
+	err = d.store(stored, it)
+	d.unlock()
+	if err != nil {
+		return false, err
+	}
+	d.maybeCompact()
+	return true, nil
+}
+
+// expireNow 过期时间已经过去，记录删除并立即删除，调用方需持有写锁
+func (d *TypedDB[K, V]) expireNow(stored K) error {
+	if d.log != nil {
+		rec, err := d.deleteRecord(stored)
+		if err != nil {
+			return err
+		}
+		if err = d.log.Append(rec); err != nil {
+			return err
+		}
+	}
+	return d.delete(stored, EvictExpired)
+}
+
+// TTL key剩余的过期时间，精确到毫秒，不会过期时返回NoExpiration，同Get支持CustomKey.Key()作为寻址key
+func (d *DB) TTL(key interface{}) (time.Duration, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	k, err := d.lookupKey(key)
+	if err != nil {
+		return 0, err
+	}
+	return d.typed.TTL(k)
+}
+
+// Expire 修改key的过期时间为ttl之后，ttl不大于0时key立即被删除
+func (d *DB) Expire(key interface{}, ttl time.Duration) error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	k, err := d.lookupKey(key)
+	if err != nil {
+		return err
+	}
+	return d.typed.Expire(k, ttl)
+}
+
+// ExpireAt 修改key的过期时间为t，t已经过去时key立即被删除
+func (d *DB) ExpireAt(key interface{}, t time.Time) error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	k, err := d.lookupKey(key)
+	if err != nil {
+		return err
+	}
+	return d.typed.ExpireAt(k, t)
+}
+
+// Persist 去掉key的过期时间，返回是否去掉了过期时间，key原本不会过期时返回false
+func (d *DB) Persist(key interface{}) (bool, error) {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	k, err := d.lookupKey(key)
+	if err != nil {
+		return false, err
+	}
+	return d.typed.Persist(k)
+}
+
+// sliding 滑动过期的时长和当前的过期时间(unix毫秒)，覆盖前的各个版本共享，读取时原子地续期，无需重新写入跳表
+type sliding struct {
+	ttl      int64
+	deadline int64
+}
+
+// renew 将过期时间延后到now之后ttl，只会向后延
+func (s *sliding) renew(now int64) {
+	next := now + s.ttl
+	for {
+		cur := atomic.LoadInt64(&s.deadline)
+		if cur >= next || atomic.CompareAndSwapInt64(&s.deadline, cur, next) {
+			return
+		}
+	}
+}
+
+// deadline 实际的过期时间，滑动过期时以续期后的时间为准
+func (it item[V]) deadline() int64 {
+	if it.sliding != nil {
+		return atomic.LoadInt64(&it.sliding.deadline)
+	}
+	return it.expireAt
+}
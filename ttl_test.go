@@ -0,0 +1,210 @@
+package simpledb
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/clocktest"
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_SaveOptionTTLDuration(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock))
+	_ = db.Save("1", 1, SaveOptionTTLDuration(50*time.Millisecond))
+	_ = db.Save("2", 2, SaveOptionExpireAt(clock.Now().Add(time.Hour)))
+	if _, err := db.Get("1"); err != nil {
+		t.Errorf("get failed, err: %+v", err)
+		return
+	}
+	clock.Advance(49 * time.Millisecond)
+	if _, err := db.Get("1"); err != nil {
+		t.Errorf("get failed, 1 should not be expired, err: %+v", err)
+		return
+	}
+	clock.Advance(time.Millisecond)
+	if _, err := db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("get failed, 1 should be expired, err: %+v", err)
+		return
+	}
+	if ttl, _ := db.TTL("2"); ttl != time.Hour-50*time.Millisecond {
+		t.Errorf("ttl failed, ttl(%s) should be 59m59.95s", ttl)
+	}
+}
+
+func TestDB_SaveOptionTTLOverflow(t *testing.T) {
+	db := NewDB(DBOptionWithExpired())
+	// 秒数乘以time.Second溢出时不应变成已过期
+	_ = db.Save("1", 1, SaveOptionTTL(math.MaxInt64))
+	if _, err := db.Get("1"); err != nil {
+		t.Errorf("get failed, 1 should not be expired, err: %+v", err)
+		return
+	}
+	if ttl, _ := db.TTL("1"); ttl < 100*365*24*time.Hour {
+		t.Errorf("ttl failed, ttl(%s) should be the max duration", ttl)
+	}
+}
+
+func TestDB_Expire(t *testing.T) {
+	db := NewDB(DBOptionWithExpired())
+	_ = db.Save("1", 1)
+	if ttl, _ := db.TTL("1"); ttl != NoExpiration {
+		t.Errorf("ttl failed, ttl(%s) should be NoExpiration", ttl)
+		return
+	}
+	if _, err := db.TTL("2"); err != errors.ErrNotFound {
+		t.Errorf("ttl failed, err(%+v) should be ErrNotFound", err)
+		return
+	}
+	if err := db.Expire("1", time.Minute); err != nil {
+		t.Errorf("expire failed, err: %+v", err)
+		return
+	}
+	if ttl, _ := db.TTL("1"); ttl <= 59*time.Second || ttl > time.Minute {
+		t.Errorf("ttl failed, ttl(%s) should be about 1m", ttl)
+		return
+	}
+	if v, _ := db.Get("1"); v != 1 {
+		t.Errorf("expire failed, v(%+v) should be 1", v)
+		return
+	}
+	if removed, err := db.Persist("1"); err != nil || !removed {
+		t.Errorf("persist failed, removed(%v) should be true, err: %+v", removed, err)
+		return
+	}
+	if removed, err := db.Persist("1"); err != nil || removed {
+		t.Errorf("persist failed, removed(%v) should be false without ttl, err: %+v", removed, err)
+		return
+	}
+	if ttl, _ := db.TTL("1"); ttl != NoExpiration {
+		t.Errorf("ttl failed, ttl(%s) should be NoExpiration", ttl)
+		return
+	}
+	_ = db.ExpireAt("1", time.Now().Add(-time.Second))
+	if _, err := db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("expire at failed, 1 should be expired, err: %+v", err)
+		return
+	}
+	if _, err := db.Persist("1"); err != errors.ErrNotFound {
+		t.Errorf("persist failed, err(%+v) should be ErrNotFound", err)
+		return
+	}
+	// unix纪元不会被当作不过期，已经过去的时间直接删除
+	_ = db.Save("3", 3)
+	if err := db.ExpireAt("3", time.UnixMilli(0)); err != nil {
+		t.Errorf("expire at failed, err: %+v", err)
+		return
+	}
+	if _, err := db.typed.data.Get("3"); err != errors.ErrNotFound {
+		t.Errorf("expire at failed, 3 should be deleted, err: %+v", err)
+		return
+	}
+	_ = db.Save("4", 4, SaveOptionExpireAt(time.UnixMilli(0)))
+	if _, err := db.Get("4"); err != errors.ErrNotFound {
+		t.Errorf("save failed, 4 should be expired, err: %+v", err)
+		return
+	}
+	if err := NewDB().Expire("1", time.Minute); err != errors.ErrExpireDisabled {
+		t.Errorf("expire failed, err(%+v) should be ErrExpireDisabled", err)
+	}
+}
+
+func TestDBWithWAL_Expire(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB(DBOptionWithExpired(), DBOptionWithWAL(dir))
+	_ = db.Save("1", 1, SaveOptionTTL(100))
+	_ = db.Save("2", 2)
+	_, _ = db.Persist("1")
+	_ = db.Expire("2", time.Hour)
+	_ = db.Close()
+
+	db = NewDB(DBOptionWithExpired(), DBOptionWithWAL(dir))
+	defer db.Close()
+	if ttl, _ := db.TTL("1"); ttl != NoExpiration {
+		t.Errorf("reopen failed, ttl(%s) should be NoExpiration", ttl)
+		return
+	}
+	if ttl, _ := db.TTL("2"); ttl <= 59*time.Minute {
+		t.Errorf("reopen failed, ttl(%s) should be about 1h", ttl)
+		return
+	}
+	if v, _ := db.Get("2"); v != 2 {
+		t.Errorf("reopen failed, v(%+v) should be 2", v)
+	}
+}
+
+func TestDB_ClockSweep(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock))
+	defer db.Close()
+	_ = db.Save("1", 1, SaveOptionTTL(1))
+	_ = db.Save("2", 2, SaveOptionTTL(5))
+	clock.Advance(2 * time.Second)
+	// 后台清理在Advance中同步执行
+	if l := db.typed.data.Len(); l != 1 {
+		t.Errorf("sweep failed, len(%d) should be 1", l)
+		return
+	}
+	clock.Advance(3 * time.Second)
+	if l := db.typed.data.Len(); l != 0 {
+		t.Errorf("sweep failed, len(%d) should be 0", l)
+	}
+}
+
+func TestDB_SlidingTTL(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	db := NewDB(DBOptionWithExpired(), DBOptionClock(clock))
+	defer db.Close()
+	_ = db.Save("1", 1, SaveOptionSlidingTTL(2*time.Second))
+	clock.Advance(1500 * time.Millisecond)
+	if _, err := db.Get("1"); err != nil {
+		t.Errorf("get failed, err: %+v", err)
+		return
+	}
+	if ttl, _ := db.TTL("1"); ttl != 2*time.Second {
+		t.Errorf("sliding ttl failed, ttl(%s) should be 2s", ttl)
+		return
+	}
+	// 原本在2秒时过期，读取后续期到3.5秒，后台清理不会删除
+	clock.Advance(time.Second)
+	if l := db.typed.data.Len(); l != 1 {
+		t.Errorf("sweep failed, len(%d) should be 1", l)
+		return
+	}
+	if _, err := db.Get("1"); err != nil {
+		t.Errorf("get failed, err: %+v", err)
+		return
+	}
+	clock.Advance(2 * time.Second)
+	if _, err := db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("sliding ttl failed, 1 should be expired, err: %+v", err)
+		return
+	}
+	clock.Advance(time.Second)
+	if l := db.typed.data.Len(); l != 0 {
+		t.Errorf("sweep failed, len(%d) should be 0", l)
+		return
+	}
+
+	// 修改过期时间后不再滑动
+	_ = db.Save("2", 2, SaveOptionSlidingTTL(time.Second))
+	_ = db.Expire("2", time.Minute)
+	_, _ = db.Get("2")
+	if ttl, _ := db.TTL("2"); ttl != time.Minute {
+		t.Errorf("expire failed, ttl(%s) should be 1m", ttl)
+		return
+	}
+	// 覆盖时不指定过期时间则保留滑动过期
+	_ = db.Save("3", 3, SaveOptionSlidingTTL(time.Second))
+	_, _ = db.Modify("3", func(old interface{}, exists bool) (interface{}, bool) {
+		return 30, true
+	})
+	clock.Advance(800 * time.Millisecond)
+	_, _ = db.Get("3")
+	clock.Advance(800 * time.Millisecond)
+	if v, _ := db.Get("3"); v != 30 {
+		t.Errorf("sliding ttl failed, v(%+v) should be 30", v)
+	}
+}
@@ -0,0 +1,419 @@
+package simpledb
+
+import (
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/lsm"
+	"github.com/byronzhu-haha/simpledb/skiplist"
+	"github.com/byronzhu-haha/simpledb/wal"
+)
+
+// TypedTx TypedDB的事务，读取开始时的快照以及事务自身的写入，写入在提交前对其他人不可见。
+// 提交时校验事务读到的数据是否被其他写入修改过，是则整个事务失败并返回ErrConflict
+type TypedTx[K, V any] struct {
+	db       *TypedDB[K, V]
+	snapshot skiplist.Iterator[K, item[V]]
+	writable bool
+	done     bool
+	// reads 读到的数据的写入戳，writes 尚未提交的写入
+	reads  skiplist.SkipList[K, txRead]
+	writes skiplist.SkipList[K, txWrite[V]]
+}
+
+// txRead 事务读取时数据是否存在及其写入戳
+type txRead struct {
+	exists bool
+	stamp  uint64
+}
+
+// txWrite 事务中的一次写入，deleted表示删除
+type txWrite[V any] struct {
+	item    item[V]
+	deleted bool
+}
+
+// Update 在读写事务中执行fn，fn返回nil时提交，否则丢弃所有写入，
+// 发生冲突时返回ErrConflict，可以重新执行
+func (d *TypedDB[K, V]) Update(fn func(tx *TypedTx[K, V]) error) error {
+	if !d.life.enter() {
+		return errors.ErrClosed
+	}
+	defer d.life.leave()
+	tx := d.begin(true)
+	defer tx.discard()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// View 在只读事务中执行fn，fn中读到的是同一个快照
+func (d *TypedDB[K, V]) View(fn func(tx *TypedTx[K, V]) error) error {
+	if !d.life.enter() {
+		return errors.ErrClosed
+	}
+	defer d.life.leave()
+	tx := d.begin(false)
+	defer tx.discard()
+	return fn(tx)
+}
+
+func (d *TypedDB[K, V]) begin(writable bool) *TypedTx[K, V] {
+	tx := &TypedTx[K, V]{
+		db:       d,
+		snapshot: d.data.Iterator(),
+		writable: writable,
+	}
+	if writable {
+		tx.reads = skiplist.NewSkipList[K, txRead](d.cmp)
+		tx.writes = skiplist.NewSkipList[K, txWrite[V]](d.cmp)
+	}
+	return tx
+}
+
+// Get 根据key获取值，优先返回事务自身的写入
+func (tx *TypedTx[K, V]) Get(key K) (value V, err error) {
+	if any(key) == nil {
+		return value, errors.ErrNilKey
+	}
+	if tx.done {
+		return value, errors.ErrTxDone
+	}
+	if tx.writable {
+		if w, err := tx.writes.Get(key); err == nil {
+			if w.deleted {
+				return value, errors.ErrNotFound
+			}
+			return w.item.value, nil
+		}
+	}
+	_, it, ok := tx.read(key)
+	if !ok {
+		return value, errors.ErrNotFound
+	}
+	return it.value, nil
+}
+
+// read 从快照中读取，已过期的数据视为不存在，读写事务会记录读到的写入戳
+func (tx *TypedTx[K, V]) read(key K) (stored K, it item[V], ok bool) {
+	tx.snapshot.Seek(key)
+	if tx.snapshot.Valid() && tx.db.cmp(tx.snapshot.Key(), key) == 0 {
+		stored, it, ok = tx.snapshot.Key(), tx.snapshot.Value(), true
+	}
+	if tx.writable {
+		if _, err := tx.reads.Get(key); err != nil {
+			_ = tx.reads.Set(key, txRead{exists: ok, stamp: it.stamp})
+		}
+	}
+	if ok && tx.db.expired(it, tx.db.now()) {
+		return stored, it, false
+	}
+	return stored, it, ok
+}
+
+// Save 保存数据，支持过期时间，提交后才对其他人可见
+func (tx *TypedTx[K, V]) Save(key K, value V, opts ...SaveOption) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+	return tx.writes.Set(key, txWrite[V]{
+		item: tx.db.newItem(value, opts, item[V]{}),
+	})
+}
+
+// Delete 删除指定的key，key不存在时返回ErrNotFound
+func (tx *TypedTx[K, V]) Delete(key K) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+	if w, err := tx.writes.Get(key); err == nil {
+		if w.deleted {
+			return errors.ErrNotFound
+		}
+		return tx.writes.Set(key, txWrite[V]{deleted: true})
+	}
+	stored, _, ok := tx.read(key)
+	if !ok {
+		return errors.ErrNotFound
+	}
+	return tx.writes.Set(stored, txWrite[V]{deleted: true})
+}
+
+func (tx *TypedTx[K, V]) check(key K) error {
+	switch {
+	case any(key) == nil:
+		return errors.ErrNilKey
+	case tx.done:
+		return errors.ErrTxDone
+	case !tx.writable:
+		return errors.ErrTxReadOnly
+	}
+	return nil
+}
+
+// commit 校验并原子地写入事务中的所有写入
+func (tx *TypedTx[K, V]) commit() error {
+	if tx.writes.Len() == 0 {
+		return nil
+	}
+	var (
+		d           = tx.db
+		tree, isLSM = d.data.(*lsm.Tree[K, item[V]])
+		batch       []lsm.Mutation[K, item[V]]
+		recs        []wal.Record
+	)
+	iter := tx.writes.Iterator()
+	for iter.HasNext() {
+		w := iter.Value()
+		batch = append(batch, lsm.Mutation[K, item[V]]{Key: iter.Key(), Value: w.item, Deleted: w.deleted})
+		if d.log == nil {
+			continue
+		}
+		var (
+			rec wal.Record
+			err error
+		)
+		if w.deleted {
+			rec, err = d.deleteRecord(iter.Key())
+		} else {
+			rec, err = d.saveRecord(iter.Key(), w.item.value, w.item.expireAt)
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		recs = append(recs, rec)
+	}
+	iter.Close()
+
+	d.mu.Lock()
+	if err := tx.validate(); err != nil {
+		d.unlock()
+		return err
+	}
+	var (
+		applied = batch[:0]
+		logged  = recs[:0]
+		written = func(other K) bool {
+			_, err := tx.writes.Get(other)
+			return err == nil
+		}
+	)
+	for i, m := range batch {
+		if m.Deleted {
+			// 删除作用于实际存储的key，事务中先保存后删除的key可能并不存在，此时不需要写入
+			stored, _, err := d.data.Lookup(m.Key)
+			if err != nil {
+				continue
+			}
+			m.Key = stored
+		} else if err := d.checkUniqueExcept(m.Key, m.Value.value, written); err != nil {
+			// 事务中删除或覆盖的数据不再与之冲突，事务内部的冲突由tx.checkUnique检查
+			d.unlock()
+			return err
+		}
+		applied = append(applied, m)
+		if recs != nil {
+			logged = append(logged, recs[i])
+		}
+	}
+	if len(applied) == 0 {
+		d.unlock()
+		return nil
+	}
+	if err := tx.checkUnique(applied); err != nil {
+		d.unlock()
+		return err
+	}
+	// 先确认每条数据都能放下，只计算不淘汰，需要淘汰的数据在写入时淘汰
+	for _, m := range applied {
+		if !m.Deleted && !d.fits(m.Key, m.Value.value) {
+			d.unlock()
+			return errors.ErrOutOfCapacity
+		}
+	}
+	var err error
+	if isLSM {
+		err = tx.applyLSM(tree, applied)
+	} else {
+		err = tx.apply(applied, logged)
+	}
+	if err != nil {
+		d.unlock()
+		return err
+	}
+	d.unlock()
+	d.maybeCompact()
+	return nil
+}
+
+// applyLSM 将事务的修改原子地写入LSM树，调用方需持有写锁
+func (tx *TypedTx[K, V]) applyLSM(tree *lsm.Tree[K, item[V]], batch []lsm.Mutation[K, item[V]]) error {
+	var (
+		d    = tx.db
+		olds = make([]*item[V], len(batch))
+	)
+	for i, m := range batch {
+		stored, old, err := d.data.Lookup(m.Key)
+		if err == nil {
+			olds[i] = &old
+		}
+		if m.Deleted {
+			d.evict(m.Key, old, EvictDeleted)
+			continue
+		}
+		if err == nil {
+			d.evict(stored, old, EvictOverwrite)
+		}
+		d.stamp++
+		batch[i].Value.stamp = d.stamp
+	}
+	if err := tree.Apply(batch); err != nil {
+		return err
+	}
+	for i, m := range batch {
+		if m.Deleted {
+			d.unbind(m.Key)
+			d.reindex(m.Key, olds[i], nil)
+			continue
+		}
+		d.bind(m.Key)
+		d.track(m.Key, m.Value)
+		d.reindex(m.Key, olds[i], &batch[i].Value)
+	}
+	return nil
+}
+
+// apply 将事务的修改作为一条日志记录写入后更新跳表，调用方需持有写锁
+func (tx *TypedTx[K, V]) apply(batch []lsm.Mutation[K, item[V]], recs []wal.Record) error {
+	d := tx.db
+	if d.log != nil {
+		if err := d.log.AppendBatch(recs); err != nil {
+			return err
+		}
+	}
+	// 日志已写入，出错时仍继续应用其余的修改使内存与日志一致，返回第一个错误
+	var ret error
+	for _, m := range batch {
+		var err error
+		if m.Deleted {
+			err = d.delete(m.Key, EvictDeleted)
+		} else {
+			// 为事务中先前写入的数据腾出空间，没有可以淘汰的数据时(如volatile-ttl下都不过期)暂时超出限制
+			if err = d.makeRoom(m.Key, m.Value.value); err == errors.ErrOutOfCapacity {
+				err = nil
+			}
+			if setErr := d.set(m.Key, m.Value); err == nil {
+				err = setErr
+			}
+		}
+		if err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// checkUnique 检查事务中保存的数据之间是否违反唯一索引，调用方需持有写锁
+func (tx *TypedTx[K, V]) checkUnique(batch []lsm.Mutation[K, item[V]]) error {
+	for _, ix := range tx.db.indexes {
+		if !ix.unique {
+			continue
+		}
+		// fields 事务中已保存的字段，同一个key在事务中只保存一次
+		fields := skiplist.NewSkipList[interface{}, struct{}](ix.compare)
+		for _, m := range batch {
+			if m.Deleted {
+				continue
+			}
+			field, ok := ix.extractor(m.Value.value)
+			if !ok {
+				continue
+			}
+			if _, err := fields.Get(field); err == nil {
+				return errors.ErrDuplicateIndex
+			}
+			_ = fields.Set(field, struct{}{})
+		}
+	}
+	return nil
+}
+
+// validate 事务读到的数据在提交时仍然不变，调用方需持有写锁
+func (tx *TypedTx[K, V]) validate() error {
+	iter := tx.reads.Iterator()
+	defer iter.Close()
+	for iter.HasNext() {
+		r := iter.Value()
+		_, cur, err := tx.db.data.Lookup(iter.Key())
+		if err != nil && err != errors.ErrNotFound {
+			return err
+		}
+		exists := err == nil
+		if exists != r.exists || (exists && cur.stamp != r.stamp) {
+			return errors.ErrConflict
+		}
+	}
+	return nil
+}
+
+// discard 结束事务并释放快照
+func (tx *TypedTx[K, V]) discard() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	tx.snapshot.Close()
+}
+
+// Tx DB的事务，同TypedTx，key的校验和寻址规则与DB相同
+type Tx struct {
+	db *DB
+	tx *TypedTx[interface{}, interface{}]
+}
+
+// Update 在读写事务中执行fn，fn返回nil时提交，否则丢弃所有写入，
+// 发生冲突时返回ErrConflict，可以重新执行
+func (d *DB) Update(fn func(tx *Tx) error) error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.Update(func(tx *TypedTx[interface{}, interface{}]) error {
+		return fn(&Tx{db: d, tx: tx})
+	})
+}
+
+// View 在只读事务中执行fn，fn中读到的是同一个快照
+func (d *DB) View(fn func(tx *Tx) error) error {
+	// 检测db是否被初始化
+	d.checkBeforeOp()
+
+	return d.typed.View(func(tx *TypedTx[interface{}, interface{}]) error {
+		return fn(&Tx{db: d, tx: tx})
+	})
+}
+
+// Get 根据key获取值，同DB.Get支持CustomKey.Key()作为寻址key
+func (tx *Tx) Get(key interface{}) (interface{}, error) {
+	k, err := tx.db.lookupKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return tx.tx.Get(k)
+}
+
+// Save 保存数据，支持过期时间
+func (tx *Tx) Save(key, value interface{}, opts ...SaveOption) error {
+	if err := tx.db.isValidKey(key); err != nil {
+		return err
+	}
+	return tx.tx.Save(key, value, opts...)
+}
+
+// Delete 删除指定的Key，同DB.Delete支持CustomKey.Key()作为寻址key
+func (tx *Tx) Delete(key interface{}) error {
+	k, err := tx.db.lookupKey(key)
+	if err != nil {
+		return err
+	}
+	return tx.tx.Delete(k)
+}
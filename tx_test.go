@@ -0,0 +1,202 @@
+package simpledb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestDB_Update(t *testing.T) {
+	db := NewDB()
+	_ = db.Save("1", 1)
+	err := db.Update(func(tx *Tx) error {
+		if err := tx.Save("2", 2); err != nil {
+			return err
+		}
+		if err := tx.Delete("1"); err != nil {
+			return err
+		}
+		// 自身的写入可见，其他人不可见
+		if v, err := tx.Get("2"); err != nil || v != 2 {
+			t.Errorf("update failed, v(%+v) should be 2, err: %+v", v, err)
+		}
+		if _, err := tx.Get("1"); err != errors.ErrNotFound {
+			t.Errorf("update failed, 1 should be deleted in tx, err: %+v", err)
+		}
+		if _, err := db.Get("2"); err != errors.ErrNotFound {
+			t.Errorf("update failed, 2 should not be visible before commit, err: %+v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("update failed, err: %+v", err)
+		return
+	}
+	if v, _ := db.Get("2"); v != 2 {
+		t.Errorf("update failed, v(%+v) should be 2", v)
+		return
+	}
+	if _, err = db.Get("1"); err != errors.ErrNotFound {
+		t.Errorf("update failed, 1 should be deleted, err: %+v", err)
+		return
+	}
+
+	// fn返回错误时丢弃所有写入
+	_ = db.Update(func(tx *Tx) error {
+		_ = tx.Save("3", 3)
+		return errors.ErrNotFound
+	})
+	if _, err = db.Get("3"); err != errors.ErrNotFound {
+		t.Errorf("update failed, 3 should be discarded, err: %+v", err)
+	}
+}
+
+func TestDB_UpdateConflict(t *testing.T) {
+	db := NewDB()
+	_ = db.Save("balance", 10)
+	err := db.Update(func(tx *Tx) error {
+		v, _ := tx.Get("balance")
+		// 事务读取之后被其他人修改
+		_ = db.Save("balance", 100)
+		_ = tx.Save("balance", v.(int)-1)
+		return tx.Save("log", "withdraw")
+	})
+	if err != errors.ErrConflict {
+		t.Errorf("update failed, err(%+v) should be ErrConflict", err)
+		return
+	}
+	if v, _ := db.Get("balance"); v != 100 {
+		t.Errorf("update failed, v(%+v) should be 100", v)
+		return
+	}
+	if _, err = db.Get("log"); err != errors.ErrNotFound {
+		t.Errorf("update failed, log should not be written, err: %+v", err)
+		return
+	}
+
+	// 读到不存在的key，提交前被其他人写入
+	err = db.Update(func(tx *Tx) error {
+		if _, err := tx.Get("lock"); err != errors.ErrNotFound {
+			return err
+		}
+		_ = db.Save("lock", "other")
+		return tx.Save("lock", "me")
+	})
+	if err != errors.ErrConflict {
+		t.Errorf("update failed, err(%+v) should be ErrConflict", err)
+	}
+}
+
+func TestDB_UpdateConcurrently(t *testing.T) {
+	db := NewDB()
+	_ = db.Save("counter", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := db.Update(func(tx *Tx) error {
+					v, err := tx.Get("counter")
+					if err != nil {
+						return err
+					}
+					return tx.Save("counter", v.(int)+1)
+				})
+				if err != errors.ErrConflict {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := db.Get("counter"); v != 20 {
+		t.Errorf("update failed, counter(%+v) should be 20", v)
+	}
+}
+
+func TestDB_View(t *testing.T) {
+	db := NewDB()
+	_ = db.Save("1", 1)
+	err := db.View(func(tx *Tx) error {
+		_ = db.Save("1", 10)
+		if v, _ := tx.Get("1"); v != 1 {
+			t.Errorf("view failed, v(%+v) should be 1", v)
+		}
+		if err := tx.Save("2", 2); err != errors.ErrTxReadOnly {
+			t.Errorf("view failed, err(%+v) should be ErrTxReadOnly", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("view failed, err: %+v", err)
+	}
+}
+
+func TestDB_UpdateTTL(t *testing.T) {
+	db := NewDB(DBOptionWithExpired())
+	_ = db.Save("1", 1, SaveOptionTTL(100))
+	err := db.Update(func(tx *Tx) error {
+		v, _ := tx.Get("1")
+		return tx.Save("2", v, SaveOptionTTL(10))
+	})
+	if err != nil {
+		t.Errorf("update failed, err: %+v", err)
+		return
+	}
+	it, err := db.typed.data.Get("2")
+	if err != nil || it.value != 1 || it.expireAt == 0 {
+		t.Errorf("update failed, item(%+v) should expire, err: %+v", it, err)
+	}
+}
+
+func TestDB_UpdateReopen(t *testing.T) {
+	for _, opt := range []func(dir string) DBOption{DBOptionWithWAL, DBOptionWithLSM} {
+		dir, clean := tempDir(t)
+		db := NewDB(opt(dir))
+		_ = db.Save("1", 1)
+		err := db.Update(func(tx *Tx) error {
+			_ = tx.Delete("1")
+			_ = tx.Save("2", 2)
+			return tx.Save("3", 3)
+		})
+		if err != nil {
+			t.Errorf("update failed, err: %+v", err)
+			clean()
+			return
+		}
+		_ = db.Close()
+
+		db = NewDB(opt(dir))
+		n, _ := db.Count()
+		v, _ := db.Get("3")
+		_ = db.Close()
+		clean()
+		if n != 2 || v != 3 {
+			t.Errorf("reopen failed, count(%d) should be 2, v(%+v) should be 3", n, v)
+			return
+		}
+	}
+}
+
+func TestDBWithWAL_UpdateSaveThenDelete(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db := NewDB(DBOptionWithWAL(dir))
+	defer db.Close()
+	_ = db.Save("1", 1)
+	size := db.typed.log.Size()
+	// 事务中先保存后删除的key实际并不存在，不写入日志
+	err := db.Update(func(tx *Tx) error {
+		_ = tx.Save("2", 2)
+		return tx.Delete("2")
+	})
+	if err != nil {
+		t.Errorf("update failed, err: %+v", err)
+		return
+	}
+	if db.typed.log.Size() != size {
+		t.Errorf("update failed, size of log(%d) should be %d", db.typed.log.Size(), size)
+	}
+}
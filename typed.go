@@ -0,0 +1,560 @@
+package simpledb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+	"github.com/byronzhu-haha/simpledb/skiplist"
+	"github.com/byronzhu-haha/simpledb/wal"
+)
+
+// item 跳表中保存的值，expireAt为过期时间(unix毫秒)，0表示不过期，
+// staleAt为软过期时间(unix毫秒)，之后读取会在后台刷新，只保存在内存中，
+// stamp为写入时的戳，每次写入都不同，事务提交时据此判断数据是否被修改过
+type item[V any] struct {
+	value    V
+	expireAt int64
+	staleAt  int64
+	stamp    uint64
+	// sliding 不为nil时为滑动过期，实际的过期时间以sliding为准
+	sliding *sliding
+	// meta 开启容量限制时的元信息
+	meta *cacheEntry
+}
+
+// TypedDB key和value类型在编译期确定的内存数据库，key的顺序和相等性都由cmp决定
+type TypedDB[K, V any] struct {
+	mu   sync.RWMutex
+	cmp  func(a, b K) int
+	data skiplist.SkipList[K, item[V]]
+	conf Config
+	// stringKey 持久化时key直接以字节保存，否则使用codec编码
+	stringKey bool
+	// alias 不为nil时支持通过别名寻址，用于CustomKey.Key()，同一个别名只对应一个key
+	alias   func(key K) string
+	aliases map[string]K
+	log     *wal.Log
+	// 上次压缩后日志的大小，以及是否正在自动压缩
+	compactBase int64
+	compacting  int32
+	// stamp 最近一次写入的戳，需持有写锁
+	stamp uint64
+	// expiries 过期索引，expiryLive为上次重建后有效的项数，需持有写锁
+	expiries   expiryHeap[K]
+	expiryLive int
+	life       lifecycle
+	// stopSweep 停止后台清理过期数据
+	stopSweep func()
+	// evictions 执行移除回调的协程池，pending 持有写锁期间记录的移除
+	evictions *evictPool
+	pending   []evictEvent
+	// cache 开启容量限制时不为nil，hits、misses 为Get的统计
+	cache  *cache
+	hits   uint64
+	misses uint64
+	// loads GetOrLoad正在执行的加载
+	loads *loads[K, V]
+	// indexes 二级索引，需持有锁
+	indexes map[string]*index[K, V]
+	// nilable V为接口类型时值可能为nil，nils为值为nil的数据的个数，需持有写锁
+	nilable bool
+	nils    int
+}
+
+// NewTypedDB 创建key为K, value为V的内存数据库，cmp与strings.Compare的约定相同，
+// 开启持久化时若打开日志失败会panic，需要处理错误请使用OpenTypedDB
+func NewTypedDB[K, V any](cmp func(a, b K) int, opts ...DBOption) *TypedDB[K, V] {
+	db, err := OpenTypedDB[K, V](cmp, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// OpenTypedDB 同NewTypedDB，开启持久化时会回放日志，失败则返回错误
+func OpenTypedDB[K, V any](cmp func(a, b K) int, opts ...DBOption) (*TypedDB[K, V], error) {
+	db := newTypedDB[K, V](cmp, newConfig(opts))
+	if err := db.open(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func newTypedDB[K, V any](cmp func(a, b K) int, conf Config) *TypedDB[K, V] {
+	var key K
+	_, stringKey := any(key).(string)
+	return &TypedDB[K, V]{
+		cmp:       cmp,
+		conf:      conf,
+		stringKey: stringKey,
+		life:      newLifecycle(),
+		loads:     newLoads[K, V](cmp),
+	}
+}
+
+// open 创建存储，回放日志并启动后台任务
+func (d *TypedDB[K, V]) open() error {
+	if d.alias != nil {
+		d.aliases = make(map[string]K)
+	}
+	if d.conf.maxEntries > 0 || d.conf.maxBytes > 0 {
+		// LSM树中的数据不在内存中，无法统计和淘汰
+		if d.conf.lsmDir != "" {
+			return errors.ErrCapacityWithLSM
+		}
+		d.cache = newCache(d.conf)
+	}
+	// LSM树会保存写入戳，从当前时间开始递增，重启后也不会与已有的戳重复
+	d.stamp = uint64(time.Now().UnixNano())
+	if d.conf.lsmDir != "" {
+		// LSM树自身带有日志，无需再开启预写日志
+		if err := d.openLSM(); err != nil {
+			return err
+		}
+	} else {
+		var value V
+		d.data = skiplist.NewSkipList[K, item[V]](d.cmp)
+		d.nilable = any(value) == nil
+	}
+	if d.conf.walDir != "" && d.conf.lsmDir == "" {
+		if err := d.openWAL(); err != nil {
+			return err
+		}
+	}
+	// 回放日志时的覆盖和删除不触发回调
+	if d.conf.onEvict != nil {
+		d.evictions = newEvictPool(d.conf)
+	}
+	if d.withExpired() {
+		d.stopSweep = d.conf.clock.Every(time.Second, d.sweep)
+	}
+	return nil
+}
+
+func (d *TypedDB[K, V]) withExpired() bool {
+	return d.conf.withExpired
+}
+
+// Save 保存数据，支持过期时间
+func (d *TypedDB[K, V]) Save(key K, value V, opts ...SaveOption) error {
+	if !d.life.enter() {
+		return errors.ErrClosed
+	}
+	defer d.life.leave()
+	if any(key) == nil {
+		return errors.ErrNilKey
+	}
+	return d.write(key, d.newItem(value, opts, item[V]{}))
+}
+
+// newItem 根据选项生成要写入的数据，未指定过期时间时沿用old的过期时间
+func (d *TypedDB[K, V]) newItem(value V, opts []SaveOption, old item[V]) item[V] {
+	var o SaveOptions
+	for _, opt := range opts {
+		o = opt(o)
+	}
+	it := item[V]{value: value, expireAt: old.deadline(), staleAt: old.staleAt, sliding: old.sliding}
+	if !d.withExpired() || !o.isExpired {
+		return it
+	}
+	now := d.now()
+	if !o.deadline.IsZero() {
+		it.expireAt = deadline(o.deadline.UnixMilli())
+	} else {
+		it.expireAt = now + millis(o.ttl)
+	}
+	it.staleAt = d.staleAt(now, it.expireAt, o.softTTL)
+	it.sliding = nil
+	if o.sliding {
+		it.sliding = &sliding{ttl: millis(o.ttl), deadline: it.expireAt}
+	}
+	return it
+}
+
+// now 当前时间(unix毫秒)
+func (d *TypedDB[K, V]) now() int64 {
+	return d.conf.clock.Now().UnixMilli()
+}
+
+// millis 将时长转换为毫秒，不足1毫秒的部分向上取整
+func millis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// expired 数据是否已经过期
+func (d *TypedDB[K, V]) expired(it item[V], now int64) bool {
+	if !d.withExpired() {
+		return false
+	}
+	expireAt := it.deadline()
+	return expireAt != 0 && expireAt <= now
+}
+
+// write 记录日志并写入数据
+func (d *TypedDB[K, V]) write(key K, it item[V]) error {
+	var (
+		rec wal.Record
+		err error
+	)
+	if d.log != nil {
+		rec, err = d.saveRecord(key, it.value, it.expireAt)
+		if err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	if err = d.prepare(key, it.value); err != nil {
+		d.unlock()
+		return err
+	}
+	if d.log != nil {
+		if err = d.log.Append(rec); err != nil {
+			d.unlock()
+			return err
+		}
+	}
+	err = d.set(key, it)
+	d.unlock()
+	if err == nil {
+		d.maybeCompact()
+	}
+	return err
+}
+
+// set 写入数据，调用方需持有写锁
+func (d *TypedDB[K, V]) set(key K, it item[V]) error {
+	d.bind(key)
+	var (
+		meta *cacheEntry
+		old  *item[V]
+	)
+	if d.evictions != nil || d.cache != nil || len(d.indexes) > 0 {
+		if stored, cur, err := d.data.Lookup(key); err == nil {
+			d.evict(stored, cur, EvictOverwrite)
+			meta, old = cur.meta, &cur
+		}
+	}
+	if d.cache != nil {
+		it.meta = d.cache.put(meta, key, it.value, it.expireAt)
+	}
+	if err := d.store(key, it); err != nil {
+		return err
+	}
+	d.reindex(key, old, &it)
+	return nil
+}
+
+// store 生成写入戳后写入，不处理别名，调用方需持有写锁
+func (d *TypedDB[K, V]) store(key K, it item[V]) error {
+	d.stamp++
+	it.stamp = d.stamp
+	nils := d.nils
+	if d.nilable {
+		if _, old, err := d.data.Lookup(key); err == nil && any(old.value) == nil {
+			nils--
+		}
+		if any(it.value) == nil {
+			nils++
+		}
+	}
+	if err := d.data.Set(key, it); err != nil {
+		return err
+	}
+	d.nils = nils
+	d.track(key, it)
+	return nil
+}
+
+// bind 记录key的别名，别名相同但不相等的旧key会被替换掉，调用方需持有写锁
+func (d *TypedDB[K, V]) bind(key K) {
+	if d.alias == nil {
+		return
+	}
+	name := d.alias(key)
+	if old, ok := d.aliases[name]; ok && d.cmp(old, key) != 0 {
+		_ = d.delete(old, EvictOverwrite)
+	}
+	d.aliases[name] = key
+}
+
+// Get 根据key获取值，已过期的数据返回ErrNotFound
+func (d *TypedDB[K, V]) Get(key K) (value V, err error) {
+	if !d.life.enter() {
+		return value, errors.ErrClosed
+	}
+	defer d.life.leave()
+	it, err := d.data.Get(key)
+	if err != nil {
+		atomic.AddUint64(&d.misses, 1)
+		return value, err
+	}
+	now := d.now()
+	if d.expired(it, now) {
+		atomic.AddUint64(&d.misses, 1)
+		if d.conf.deleteOnRead {
+			d.deleteExpired(key, it.stamp)
+		}
+		return value, errors.ErrNotFound
+	}
+	atomic.AddUint64(&d.hits, 1)
+	if it.sliding != nil {
+		it.sliding.renew(now)
+	}
+	if it.staleAt != 0 && it.staleAt <= now && d.conf.refresh != nil {
+		d.refresh(key, it.stamp)
+	}
+	if d.cache != nil {
+		d.cache.access(it.meta)
+	}
+	return it.value, nil
+}
+
+// deleteExpired 删除读到的已过期数据，期间被重新写入过则不删除
+func (d *TypedDB[K, V]) deleteExpired(key K, stamp uint64) {
+	d.mu.Lock()
+	stored, cur, err := d.data.Lookup(key)
+	if err == nil && cur.stamp == stamp {
+		_ = d.delete(stored, EvictExpired)
+	}
+	d.unlock()
+}
+
+// resolve 根据别名找到实际的key
+func (d *TypedDB[K, V]) resolve(name string) (key K, err error) {
+	if d.alias == nil {
+		return key, errors.ErrNotFound
+	}
+	d.mu.RLock()
+	key, ok := d.aliases[name]
+	d.mu.RUnlock()
+	if !ok {
+		err = errors.ErrNotFound
+	}
+	return key, err
+}
+
+// Delete 删除指定的Key
+func (d *TypedDB[K, V]) Delete(key K) error {
+	if !d.life.enter() {
+		return errors.ErrClosed
+	}
+	defer d.life.leave()
+	if any(key) == nil {
+		return errors.ErrNilKey
+	}
+	d.mu.Lock()
+	stored, _, err := d.data.Lookup(key)
+	if err != nil {
+		d.unlock()
+		return err
+	}
+	if d.log != nil {
+		rec, err := d.deleteRecord(stored)
+		if err != nil {
+			d.unlock()
+			return err
+		}
+		if err = d.log.Append(rec); err != nil {
+			d.unlock()
+			return err
+		}
+	}
+	err = d.delete(stored, EvictDeleted)
+	d.unlock()
+	if err == nil {
+		d.maybeCompact()
+	}
+	return err
+}
+
+// delete 删除跳表中实际存储的key，reason为触发回调时的原因，调用方需持有写锁
+func (d *TypedDB[K, V]) delete(stored K, reason EvictReason) error {
+	var (
+		old item[V]
+		err error
+	)
+	if d.evictions != nil || d.cache != nil || len(d.indexes) > 0 || d.nilable {
+		if _, old, err = d.data.Lookup(stored); err != nil {
+			return err
+		}
+	}
+	if err = d.data.Del(stored); err != nil {
+		return err
+	}
+	if d.nilable && any(old.value) == nil {
+		d.nils--
+	}
+	d.evict(stored, old, reason)
+	d.reindex(stored, &old, nil)
+	if d.cache != nil {
+		d.cache.remove(old.meta)
+	}
+	d.unbind(stored)
+	return nil
+}
+
+// unbind 删除key的别名，调用方需持有写锁
+func (d *TypedDB[K, V]) unbind(stored K) {
+	if d.alias == nil {
+		return
+	}
+	name := d.alias(stored)
+	if k, ok := d.aliases[name]; ok && d.cmp(k, stored) == 0 {
+		delete(d.aliases, name)
+	}
+}
+
+// Count 统计满足所有查询条件的数据，不包括已过期的数据
+func (d *TypedDB[K, V]) Count(queries ...func(v V) bool) (int, error) {
+	if !d.life.enter() {
+		return 0, errors.ErrClosed
+	}
+	defer d.life.leave()
+	if len(queries) == 0 && !d.withExpired() {
+		return d.data.Len(), nil
+	}
+	var count int
+	iter := d.Iterator()
+	for iter.HasNext() {
+		v := iter.Value()
+		if any(v) == nil {
+			continue
+		}
+		if !match(v, queries) {
+			continue
+		}
+		count++
+	}
+	iter.Close()
+	return count, nil
+}
+
+func match[V any](v V, queries []func(v V) bool) bool {
+	for _, q := range queries {
+		if !q(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Iterator 按key的顺序遍历，跳过创建时已过期的数据，db关闭后返回空的迭代器
+func (d *TypedDB[K, V]) Iterator() skiplist.Iterator[K, V] {
+	if !d.life.enter() {
+		return emptyIterator[K, V]{}
+	}
+	defer d.life.leave()
+	return &iterator[K, V]{
+		db:   d,
+		iter: d.data.Iterator(),
+		now:  d.now(),
+	}
+}
+
+// List 分页返回满足所有查询函数的数据，page从1开始，没有查询函数且所有数据都可见时通过跳表的跨度直接定位到该页
+func (d *TypedDB[K, V]) List(page, pageSize int32, queries ...func(v V) bool) ([]V, bool, error) {
+	if !d.life.enter() {
+		return nil, false, errors.ErrClosed
+	}
+	defer d.life.leave()
+	var (
+		iter        skiplist.Iterator[K, V]
+		ok          bool
+		offset      = (page - 1) * pageSize
+		end         = offset + pageSize
+		count       int32
+		ret         = make([]V, 0, pageSize)
+		hasNextPage bool
+		valid       bool
+	)
+	if len(queries) == 0 && offset > 0 {
+		iter, ok = d.rankIterator(int(offset))
+	}
+	if ok {
+		count, valid = offset, iter.Valid()
+	} else {
+		iter = d.Iterator()
+		valid = iter.HasNext()
+	}
+
+	// 有查询函数时最坏情况是全表扫描
+	for ; valid; valid = iter.HasNext() {
+		if hasNextPage {
+			break
+		}
+		v := iter.Value()
+		if any(v) == nil {
+			continue
+		}
+		if !match(v, queries) {
+			continue
+		}
+		if count >= end {
+			hasNextPage = true
+			continue
+		}
+		if offset <= count {
+			ret = append(ret, v)
+		}
+		count++
+	}
+	iter.Close()
+	return ret, hasNextPage, nil
+}
+
+// iterator 遍历跳表时去掉过期时间，只返回value，支持定位和反向遍历，
+// 在now时已过期的数据会被跳过
+type iterator[K, V any] struct {
+	db   *TypedDB[K, V]
+	iter skiplist.Iterator[K, item[V]]
+	now  int64
+}
+
+// skip 沿step的方向跳过已过期的数据
+func (i *iterator[K, V]) skip(valid bool, step func() bool) bool {
+	for valid && i.db.expired(i.iter.Value(), i.now) {
+		valid = step()
+	}
+	return valid
+}
+
+func (i *iterator[K, V]) HasNext() bool {
+	return i.skip(i.iter.HasNext(), i.iter.HasNext)
+}
+
+func (i *iterator[K, V]) Prev() bool {
+	return i.skip(i.iter.Prev(), i.iter.Prev)
+}
+
+func (i *iterator[K, V]) Seek(key K) {
+	i.iter.Seek(key)
+	i.skip(i.iter.Valid(), i.iter.HasNext)
+}
+
+func (i *iterator[K, V]) SeekToFirst() {
+	i.iter.SeekToFirst()
+	i.skip(i.iter.Valid(), i.iter.HasNext)
+}
+
+func (i *iterator[K, V]) SeekToLast() {
+	i.iter.SeekToLast()
+	i.skip(i.iter.Valid(), i.iter.Prev)
+}
+
+func (i *iterator[K, V]) Valid() bool {
+	return i.iter.Valid()
+}
+
+func (i *iterator[K, V]) Key() K {
+	return i.iter.Key()
+}
+
+func (i *iterator[K, V]) Value() V {
+	return i.iter.Value().value
+}
+
+func (i *iterator[K, V]) Close() {
+	i.iter.Close()
+}
@@ -0,0 +1,115 @@
+package simpledb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func TestTypedDB_SaveGetDelete(t *testing.T) {
+	db := NewTypedDB[string, int](strings.Compare)
+	if err := db.Save("1", 1); err != nil {
+		t.Errorf("save failed, err: %+v", err)
+		return
+	}
+	_ = db.Save("1", 2)
+	v, err := db.Get("1")
+	if err != nil || v != 2 {
+		t.Errorf("get failed, v(%d) should be 2, err: %+v", v, err)
+		return
+	}
+	if err = db.Delete("1"); err != nil {
+		t.Errorf("delete failed, err: %+v", err)
+		return
+	}
+	if v, err = db.Get("1"); err != errors.ErrNotFound || v != 0 {
+		t.Errorf("get failed, err should be ErrNotFound and v should be zero")
+	}
+}
+
+func TestTypedDB_ListAndIterator(t *testing.T) {
+	type pair struct {
+		seq  int
+		name string
+	}
+	db := NewTypedDB[pair, string](func(a, b pair) int {
+		return a.seq - b.seq
+	})
+	for _, p := range []pair{{3, "c"}, {1, "a"}, {2, "b"}, {4, "d"}} {
+		_ = db.Save(p, p.name)
+	}
+	out, hasNextPage, err := db.List(1, 2, func(v string) bool {
+		return v != "a"
+	})
+	if err != nil || !hasNextPage || len(out) != 2 || out[0] != "b" || out[1] != "c" {
+		t.Errorf("list failed, out(%+v) hasNextPage(%v) err: %+v", out, hasNextPage, err)
+		return
+	}
+	iter := db.Iterator()
+	var seqs []int
+	for iter.HasNext() {
+		seqs = append(seqs, iter.Key().seq)
+	}
+	iter.Close()
+	if len(seqs) != 4 || seqs[0] != 1 || seqs[3] != 4 {
+		t.Errorf("iterate failed, seqs(%+v) should be in order", seqs)
+	}
+}
+
+func TestTypedDB_Persist(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	db, err := OpenTypedDB[string, int](strings.Compare, DBOptionWithWAL(dir))
+	if err != nil {
+		t.Errorf("open failed, err: %+v", err)
+		return
+	}
+	for i, k := range []string{"a", "b", "c"} {
+		_ = db.Save(k, i)
+	}
+	_ = db.Delete("b")
+	var buf bytes.Buffer
+	if err = db.SnapshotTo(&buf); err != nil {
+		t.Errorf("snapshot failed, err: %+v", err)
+		return
+	}
+	_ = db.Close()
+
+	db = NewTypedDB[string, int](strings.Compare, DBOptionWithWAL(dir))
+	defer db.Close()
+	if v, err := db.Get("c"); err != nil || v != 2 {
+		t.Errorf("reopen failed, v(%d) should be 2, err: %+v", v, err)
+		return
+	}
+	loaded, err := LoadTypedSnapshot[string, int](&buf, strings.Compare)
+	if err != nil {
+		t.Errorf("load failed, err: %+v", err)
+		return
+	}
+	if n, _ := loaded.Count(); n != 2 {
+		t.Errorf("load failed, count(%d) should be 2", n)
+	}
+}
+
+func TestTypedDB_CountSkipNil(t *testing.T) {
+	db := NewTypedDB[string, interface{}](strings.Compare, DBOptionWithExpired())
+	_ = db.Save("a", 1)
+	_ = db.Save("b", nil)
+	if n, err := db.Count(); err != nil || n != 1 {
+		t.Errorf("count failed, n(%d) should be 1, err: %+v", n, err)
+		return
+	}
+	n, err := db.Count(func(v interface{}) bool {
+		return v.(int) == 1
+	})
+	if err != nil || n != 1 {
+		t.Errorf("count failed, n(%d) should be 1, err: %+v", n, err)
+		return
+	}
+	out, _, _ := db.List(1, 10)
+	if len(out) != n {
+		t.Errorf("list failed, len(%d) should equal count(%d)", len(out), n)
+	}
+}
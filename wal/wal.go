@@ -0,0 +1,468 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+const (
+	// headerSize 每条记录的头部: crc32(4字节) + 负载长度(4字节)
+	headerSize = 8
+	// maxRecordSize 单条记录负载的长度上限，回放时超出的长度视为损坏
+	maxRecordSize = 1 << 30
+)
+
+type Op byte
+
+const (
+	OpSave Op = iota + 1
+	OpDelete
+	// opBatch 一组原子写入的记录，只在日志内部使用
+	opBatch
+	// OpExpire 只修改过期时间，不带value
+	OpExpire
+)
+
+type SyncPolicy byte
+
+const (
+	// SyncEvery 每隔固定时间刷盘一次，默认策略
+	SyncEvery SyncPolicy = iota
+	// SyncAlways 每次追加都刷盘
+	SyncAlways
+	// SyncNever 从不主动刷盘，交给操作系统
+	SyncNever
+)
+
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+// Record 日志中的一条记录，ExpireAt为0表示不过期
+type Record struct {
+	Op       Op
+	ExpireAt int64
+	Key      []byte
+	Value    []byte
+}
+
+// Log 只追加的预写日志，每条记录都带有校验和
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+	opts Options
+	stop chan struct{}
+	done chan struct{}
+	// 重写期间新追加的记录，重写完成时补到新日志的末尾
+	rewriting bool
+	pending   []byte
+	// failed 写入失败后无法截断不完整的记录，之后的写入都返回该错误
+	failed error
+}
+
+// Open 打开日志文件并按顺序回放其中的记录，
+// 尾部不完整或校验失败的记录会被截断，而不是让整个打开失败，中间的记录损坏时返回ErrCorruptRecord
+func Open(path string, opts Options, replay func(r Record) error) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := load(file, replay)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	l := &Log{
+		path: path,
+		file: file,
+		size: size,
+		opts: opts,
+	}
+	if opts.Sync == SyncEvery {
+		if l.opts.SyncInterval <= 0 {
+			l.opts.SyncInterval = time.Second
+		}
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.background()
+	}
+	return l, nil
+}
+
+// load 回放日志，返回有效数据的长度
+func load(file *os.File, replay func(r Record) error) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		r      = bufio.NewReader(file)
+		header = make([]byte, headerSize)
+		offset int64
+	)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			break
+		}
+		length := binary.LittleEndian.Uint32(header[4:])
+		// 先校验长度再分配，损坏的长度按尾部不完整处理
+		if length > maxRecordSize || int64(length) > info.Size()-offset-headerSize {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			break
+		}
+		var (
+			end  = offset + headerSize + int64(length)
+			recs []Record
+		)
+		// 校验通过后再解码，避免按损坏的数据分配内存
+		err = errors.ErrCorruptRecord
+		if crc32.ChecksumIEEE(payload) == binary.LittleEndian.Uint32(header) {
+			recs, err = decode(payload)
+		}
+		if err != nil {
+			// 只有最后一条记录可能是写到一半的，之后还有数据说明日志中间损坏，截断会丢掉之后的记录
+			if end < info.Size() {
+				return 0, errors.ErrCorruptRecord
+			}
+			break
+		}
+		for _, rec := range recs {
+			if err = replay(rec); err != nil {
+				return 0, err
+			}
+		}
+		offset = end
+	}
+	// 尾部记录损坏，截断
+	if err := file.Truncate(offset); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+func encode(r Record) []byte {
+	buf := make([]byte, headerSize, headerSize+1+2*binary.MaxVarintLen64+len(r.Key)+len(r.Value))
+	return frame(appendRecord(buf, r))
+}
+
+// encodeBatch 将多条记录编码为一条，校验和覆盖所有记录，回放时要么全部生效要么全部丢弃
+func encodeBatch(records []Record) []byte {
+	var (
+		buf = make([]byte, headerSize, headerSize+1+binary.MaxVarintLen64)
+		tmp []byte
+	)
+	buf = append(buf, byte(opBatch))
+	buf = binary.AppendUvarint(buf, uint64(len(records)))
+	for _, r := range records {
+		tmp = appendRecord(tmp[:0], r)
+		buf = binary.AppendUvarint(buf, uint64(len(tmp)))
+		buf = append(buf, tmp...)
+	}
+	return frame(buf)
+}
+
+// appendRecord 追加一条记录的负载: op + 过期时间 + key长度 + key + value
+func appendRecord(buf []byte, r Record) []byte {
+	buf = append(buf, byte(r.Op))
+	buf = binary.AppendVarint(buf, r.ExpireAt)
+	buf = binary.AppendUvarint(buf, uint64(len(r.Key)))
+	buf = append(buf, r.Key...)
+	return append(buf, r.Value...)
+}
+
+// frame 填写头部的校验和与负载长度，buf的前headerSize个字节为头部
+func frame(buf []byte) []byte {
+	payload := buf[headerSize:]
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(payload)))
+	return buf
+}
+
+func decode(payload []byte) ([]Record, error) {
+	if len(payload) == 0 || Op(payload[0]) != opBatch {
+		rec, err := decodeRecord(payload)
+		return []Record{rec}, err
+	}
+	count, n := binary.Uvarint(payload[1:])
+	if n <= 0 {
+		return nil, errors.ErrCorruptRecord
+	}
+	payload = payload[1+n:]
+	recs := make([]Record, 0, count)
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < length {
+			return nil, errors.ErrCorruptRecord
+		}
+		rec, err := decodeRecord(payload[n : n+int(length)])
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+		payload = payload[n+int(length):]
+	}
+	return recs, nil
+}
+
+func decodeRecord(payload []byte) (Record, error) {
+	var rec Record
+	if len(payload) == 0 {
+		return rec, errors.ErrCorruptRecord
+	}
+	rec.Op = Op(payload[0])
+	if rec.Op != OpSave && rec.Op != OpDelete && rec.Op != OpExpire {
+		return rec, errors.ErrCorruptRecord
+	}
+	payload = payload[1:]
+	expireAt, n := binary.Varint(payload)
+	if n <= 0 {
+		return rec, errors.ErrCorruptRecord
+	}
+	rec.ExpireAt = expireAt
+	payload = payload[n:]
+	keyLen, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) < keyLen {
+		return rec, errors.ErrCorruptRecord
+	}
+	payload = payload[n:]
+	rec.Key = payload[:keyLen]
+	rec.Value = payload[keyLen:]
+	return rec, nil
+}
+
+// Append 追加一条记录，是否立即刷盘取决于同步策略
+func (l *Log) Append(r Record) error {
+	return l.write(encode(r))
+}
+
+// AppendBatch 原子地追加一组记录，回放时要么全部可见要么全部不可见
+func (l *Log) AppendBatch(records []Record) error {
+	return l.write(encodeBatch(records))
+}
+
+func (l *Log) write(buf []byte) error {
+	if len(buf)-headerSize > maxRecordSize {
+		return errors.ErrRecordTooLarge
+	}
+	l.mu.Lock()
+	if l.file == nil {
+		l.mu.Unlock()
+		return errors.ErrLogClosed
+	}
+	if l.failed != nil {
+		l.mu.Unlock()
+		return l.failed
+	}
+	n, err := l.file.Write(buf)
+	if err != nil {
+		// 截断写了一部分的记录，否则之后的记录会跟在损坏的记录后面
+		if n > 0 {
+			l.rollback(err)
+		}
+		l.mu.Unlock()
+		return err
+	}
+	l.size += int64(n)
+	if l.opts.Sync == SyncAlways {
+		err = l.file.Sync()
+	}
+	if err == nil && l.rewriting {
+		l.pending = append(l.pending, buf...)
+	}
+	l.mu.Unlock()
+	return err
+}
+
+// rollback 截断到写入前的大小，失败时标记日志不可用，调用方需持有锁
+func (l *Log) rollback(cause error) {
+	if err := l.file.Truncate(l.size); err != nil {
+		l.failed = cause
+		return
+	}
+	if _, err := l.file.Seek(l.size, io.SeekStart); err != nil {
+		l.failed = cause
+	}
+}
+
+// Size 当前日志文件的大小
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	size := l.size
+	l.mu.Unlock()
+	return size
+}
+
+// Sync 将日志刷到磁盘
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errors.ErrLogClosed
+	}
+	return l.file.Sync()
+}
+
+// Close 刷盘并关闭日志
+func (l *Log) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Sync()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
+
+func (l *Log) background() {
+	t := time.NewTicker(l.opts.SyncInterval)
+	defer func() {
+		t.Stop()
+		close(l.done)
+	}()
+	for {
+		select {
+		case <-t.C:
+			_ = l.Sync()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Rewriter 用于重写日志，重写期间原日志照常追加，
+// 提交时把期间追加的记录补到新日志的末尾，再原子地替换原日志
+type Rewriter struct {
+	l    *Log
+	file *os.File
+	path string
+}
+
+// StartRewrite 开始重写日志，同一时刻只能有一个重写
+func (l *Log) StartRewrite() (*Rewriter, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil, errors.ErrLogClosed
+	}
+	if l.rewriting {
+		return nil, errors.ErrRewriting
+	}
+	path := l.path + ".rewrite"
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.rewriting = true
+	l.pending = nil
+	return &Rewriter{
+		l:    l,
+		file: file,
+		path: path,
+	}, nil
+}
+
+// Append 向新日志追加一条记录
+func (r *Rewriter) Append(rec Record) error {
+	_, err := r.file.Write(encode(rec))
+	return err
+}
+
+// Commit 补上重写期间追加的记录，并用新日志替换原日志
+func (r *Rewriter) Commit() error {
+	// 先在锁外刷盘，缩短持锁时间
+	if err := r.file.Sync(); err != nil {
+		r.Abort()
+		return err
+	}
+	l := r.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		r.abort()
+		return errors.ErrLogClosed
+	}
+	if err := r.commit(); err != nil {
+		r.abort()
+		return err
+	}
+	_ = l.file.Close()
+	l.file = r.file
+	l.rewriting = false
+	l.pending = nil
+	return syncDir(filepath.Dir(l.path))
+}
+
+func (r *Rewriter) commit() error {
+	if _, err := r.file.Write(r.l.pending); err != nil {
+		return err
+	}
+	if err := r.file.Sync(); err != nil {
+		return err
+	}
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(r.path, r.l.path); err != nil {
+		return err
+	}
+	r.l.size = info.Size()
+	return nil
+}
+
+// Abort 放弃重写
+func (r *Rewriter) Abort() {
+	r.l.mu.Lock()
+	r.abort()
+	r.l.mu.Unlock()
+}
+
+func (r *Rewriter) abort() {
+	_ = r.file.Close()
+	_ = os.Remove(r.path)
+	r.l.rewriting = false
+	r.l.pending = nil
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
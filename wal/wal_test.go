@@ -0,0 +1,238 @@
+package wal
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/byronzhu-haha/simpledb/errors"
+)
+
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %+v", err)
+	}
+	return filepath.Join(dir, "test.wal"), func() { _ = os.RemoveAll(dir) }
+}
+
+func readAll(t *testing.T, path string) []Record {
+	var out []Record
+	l, err := Open(path, Options{Sync: SyncNever}, func(r Record) error {
+		out = append(out, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("open failed, err: %+v", err)
+	}
+	_ = l.Close()
+	return out
+}
+
+func TestLog_Replay(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, err := Open(path, Options{Sync: SyncAlways}, func(r Record) error { return nil })
+	if err != nil {
+		t.Errorf("open failed, err: %+v", err)
+		return
+	}
+	_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte("a"), ExpireAt: 100})
+	_ = l.Append(Record{Op: OpDelete, Key: []byte("1")})
+	_ = l.Close()
+
+	out := readAll(t, path)
+	if len(out) != 2 {
+		t.Errorf("replay failed, len of records(%d) should be 2", len(out))
+		return
+	}
+	if out[0].Op != OpSave || string(out[0].Key) != "1" || string(out[0].Value) != "a" || out[0].ExpireAt != 100 {
+		t.Errorf("replay failed, unexpected record: %+v", out[0])
+		return
+	}
+	if out[1].Op != OpDelete || string(out[1].Key) != "1" {
+		t.Errorf("replay failed, unexpected record: %+v", out[1])
+	}
+}
+
+func TestLog_TruncateTornTail(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, _ := Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil })
+	_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte("a")})
+	_ = l.Append(Record{Op: OpSave, Key: []byte("2"), Value: []byte("b")})
+	size := l.Size()
+	_ = l.Close()
+
+	// 模拟写到一半崩溃
+	_ = os.Truncate(path, size-1)
+	out := readAll(t, path)
+	if len(out) != 1 {
+		t.Errorf("truncate failed, len of records(%d) should be 1", len(out))
+		return
+	}
+	info, _ := os.Stat(path)
+	if info.Size() != size-int64(len(encode(Record{Op: OpSave, Key: []byte("2"), Value: []byte("b")}))) {
+		t.Errorf("truncate failed, torn record should be removed, size: %d", info.Size())
+	}
+}
+
+func TestLog_TruncateCorruptTail(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, _ := Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil })
+	_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte("a")})
+	_ = l.Append(Record{Op: OpSave, Key: []byte("2"), Value: []byte("b")})
+	size := l.Size()
+	_ = l.Close()
+
+	// 篡改最后一个字节，校验和不再匹配
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	_, _ = f.WriteAt([]byte{'c'}, size-1)
+	_ = f.Close()
+
+	out := readAll(t, path)
+	if len(out) != 1 || string(out[0].Key) != "1" {
+		t.Errorf("truncate failed, only the first record should be left, out: %+v", out)
+		return
+	}
+
+	// 截断后可以继续追加
+	l, _ = Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil })
+	_ = l.Append(Record{Op: OpDelete, Key: []byte("1")})
+	_ = l.Close()
+	if out = readAll(t, path); len(out) != 2 {
+		t.Errorf("append failed, len of records(%d) should be 2", len(out))
+	}
+}
+
+func TestLog_TruncateCorruptLength(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, _ := Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil })
+	_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte("a")})
+	size := l.Size()
+	_ = l.Append(Record{Op: OpSave, Key: []byte("2"), Value: []byte("b")})
+	_ = l.Close()
+
+	// 长度被改为远大于文件剩余的字节数，不应按该长度分配内存
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	_, _ = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, size+4)
+	_ = f.Close()
+
+	out := readAll(t, path)
+	if len(out) != 1 || string(out[0].Key) != "1" {
+		t.Errorf("truncate failed, only the first record should be left, out: %+v", out)
+		return
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Errorf("truncate failed, size(%d) should be %d", info.Size(), size)
+	}
+}
+
+func TestLog_CorruptMiddle(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, _ := Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil })
+	_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte("a")})
+	size := l.Size()
+	_ = l.Append(Record{Op: OpSave, Key: []byte("2"), Value: []byte("b")})
+	_ = l.Close()
+
+	// 篡改第一条记录的最后一个字节，之后还有完整的记录，不能截断
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	_, _ = f.WriteAt([]byte{'c'}, size-1)
+	_ = f.Close()
+	info, _ := os.Stat(path)
+
+	if _, err := Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil }); err != errors.ErrCorruptRecord {
+		t.Errorf("open failed, err(%+v) should be ErrCorruptRecord", err)
+		return
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Errorf("open failed, size(%d) should be %d, log should not be truncated", after.Size(), info.Size())
+	}
+}
+
+func TestLog_RollbackPartialWrite(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, _ := Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil })
+	_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte("a")})
+	// 模拟只写入了一部分的记录
+	buf := encode(Record{Op: OpSave, Key: []byte("2"), Value: []byte("b")})
+	_, _ = l.file.Write(buf[:len(buf)/2])
+	l.mu.Lock()
+	l.rollback(io.ErrShortWrite)
+	l.mu.Unlock()
+	_ = l.Append(Record{Op: OpSave, Key: []byte("3"), Value: []byte("c")})
+	_ = l.Close()
+
+	out := readAll(t, path)
+	if len(out) != 2 || string(out[0].Key) != "1" || string(out[1].Key) != "3" {
+		t.Errorf("rollback failed, records should be 1 and 3, out: %+v", out)
+	}
+}
+
+func TestLog_Rewrite(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, _ := Open(path, Options{Sync: SyncNever}, func(r Record) error { return nil })
+	for i := 0; i < 10; i++ {
+		_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte{byte(i)}})
+	}
+	rw, err := l.StartRewrite()
+	if err != nil {
+		t.Errorf("rewrite failed, err: %+v", err)
+		return
+	}
+	if _, err = l.StartRewrite(); err == nil {
+		t.Errorf("rewrite failed, only one rewrite is allowed at a time")
+		return
+	}
+	_ = rw.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte{9}})
+	// 重写期间的追加
+	_ = l.Append(Record{Op: OpSave, Key: []byte("2"), Value: []byte{1}})
+	if err = rw.Commit(); err != nil {
+		t.Errorf("rewrite failed, err: %+v", err)
+		return
+	}
+	_ = l.Append(Record{Op: OpDelete, Key: []byte("2")})
+	_ = l.Close()
+
+	out := readAll(t, path)
+	if len(out) != 3 || string(out[1].Key) != "2" || out[2].Op != OpDelete {
+		t.Errorf("rewrite failed, unexpected records: %+v", out)
+	}
+}
+
+func TestLog_AppendBatch(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+	l, err := Open(path, Options{Sync: SyncAlways}, nil)
+	if err != nil {
+		t.Errorf("open failed, err: %+v", err)
+		return
+	}
+	_ = l.Append(Record{Op: OpSave, Key: []byte("1"), Value: []byte("a")})
+	_ = l.AppendBatch([]Record{
+		{Op: OpSave, Key: []byte("2"), Value: []byte("b"), ExpireAt: 10},
+		{Op: OpDelete, Key: []byte("1")},
+	})
+	_ = l.Close()
+
+	recs := readAll(t, path)
+	if len(recs) != 3 || string(recs[1].Key) != "2" || recs[1].ExpireAt != 10 || recs[2].Op != OpDelete {
+		t.Errorf("replay failed, records(%+v) should be 3", recs)
+		return
+	}
+
+	// 截断的批量记录整体丢弃
+	info, _ := os.Stat(path)
+	_ = os.Truncate(path, info.Size()-1)
+	if recs = readAll(t, path); len(recs) != 1 {
+		t.Errorf("replay failed, len(%d) should be 1", len(recs))
+	}
+}